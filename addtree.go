@@ -0,0 +1,218 @@
+//go:build !purego
+
+package storm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+)
+
+// Options for adding a directory tree to an archive.
+type AddTreeOptions struct {
+	Prefix          string            // Directory in the archive the tree is added to, using backslashes (the root if empty)
+	Include         []string          // Patterns of the files to add, as for path.Match (all files if empty)
+	Exclude         []string          // Patterns of the files to leave out, as for path.Match
+	Rules           []CompressionRule // Flags and compression by file extension, the first match wins
+	Flags           uint32            // MPQ_FILE_* flags of files not matching a rule, MPQ_FILE_COMPRESS is added unless Store is set
+	Compression     uint32            // Compression of files not matching a rule (MPQ_COMPRESSION_ZLIB if 0)
+	Store           bool              // Store files not matching a rule without compression
+	Locale          Locale            // Locale of the added files
+	ReplaceExisting bool              // Replace files that exist in the archive instead of skipping them
+}
+
+// Flags and compression for the files with a given extension.
+type CompressionRule struct {
+	Extension   string // File extension including the dot, such as ".wav", matched case-insensitively
+	Flags       uint32 // MPQ_FILE_* flags of the files, MPQ_FILE_COMPRESS is required for compression
+	Compression uint32 // Compression of the files, see MPQ_COMPRESSION_*
+}
+
+// Report of adding a directory tree to an archive.
+type AddTreeReport struct {
+	Added   int   // Number of files added
+	Skipped int   // Number of files skipped because they exist in the archive
+	Bytes   int64 // Number of bytes added, before compression
+}
+
+// Adds the files of a directory on the local drive to the archive, see AddTreeFS.
+func (a *Archive) AddTree(ctx context.Context, srcDir string, options AddTreeOptions) (*AddTreeReport, error) {
+	return a.AddTreeFS(ctx, os.DirFS(srcDir), options)
+}
+
+// Adds the files of a file system to the archive, naming them by their path below the prefix.
+//
+// Patterns without a slash are matched against the base name of a file, others against its whole path. The file limit
+// of the archive is raised as needed, and the archive is flushed at the end so that the (listfile) and (attributes) are
+// updated. A malformed pattern fails with path.ErrBadPattern before anything is added. On an error or if the context is
+// canceled, the files added so far are kept and the partial report is returned.
+func (a *Archive) AddTreeFS(ctx context.Context, fsys fs.FS, options AddTreeOptions) (*AddTreeReport, error) {
+	var report AddTreeReport
+	var names []string
+
+	err := options.checkPatterns()
+	if err != nil {
+		return nil, err
+	}
+
+	if options.Store {
+		options.Flags &^= MPQ_FILE_COMPRESS
+	} else {
+		options.Flags |= MPQ_FILE_COMPRESS
+	}
+	if options.Compression == 0 {
+		options.Compression = MPQ_COMPRESSION_ZLIB
+	}
+
+	err = fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() && options.includes(name) {
+			names = append(names, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = a.reserveFiles(uint32(len(names)))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range names {
+		err = ctx.Err()
+		if err != nil {
+			break
+		}
+
+		var size int64
+		size, err = a.addTreeFile(fsys, name, &options)
+		if errors.Is(err, ErrAlreadyExists) {
+			report.Skipped++
+			continue
+		}
+		if err != nil {
+			break
+		}
+		report.Added++
+		report.Bytes += size
+	}
+
+	flushErr := a.SFileFlushArchive()
+	if err == nil {
+		err = flushErr
+	}
+	if err != nil {
+		return &report, err
+	}
+
+	return &report, nil
+}
+
+// Checks that the include and exclude patterns are well-formed, so that a malformed pattern fails even if no file is
+// matched against it.
+func (options *AddTreeOptions) checkPatterns() error {
+	for _, patterns := range [][]string{options.Include, options.Exclude} {
+		for _, pattern := range patterns {
+			_, err := path.Match(pattern, "")
+			if err != nil {
+				return fmt.Errorf("%w (%q)", err, pattern)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Reports whether a file matches the include and exclude patterns.
+func (options *AddTreeOptions) includes(name string) bool {
+	match := func(patterns []string) bool {
+		for _, pattern := range patterns {
+			subject := name
+			if !strings.Contains(pattern, "/") {
+				subject = path.Base(name)
+			}
+			matched, _ := path.Match(pattern, subject)
+			if matched {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(options.Include) > 0 && !match(options.Include) {
+		return false
+	}
+
+	return !match(options.Exclude)
+}
+
+// Raises the file limit of the archive so that count more files fit.
+func (a *Archive) reserveFiles(count uint32) error {
+	info, err := a.Info()
+	if err != nil {
+		return err
+	}
+
+	// Leave room for the (listfile), (attributes) and (signature)
+	needed := info.NumberOfFiles + count + 3
+	if needed <= info.MaxFileCount {
+		return nil
+	}
+
+	return a.SFileSetMaxFileCount(needed)
+}
+
+// Adds a single file of the tree, returning its size.
+func (a *Archive) addTreeFile(fsys fs.FS, name string, options *AddTreeOptions) (int64, error) {
+	flags := options.Flags
+	compression := options.Compression
+	for _, rule := range options.Rules {
+		if strings.EqualFold(path.Ext(name), rule.Extension) {
+			flags = rule.Flags
+			compression = rule.Compression
+			break
+		}
+	}
+	if options.ReplaceExisting {
+		flags |= MPQ_FILE_REPLACEEXISTING
+	}
+
+	archivedName := strings.ReplaceAll(name, "/", "\\")
+	if options.Prefix != "" {
+		archivedName = strings.TrimSuffix(options.Prefix, "\\") + "\\" + archivedName
+	}
+
+	file, err := fsys.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if stat.Size() > int64(^uint32(0)) {
+		return 0, &StormError{Code: ERROR_NOT_SUPPORTED, Op: "add", Path: name, Message: "file larger than 4 GiB"}
+	}
+
+	err = a.CreateFromReader(archivedName, file, uint32(stat.Size()), WriteOptions{
+		FileTime:    timeToFileTime(stat.ModTime()),
+		Locale:      options.Locale,
+		Flags:       flags,
+		Compression: compression,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return stat.Size(), nil
+}
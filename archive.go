@@ -1,114 +1,314 @@
-// Go bindings for StormLib, a library for manipulating MPQ archives.
+//go:build !purego
+
 package storm
 
 // #cgo CFLAGS: -I${SRCDIR}/StormLib/src/
+// #cgo CXXFLAGS: -I${SRCDIR}/StormLib/src/
 // #cgo linux,386     LDFLAGS: -lstorm -lz -lbz2           -L${SRCDIR}/StormLib/bin/linux/386/
 // #cgo linux,amd64   LDFLAGS: -lstorm -lz -lbz2           -L${SRCDIR}/StormLib/bin/linux/amd64/
 // #cgo windows,386   LDFLAGS: -lstorm -lz -lbz2 -lwininet -L${SRCDIR}/StormLib/bin/windows/386/
 // #cgo windows,amd64 LDFLAGS: -lstorm -lz -lbz2 -lwininet -L${SRCDIR}/StormLib/bin/windows/amd64/
-// #include <StormLib.h>
+// #include "lasterror.h"
 import "C"
 
 import (
+	"runtime"
+	"runtime/cgo"
+	"sync"
 	"unsafe"
 )
 
+// An open MPQ archive.
+//
+// An Archive may be used from multiple goroutines. Every call into StormLib on the archive, or on a file or search opened
+// from it, holds a lock on the archive, so calls never overlap and closing the archive never races with other calls.
+// Operations made of several calls, such as writing a file or iterating over a search, are not atomic; only FileReader
+// guards its file pointer for concurrent use. For reads that do not wait for each other on the archive lock, see Pool.
+//
+// Closing the archive also releases the files and searches still open from it; later calls on them fail with
+// fs.ErrClosed.
 type Archive struct {
-	handle C.HANDLE
+	handle   C.HANDLE
+	name     string                      // Name of the archive file, used in errors
+	flags    uint32                      // Flags the archive was opened with, reused for the worker handles of ExtractAll
+	mutex    sync.Mutex                  // Serializes the use of the handle, see lock
+	children map[C.HANDLE]func(C.HANDLE) // Open file and search handles with the functions releasing them, see addChild
+
+	addFileCallback  cgo.Handle
+	compactCallback  cgo.Handle
+	downloadCallback cgo.Handle
 }
 
 // Opens a MPQ archive.
 func SFileOpenArchive(mpqName string, flags uint32) (*Archive, error) {
-	var a Archive
+	a := Archive{name: mpqName, flags: flags}
 
 	cMpqName := C.CString(mpqName)
 	defer C.free(unsafe.Pointer(cMpqName))
 
-	if C.SFileOpenArchive(cMpqName, 0, C.DWORD(flags), &a.handle) != 0 {
+	var errorCode C.DWORD
+	if C.shimSFileOpenArchive(cMpqName, 0, C.DWORD(flags), &a.handle, &errorCode) != 0 {
+		runtime.SetFinalizer(&a, (*Archive).finalize)
 		return &a, nil
 	}
 
-	return nil, newStormError(uint32(C.GetLastError()), "failed to open archive")
+	return nil, newPathError(uint32(errorCode), "open", mpqName)
 }
 
 // Creates a new MPQ archive.
 func SFileCreateArchive(mpqName string, createFlags uint32, maxFileCount uint32) (*Archive, error) {
-	var a Archive
+	a := Archive{name: mpqName}
+
+	cMpqName := C.CString(mpqName)
+	defer C.free(unsafe.Pointer(cMpqName))
+
+	var errorCode C.DWORD
+	if C.shimSFileCreateArchive(cMpqName, C.DWORD(createFlags), C.DWORD(maxFileCount), &a.handle, &errorCode) != 0 {
+		runtime.SetFinalizer(&a, (*Archive).finalize)
+		return &a, nil
+	}
+
+	return nil, newPathError(uint32(errorCode), "create", mpqName)
+}
+
+// Options for creating a new MPQ archive, mirroring SFILE_CREATE_MPQ.
+type CreateOptions struct {
+	MpqVersion   uint32 // Version of the MPQ, see MPQ_FORMAT_VERSION_*
+	StreamFlags  uint32 // Stream flags for creating the MPQ
+	FileFlags1   uint32 // File flags for (listfile), 0 for no (listfile). Use MPQ_FILE_DEFAULT_INTERNAL to set default flags
+	FileFlags2   uint32 // File flags for (attributes), 0 for no (attributes). Use MPQ_FILE_DEFAULT_INTERNAL to set default flags
+	FileFlags3   uint32 // File flags for (signature), 0 for no (signature). Use MPQ_FILE_DEFAULT_INTERNAL to set default flags
+	AttrFlags    uint32 // Flags for the (attributes) file, see MPQ_ATTRIBUTE_*. If 0, no attributes will be created
+	SectorSize   uint32 // Sector size for compressed files, a power of two of at least 512 (0 for the default of the version)
+	RawChunkSize uint32 // Size of raw data chunk for MD5, MPQ version 4 only (0 for the default of the version)
+	MaxFileCount uint32 // File limit for the MPQ
+}
+
+// Creates a new MPQ archive with the given options.
+func SFileCreateArchive2(mpqName string, options CreateOptions) (*Archive, error) {
+	a := Archive{name: mpqName}
+
+	err := options.validate(mpqName)
+	if err != nil {
+		return nil, err
+	}
+
+	createInfo := C.SFILE_CREATE_MPQ{
+		cbSize:         C.DWORD(unsafe.Sizeof(C.SFILE_CREATE_MPQ{})),
+		dwMpqVersion:   C.DWORD(options.MpqVersion),
+		dwStreamFlags:  C.DWORD(options.StreamFlags),
+		dwFileFlags1:   C.DWORD(options.FileFlags1),
+		dwFileFlags2:   C.DWORD(options.FileFlags2),
+		dwFileFlags3:   C.DWORD(options.FileFlags3),
+		dwAttrFlags:    C.DWORD(options.AttrFlags),
+		dwSectorSize:   C.DWORD(options.SectorSize),
+		dwRawChunkSize: C.DWORD(options.RawChunkSize),
+		dwMaxFileCount: C.DWORD(options.MaxFileCount),
+	}
 
 	cMpqName := C.CString(mpqName)
 	defer C.free(unsafe.Pointer(cMpqName))
 
-	if C.SFileCreateArchive(cMpqName, C.DWORD(createFlags), C.DWORD(maxFileCount), &a.handle) != 0 {
+	var errorCode C.DWORD
+	if C.shimSFileCreateArchive2(cMpqName, &createInfo, &a.handle, &errorCode) != 0 {
+		runtime.SetFinalizer(&a, (*Archive).finalize)
 		return &a, nil
 	}
 
-	return nil, newStormError(uint32(C.GetLastError()), "failed to create archive")
+	return nil, newPathError(uint32(errorCode), "create", mpqName)
+}
+
+// Fills in the defaults of the version and checks the options for creating the archive.
+func (options *CreateOptions) validate(mpqName string) error {
+	if options.MpqVersion > MPQ_FORMAT_VERSION_4 {
+		return newStormError(ERROR_INVALID_PARAMETER, "create", mpqName, "unsupported MPQ version")
+	}
+
+	if options.SectorSize == 0 {
+		options.SectorSize = 0x1000
+		if options.MpqVersion >= MPQ_FORMAT_VERSION_3 {
+			options.SectorSize = 0x4000
+		}
+	}
+	if options.SectorSize < 0x200 || options.SectorSize&(options.SectorSize-1) != 0 {
+		return newStormError(ERROR_INVALID_PARAMETER, "create", mpqName, "sector size must be a power of two of at least 512")
+	}
+
+	if options.MpqVersion >= MPQ_FORMAT_VERSION_4 && options.RawChunkSize == 0 {
+		options.RawChunkSize = 0x4000
+	}
+	if options.MpqVersion < MPQ_FORMAT_VERSION_4 && options.RawChunkSize != 0 {
+		return newStormError(ERROR_INVALID_PARAMETER, "create", mpqName, "raw chunk size requires MPQ version 4")
+	}
+
+	if options.AttrFlags&^MPQ_ATTRIBUTE_ALL != 0 {
+		return newStormError(ERROR_INVALID_PARAMETER, "create", mpqName, "unknown attribute flags")
+	}
+	if options.AttrFlags != 0 && options.FileFlags2 == 0 {
+		return newStormError(ERROR_INVALID_PARAMETER, "create", mpqName, "attribute flags require file flags for (attributes)")
+	}
+
+	return nil
 }
 
 // Adds another list file to the open archive in order to improve searching.
 func (a *Archive) SFileAddListFile(listFile string) error {
+	err := a.lock("add list file")
+	if err != nil {
+		return err
+	}
+	defer a.unlock()
+
 	cListFile := C.CString(listFile)
 	defer C.free(unsafe.Pointer(cListFile))
 
-	result := C.SFileAddListFile(a.handle, cListFile)
+	result := C.shimSFileAddListFile(a.handle, cListFile)
 
 	if result == C.ERROR_SUCCESS {
 		return nil
 	}
 
-	return newStormError(uint32(result), "failed to add list file")
+	return newPathError(uint32(result), "add list file", listFile)
 }
 
 // Changes default locale ID for adding new files.
 func SFileSetLocale(newLocale uint32) (locale uint32) {
-	locale = uint32(C.SFileSetLocale(C.LCID(newLocale)))
+	locale = uint32(C.shimSFileSetLocale(C.LCID(newLocale)))
 	return
 }
 
 // Returns current locale ID for adding new files.
 func SFileGetLocale() (locale uint32) {
-	locale = uint32(C.SFileGetLocale())
+	locale = uint32(C.shimSFileGetLocale())
 	return
 }
 
 // Flushes all unsaved data to the disk.
 func (a *Archive) SFileFlushArchive() error {
-	if C.SFileFlushArchive(a.handle) != 0 {
+	err := a.lock("flush")
+	if err != nil {
+		return err
+	}
+	defer a.unlock()
+
+	var errorCode C.DWORD
+	if C.shimSFileFlushArchive(a.handle, &errorCode) != 0 {
 		return nil
 	}
 
-	return newStormError(uint32(C.GetLastError()), "failed to flush archive")
+	return newPathError(uint32(errorCode), "flush", a.name)
 }
 
-// Closes an open archive.
+// Closes an open archive. The handle is released even if the close fails.
 func (a *Archive) SFileCloseArchive() error {
-	if C.SFileCloseArchive(a.handle) != 0 {
+	err := a.lock("close")
+	if err != nil {
+		return err
+	}
+	defer a.unlock()
+
+	// StormLib does not track the handles opened from the archive
+	for handle, release := range a.children {
+		release(handle)
+	}
+	a.children = nil
+
+	var errorCode C.DWORD
+	result := C.shimSFileCloseArchive(a.handle, &errorCode)
+
+	a.handle = nil
+	a.releaseCallbacks()
+	runtime.SetFinalizer(a, nil)
+
+	if result != 0 {
 		return nil
 	}
 
-	return newStormError(uint32(C.GetLastError()), "failed to close archive")
+	return newPathError(uint32(errorCode), "close", a.name)
+}
+
+// Implementation of the io.Closer interface.
+func (a *Archive) Close() error {
+	return a.SFileCloseArchive()
+}
+
+// Closes the archive if it was not closed before it became unreachable.
+func (a *Archive) finalize() {
+	if a.handle != nil {
+		reportLeak("archive", a.name)
+		a.SFileCloseArchive()
+	}
+}
+
+// Locks the archive for a call into StormLib, failing if the archive is closed.
+func (a *Archive) lock(op string) error {
+	return a.lockHandle(&a.handle, op, a.name)
+}
+
+// Registers a file or search handle opened from the archive, to be released with the archive if it is still open then.
+// Must be called with the archive locked.
+func (a *Archive) addChild(handle C.HANDLE, release func(handle C.HANDLE)) {
+	if a.children == nil {
+		a.children = make(map[C.HANDLE]func(C.HANDLE))
+	}
+	a.children[handle] = release
+}
+
+// Unregisters a handle released by its owner. Must be called with the archive locked.
+func (a *Archive) removeChild(handle C.HANDLE) {
+	delete(a.children, handle)
+}
+
+// Locks the archive for a call on one of its handles, failing if the handle or the archive is closed.
+func (a *Archive) lockHandle(handle *C.HANDLE, op string, name string) error {
+	a.mutex.Lock()
+	if *handle == nil || a.handle == nil {
+		a.mutex.Unlock()
+		return closedError(op, name)
+	}
+	return nil
 }
 
 // Changes the file limit for the archive.
 func (a *Archive) SFileSetMaxFileCount(maxFileCount uint32) error {
-	if C.SFileSetMaxFileCount(a.handle, C.DWORD(maxFileCount)) != 0 {
+	err := a.lock("set max file count")
+	if err != nil {
+		return err
+	}
+	defer a.unlock()
+
+	var errorCode C.DWORD
+	if C.shimSFileSetMaxFileCount(a.handle, C.DWORD(maxFileCount), &errorCode) != 0 {
 		return nil
 	}
 
-	return newStormError(uint32(C.GetLastError()), "failed to close archive")
+	return newPathError(uint32(errorCode), "set max file count", a.name)
 }
 
 // Setups the archive so that it becomes signed during archive close.
 func (a *Archive) SFileSignArchive(signatureType uint32) error {
-	if C.SFileSignArchive(a.handle, C.DWORD(signatureType)) != 0 {
+	err := a.lock("sign")
+	if err != nil {
+		return err
+	}
+	defer a.unlock()
+
+	var errorCode C.DWORD
+	if C.shimSFileSignArchive(a.handle, C.DWORD(signatureType), &errorCode) != 0 {
 		return nil
 	}
 
-	return newStormError(uint32(C.GetLastError()), "failed to set signature flag")
+	return newPathError(uint32(errorCode), "sign", a.name)
 }
 
 // Compacts (rebuilds) the archive, freeing all gaps that were created by write operations.
 func (a *Archive) SFileCompactArchive(listFile *string) error {
+	err := a.lock("compact")
+	if err != nil {
+		return err
+	}
+	defer a.unlock()
+
 	var cListFile = new(C.char)
 
 	if listFile != nil {
@@ -118,15 +318,22 @@ func (a *Archive) SFileCompactArchive(listFile *string) error {
 		cListFile = nil
 	}
 
-	if C.SFileCompactArchive(a.handle, cListFile, 0) != 0 {
+	var errorCode C.DWORD
+	if C.shimSFileCompactArchive(a.handle, cListFile, 0, &errorCode) != 0 {
 		return nil
 	}
 
-	return newStormError(uint32(C.GetLastError()), "failed to compact archive")
+	return newPathError(uint32(errorCode), "compact", a.name)
 }
 
 // Adds a patch archive for an existing open archive.
 func (a *Archive) SFileOpenPatchArchive(mpqName string, patchPathPrefix *string, flags uint32) error {
+	err := a.lock("open patch")
+	if err != nil {
+		return err
+	}
+	defer a.unlock()
+
 	var cPatchPathPrefix = new(C.char)
 
 	if patchPathPrefix != nil {
@@ -139,18 +346,27 @@ func (a *Archive) SFileOpenPatchArchive(mpqName string, patchPathPrefix *string,
 	cMpqName := C.CString(mpqName)
 	defer C.free(unsafe.Pointer(cMpqName))
 
-	if C.SFileOpenPatchArchive(a.handle, cMpqName, cPatchPathPrefix, C.DWORD(flags)) != 0 {
+	var errorCode C.DWORD
+	if C.shimSFileOpenPatchArchive(a.handle, cMpqName, cPatchPathPrefix, C.DWORD(flags), &errorCode) != 0 {
 		return nil
 	}
 
-	return newStormError(uint32(C.GetLastError()), "failed to open patch archive")
+	return newPathError(uint32(errorCode), "open patch", mpqName)
 }
 
-// Determines if the open MPQ has patches.
+// Determines if the open MPQ has patches. A closed archive has none; use IsPatched to tell it apart.
 func (a *Archive) SFileIsPatchedArchive() bool {
-	if C.SFileIsPatchedArchive(a.handle) != 0 {
-		return true
+	patched, _ := a.IsPatched()
+	return patched
+}
+
+// Works like SFileIsPatchedArchive, but fails with fs.ErrClosed if the archive is closed.
+func (a *Archive) IsPatched() (bool, error) {
+	err := a.lock("check patches")
+	if err != nil {
+		return false, err
 	}
+	defer a.unlock()
 
-	return false
+	return C.shimSFileIsPatchedArchive(a.handle) != 0, nil
 }
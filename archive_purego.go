@@ -0,0 +1,179 @@
+//go:build purego
+
+package storm
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/slyh/go-stormlib/internal/mpq"
+)
+
+// Masks of the stream and base providers in the flags of SFileOpenArchive.
+const (
+	baseProviderMask   = 0x0000000F
+	streamProviderMask = 0x000000F0
+)
+
+// An open MPQ archive, read without StormLib.
+//
+// An Archive may be used from multiple goroutines. Every call on the archive, or on a file or search opened from it,
+// holds a lock on the archive, so calls never overlap and closing the archive never races with other calls. Iterating
+// over a search is not atomic; only FileReader guards its file pointer for concurrent use.
+type Archive struct {
+	handle *mpq.Archive
+	file   *os.File   // Archive file, closed with the archive
+	name   string     // Name of the archive file, used in errors
+	flags  uint32     // Flags the archive was opened with, reused for the worker handles of ExtractAll
+	mutex  sync.Mutex // Serializes the use of the handle, see lock
+}
+
+// Opens a MPQ archive.
+//
+// Only flat archive files are supported, either mapped or read as files. The flags MPQ_OPEN_NO_LISTFILE,
+// MPQ_OPEN_NO_ATTRIBUTES, MPQ_OPEN_NO_HEADER_SEARCH and MPQ_OPEN_FORCE_MPQ_V1 are honored, while the other flags have no
+// effect as the archive is always opened read-only.
+func SFileOpenArchive(mpqName string, flags uint32) (*Archive, error) {
+	if flags&streamProviderMask != STREAM_PROVIDER_FLAT || flags&baseProviderMask == BASE_PROVIDER_HTTP {
+		return nil, newPathError(ERROR_NOT_SUPPORTED, "open", mpqName)
+	}
+
+	file, err := os.Open(mpqName)
+	if err != nil {
+		return nil, mpqError(err, "open", mpqName)
+	}
+
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, mpqError(err, "open", mpqName)
+	}
+
+	handle, err := mpq.Open(file, stat.Size(), mpq.Options{
+		NoListFile:     flags&MPQ_OPEN_NO_LISTFILE != 0,
+		NoAttributes:   flags&MPQ_OPEN_NO_ATTRIBUTES != 0,
+		NoHeaderSearch: flags&MPQ_OPEN_NO_HEADER_SEARCH != 0,
+		ForceV1:        flags&MPQ_OPEN_FORCE_MPQ_V1 != 0,
+	})
+	if err != nil {
+		file.Close()
+		return nil, mpqError(err, "open", mpqName)
+	}
+
+	a := Archive{handle: handle, file: file, name: mpqName, flags: flags}
+	runtime.SetFinalizer(&a, (*Archive).finalize)
+	return &a, nil
+}
+
+// Adds another list file to the open archive in order to improve searching.
+func (a *Archive) SFileAddListFile(listFile string) error {
+	err := a.lock("add list file")
+	if err != nil {
+		return err
+	}
+	defer a.unlock()
+
+	data, err := os.ReadFile(listFile)
+	if err != nil {
+		return mpqError(err, "add list file", listFile)
+	}
+
+	a.handle.AddListFile(data)
+	return nil
+}
+
+// Flushes the archive. Does nothing but check that the archive is open, as it is read-only.
+func (a *Archive) SFileFlushArchive() error {
+	err := a.lock("flush")
+	if err != nil {
+		return err
+	}
+	defer a.unlock()
+
+	return nil
+}
+
+// Closes an open archive. The handle is released even if the close fails.
+func (a *Archive) SFileCloseArchive() error {
+	err := a.lock("close")
+	if err != nil {
+		return err
+	}
+	defer a.unlock()
+
+	err = a.file.Close()
+
+	a.handle = nil
+	a.file = nil
+	runtime.SetFinalizer(a, nil)
+
+	if err != nil {
+		return mpqError(err, "close", a.name)
+	}
+
+	return nil
+}
+
+// Implementation of the io.Closer interface.
+func (a *Archive) Close() error {
+	return a.SFileCloseArchive()
+}
+
+// Closes the archive if it was not closed before it became unreachable.
+func (a *Archive) finalize() {
+	if a.handle != nil {
+		reportLeak("archive", a.name)
+		a.SFileCloseArchive()
+	}
+}
+
+// Locks the archive for a call, failing if the archive is closed.
+func (a *Archive) lock(op string) error {
+	return lockHandle(a, &a.handle, op, a.name)
+}
+
+// Locks the archive for a call on one of its handles, failing if the handle or the archive is closed.
+func lockHandle[T any](a *Archive, handle **T, op string, name string) error {
+	a.mutex.Lock()
+	if *handle == nil || a.handle == nil {
+		a.mutex.Unlock()
+		return closedError(op, name)
+	}
+	return nil
+}
+
+// Error codes for the errors of the mpq package.
+var mpqCodes = map[error]uint32{
+	mpq.ErrNotFound:     ERROR_FILE_NOT_FOUND,
+	mpq.ErrBadFormat:    ERROR_BAD_FORMAT,
+	mpq.ErrCorrupt:      ERROR_FILE_CORRUPT,
+	mpq.ErrNotSupported: ERROR_NOT_SUPPORTED,
+}
+
+// Converts an error of the mpq package or the local drive to a StormError with the code StormLib would return. Other
+// errors are wrapped in a *fs.PathError.
+func mpqError(err error, op string, path string) error {
+	for target, code := range mpqCodes {
+		if errors.Is(err, target) {
+			return &StormError{
+				Code:    code,
+				Op:      op,
+				Path:    path,
+				Message: strings.TrimPrefix(err.Error(), "mpq: "),
+			}
+		}
+	}
+
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return newPathError(ERROR_FILE_NOT_FOUND, op, path)
+	case errors.Is(err, fs.ErrPermission):
+		return newPathError(ERROR_ACCESS_DENIED, op, path)
+	}
+
+	return &fs.PathError{Op: op, Path: path, Err: err}
+}
@@ -0,0 +1,208 @@
+//go:build !purego
+
+package storm
+
+// #include "lasterror.h"
+import "C"
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+	"unsafe"
+)
+
+// Contents of the (attributes) file.
+type Attributes struct {
+	Version uint32           // Format version, see MPQ_ATTRIBUTES_V1
+	Flags   uint32           // Attributes stored for each file, see MPQ_ATTRIBUTE_*
+	Entries []AttributeEntry // Entries for the files in the archive, in block table order
+}
+
+// Attributes of a single file, as stored in the (attributes) file.
+type AttributeEntry struct {
+	FileName   string   // Name of the file (empty if the block is not in use)
+	BlockIndex uint32   // Block table index for the file
+	CRC32      uint32   // CRC32 of the file (0 if not present)
+	FileTime   uint64   // File time (0 if not present)
+	MD5        [16]byte // MD5 of the file (zero if not present)
+	PatchBit   bool     // The file is a patch file
+}
+
+// Returns the file time of the entry, or the zero time if not present.
+func (e *AttributeEntry) Time() time.Time {
+	return fileTimeToTime(e.FileTime)
+}
+
+// Returns the attributes stored in the (attributes) file, see MPQ_ATTRIBUTE_*.
+func (a *Archive) Attributes() (uint32, error) {
+	err := a.lock("get attributes")
+	if err != nil {
+		return 0, err
+	}
+	defer a.unlock()
+
+	var errorCode C.DWORD
+
+	flags := uint32(C.shimSFileGetAttributes(a.handle, &errorCode))
+	if flags != SFILE_INVALID_ATTRIBUTES {
+		return flags, nil
+	}
+
+	return 0, newPathError(uint32(errorCode), "get attributes", a.name)
+}
+
+// Changes the attributes stored in the (attributes) file. The file is rewritten when the archive is flushed or closed.
+func (a *Archive) SetAttributes(flags uint32) error {
+	err := a.lock("set attributes")
+	if err != nil {
+		return err
+	}
+	defer a.unlock()
+
+	var errorCode C.DWORD
+	if C.shimSFileSetAttributes(a.handle, C.DWORD(flags), &errorCode) != 0 {
+		return nil
+	}
+
+	return newPathError(uint32(errorCode), "set attributes", a.name)
+}
+
+// Recalculates the attributes of a file, such as after the file was patched.
+func (a *Archive) UpdateFileAttributes(fileName string) error {
+	err := a.lock("update attributes")
+	if err != nil {
+		return err
+	}
+	defer a.unlock()
+
+	cFileName := C.CString(fileName)
+	defer C.free(unsafe.Pointer(cFileName))
+
+	var errorCode C.DWORD
+	if C.shimSFileUpdateFileAttributes(a.handle, cFileName, &errorCode) != 0 {
+		return nil
+	}
+
+	return newPathError(uint32(errorCode), "update attributes", fileName)
+}
+
+// Reads and parses the (attributes) file.
+//
+// The file reflects the state of the archive when it was last flushed.
+func (a *Archive) ReadAttributes() (*Attributes, error) {
+	err := a.lock("read attributes")
+	if err != nil {
+		return nil, err
+	}
+	q := infoQuery{handle: a.handle, name: a.name}
+	fileTableSize := q.uint32(SFileMpqFileTableSize, false)
+	a.unlock()
+	if q.err != nil {
+		return nil, q.err
+	}
+
+	reader, err := a.SFileOpenFileEx("(attributes)", SFILE_OPEN_FROM_MPQ)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.SFileCloseFile()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	attributes, err := parseAttributes(data, fileTableSize, a.name)
+	if err != nil {
+		return nil, err
+	}
+
+	err = a.forEachFile("*", func(findFileData *FileFindData) {
+		if findFileData.BlockIndex < uint32(len(attributes.Entries)) {
+			attributes.Entries[findFileData.BlockIndex].FileName = findFileData.FileName
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return attributes, nil
+}
+
+// Parses the (attributes) file of an archive with the given file table size. The name of the archive is used in errors.
+func parseAttributes(data []byte, fileTableSize uint32, mpqName string) (*Attributes, error) {
+	if len(data) < 8 {
+		return nil, newStormError(ERROR_FILE_CORRUPT, "read attributes", mpqName, "(attributes) file too short")
+	}
+
+	attributes := Attributes{
+		Version: binary.LittleEndian.Uint32(data[0:]),
+		Flags:   binary.LittleEndian.Uint32(data[4:]),
+	}
+	if attributes.Version != MPQ_ATTRIBUTES_V1 {
+		return nil, newStormError(ERROR_BAD_FORMAT, "read attributes", mpqName, "unsupported (attributes) version")
+	}
+
+	// The (listfile) and (attributes) may have been left out when the file was written
+	count := -1
+	for _, n := range []uint32{fileTableSize, fileTableSize - 1, fileTableSize - 2} {
+		if n <= fileTableSize && attributesSize(attributes.Flags, int(n)) == len(data) {
+			count = int(n)
+			break
+		}
+	}
+	if count < 0 {
+		return nil, newStormError(ERROR_FILE_CORRUPT, "read attributes", mpqName, "(attributes) file size does not match the file table")
+	}
+
+	attributes.Entries = make([]AttributeEntry, count)
+	for i := range attributes.Entries {
+		attributes.Entries[i].BlockIndex = uint32(i)
+	}
+
+	data = data[8:]
+	if attributes.Flags&MPQ_ATTRIBUTE_CRC32 != 0 {
+		for i := range attributes.Entries {
+			attributes.Entries[i].CRC32 = binary.LittleEndian.Uint32(data[i*4:])
+		}
+		data = data[count*4:]
+	}
+	if attributes.Flags&MPQ_ATTRIBUTE_FILETIME != 0 {
+		for i := range attributes.Entries {
+			attributes.Entries[i].FileTime = binary.LittleEndian.Uint64(data[i*8:])
+		}
+		data = data[count*8:]
+	}
+	if attributes.Flags&MPQ_ATTRIBUTE_MD5 != 0 {
+		for i := range attributes.Entries {
+			copy(attributes.Entries[i].MD5[:], data[i*16:])
+		}
+		data = data[count*16:]
+	}
+	if attributes.Flags&MPQ_ATTRIBUTE_PATCH_BIT != 0 {
+		for i := range attributes.Entries {
+			attributes.Entries[i].PatchBit = data[i/8]&(0x80>>(i%8)) != 0
+		}
+	}
+
+	return &attributes, nil
+}
+
+// Returns the size of the (attributes) file with the given flags and number of entries.
+func attributesSize(flags uint32, count int) int {
+	size := 8
+	if flags&MPQ_ATTRIBUTE_CRC32 != 0 {
+		size += count * 4
+	}
+	if flags&MPQ_ATTRIBUTE_FILETIME != 0 {
+		size += count * 8
+	}
+	if flags&MPQ_ATTRIBUTE_MD5 != 0 {
+		size += count * 16
+	}
+	if flags&MPQ_ATTRIBUTE_PATCH_BIT != 0 {
+		size += (count + 7) / 8
+	}
+	return size
+}
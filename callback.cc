@@ -1 +1,69 @@
-// To be implemented
\ No newline at end of file
+//go:build !purego
+
+// Trampolines between StormLib callbacks and the Go functions exported in callback.go.
+//
+// The user data pointer carries a runtime/cgo.Handle which identifies the Go function.
+
+#include <stdint.h>
+#include "lasterror.h"
+
+extern "C" {
+
+// Exported from callback.go
+void goAddFileCallback(uintptr_t handle, DWORD dwBytesWritten, DWORD dwTotalBytes, int bFinalCall);
+void goCompactCallback(uintptr_t handle, DWORD dwWorkType, ULONGLONG BytesProcessed, ULONGLONG TotalBytes);
+void goDownloadCallback(uintptr_t handle, ULONGLONG ByteOffset, DWORD dwTotalBytes);
+
+static void WINAPI addFileCallback(void * pvUserData, DWORD dwBytesWritten, DWORD dwTotalBytes, bool bFinalCall)
+{
+    goAddFileCallback((uintptr_t)pvUserData, dwBytesWritten, dwTotalBytes, bFinalCall ? 1 : 0);
+}
+
+static void WINAPI compactCallback(void * pvUserData, DWORD dwWorkType, ULONGLONG BytesProcessed, ULONGLONG TotalBytes)
+{
+    goCompactCallback((uintptr_t)pvUserData, dwWorkType, BytesProcessed, TotalBytes);
+}
+
+static void WINAPI downloadCallback(void * pvUserData, ULONGLONG ByteOffset, DWORD dwTotalBytes)
+{
+    goDownloadCallback((uintptr_t)pvUserData, ByteOffset, dwTotalBytes);
+}
+
+// A zero handle removes the callback. The error code is captured as in lasterror.h.
+int setAddFileCallback(HANDLE hMpq, uintptr_t handle, DWORD * pdwError)
+{
+    bool result;
+
+    stormLock();
+    SetLastError(ERROR_SUCCESS);
+    result = SFileSetAddFileCallback(hMpq, handle ? addFileCallback : NULL, (void *)handle);
+    *pdwError = GetLastError();
+    stormUnlock();
+    return result ? 1 : 0;
+}
+
+int setCompactCallback(HANDLE hMpq, uintptr_t handle, DWORD * pdwError)
+{
+    bool result;
+
+    stormLock();
+    SetLastError(ERROR_SUCCESS);
+    result = SFileSetCompactCallback(hMpq, handle ? compactCallback : NULL, (void *)handle);
+    *pdwError = GetLastError();
+    stormUnlock();
+    return result ? 1 : 0;
+}
+
+int setDownloadCallback(HANDLE hMpq, uintptr_t handle, DWORD * pdwError)
+{
+    bool result;
+
+    stormLock();
+    SetLastError(ERROR_SUCCESS);
+    result = SFileSetDownloadCallback(hMpq, handle ? downloadCallback : NULL, (void *)handle);
+    *pdwError = GetLastError();
+    stormUnlock();
+    return result ? 1 : 0;
+}
+
+}
@@ -0,0 +1,137 @@
+//go:build !purego
+
+package storm
+
+// #include <stdint.h>
+// #include <StormLib.h>
+//
+// int setAddFileCallback(HANDLE hMpq, uintptr_t handle, DWORD * pdwError);
+// int setCompactCallback(HANDLE hMpq, uintptr_t handle, DWORD * pdwError);
+// int setDownloadCallback(HANDLE hMpq, uintptr_t handle, DWORD * pdwError);
+import "C"
+
+import "runtime/cgo"
+
+// Receives progress of adding a file to the archive.
+type AddFileProgressFunc func(bytesWritten uint32, totalBytes uint32, finalCall bool)
+
+// Receives progress of compacting the archive. The work type is one of CCB_*.
+type CompactProgressFunc func(workType uint32, bytesProcessed uint64, totalBytes uint64)
+
+// Receives progress of downloading the archive from a remote stream.
+type DownloadProgressFunc func(byteOffset uint64, totalBytes uint32)
+
+// Sets a function to be called while files are being added to the archive. A nil function removes the callback.
+//
+// The function is called on the goroutine that performs the write, while the archive is locked and while this package
+// holds the process-wide lock taken around every StormLib call. Calling back into the archive deadlocks. Calls into other
+// archives are allowed from the function itself, as the process-wide lock is recursive, but deadlock if they wait for a
+// goroutine that needs this archive, or if the function waits for another goroutine that calls into StormLib.
+func (a *Archive) SetAddFileProgress(fn AddFileProgressFunc) error {
+	err := a.lock("set callback")
+	if err != nil {
+		return err
+	}
+	defer a.unlock()
+
+	var handle cgo.Handle
+	if fn != nil {
+		handle = cgo.NewHandle(fn)
+	}
+
+	var errorCode C.DWORD
+	if C.setAddFileCallback(a.handle, C.uintptr_t(handle), &errorCode) == 0 {
+		deleteCallbackHandle(handle)
+		return newPathError(uint32(errorCode), "set callback", a.name)
+	}
+
+	deleteCallbackHandle(a.addFileCallback)
+	a.addFileCallback = handle
+	return nil
+}
+
+// Sets a function to be called while the archive is being compacted. A nil function removes the callback.
+//
+// The function is called on the goroutine that performs the compaction, while the archive is locked. Calling back into the
+// archive deadlocks, and calls into other archives are subject to the restrictions described at SetAddFileProgress.
+func (a *Archive) SetCompactProgress(fn CompactProgressFunc) error {
+	err := a.lock("set callback")
+	if err != nil {
+		return err
+	}
+	defer a.unlock()
+
+	var handle cgo.Handle
+	if fn != nil {
+		handle = cgo.NewHandle(fn)
+	}
+
+	var errorCode C.DWORD
+	if C.setCompactCallback(a.handle, C.uintptr_t(handle), &errorCode) == 0 {
+		deleteCallbackHandle(handle)
+		return newPathError(uint32(errorCode), "set callback", a.name)
+	}
+
+	deleteCallbackHandle(a.compactCallback)
+	a.compactCallback = handle
+	return nil
+}
+
+// Sets a function to be called while the archive is being downloaded. A nil function removes the callback.
+//
+// The function is called on the goroutine that performs the read, while the archive is locked. Calling back into the
+// archive deadlocks, and calls into other archives are subject to the restrictions described at SetAddFileProgress.
+func (a *Archive) SetDownloadProgress(fn DownloadProgressFunc) error {
+	err := a.lock("set callback")
+	if err != nil {
+		return err
+	}
+	defer a.unlock()
+
+	var handle cgo.Handle
+	if fn != nil {
+		handle = cgo.NewHandle(fn)
+	}
+
+	var errorCode C.DWORD
+	if C.setDownloadCallback(a.handle, C.uintptr_t(handle), &errorCode) == 0 {
+		deleteCallbackHandle(handle)
+		return newPathError(uint32(errorCode), "set callback", a.name)
+	}
+
+	deleteCallbackHandle(a.downloadCallback)
+	a.downloadCallback = handle
+	return nil
+}
+
+// Releases the handles of all callbacks registered on the archive.
+func (a *Archive) releaseCallbacks() {
+	deleteCallbackHandle(a.addFileCallback)
+	deleteCallbackHandle(a.compactCallback)
+	deleteCallbackHandle(a.downloadCallback)
+	a.addFileCallback, a.compactCallback, a.downloadCallback = 0, 0, 0
+}
+
+func deleteCallbackHandle(handle cgo.Handle) {
+	if handle != 0 {
+		handle.Delete()
+	}
+}
+
+//export goAddFileCallback
+func goAddFileCallback(handle C.uintptr_t, bytesWritten C.DWORD, totalBytes C.DWORD, finalCall C.int) {
+	fn := cgo.Handle(handle).Value().(AddFileProgressFunc)
+	fn(uint32(bytesWritten), uint32(totalBytes), finalCall != 0)
+}
+
+//export goCompactCallback
+func goCompactCallback(handle C.uintptr_t, workType C.DWORD, bytesProcessed C.ULONGLONG, totalBytes C.ULONGLONG) {
+	fn := cgo.Handle(handle).Value().(CompactProgressFunc)
+	fn(uint32(workType), uint64(bytesProcessed), uint64(totalBytes))
+}
+
+//export goDownloadCallback
+func goDownloadCallback(handle C.uintptr_t, byteOffset C.ULONGLONG, totalBytes C.DWORD) {
+	fn := cgo.Handle(handle).Value().(DownloadProgressFunc)
+	fn(uint64(byteOffset), uint32(totalBytes))
+}
@@ -0,0 +1,227 @@
+// IMA ADPCM compression of 16-bit PCM samples, as used by MPQ_COMPRESSION_ADPCM_MONO and MPQ_COMPRESSION_ADPCM_STEREO.
+//
+// This package is written in pure Go and is available in purego builds. The output is the same as that of StormLib.
+package adpcm
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	ErrInvalid = errors.New("adpcm: invalid channel count or compression level")
+	ErrCorrupt = errors.New("adpcm: corrupt data")
+)
+
+// Compression levels for the MPQ_WAVE_QUALITY_* values. MPQ_WAVE_QUALITY_HIGH does not use ADPCM.
+const (
+	LevelMedium = 5 // MPQ_WAVE_QUALITY_MEDIUM
+	LevelLow    = 4 // MPQ_WAVE_QUALITY_LOW
+)
+
+const (
+	initialStepIndex = 0x2C
+	maxStepIndex     = 88
+)
+
+var nextStep = [32]int{
+	-1, 0, -1, 4, -1, 2, -1, 6,
+	-1, 1, -1, 5, -1, 3, -1, 7,
+	-1, 1, -1, 5, -1, 3, -1, 7,
+	-1, 2, -1, 4, -1, 6, -1, 8,
+}
+
+var stepSize = [maxStepIndex + 1]int{
+	7, 8, 9, 10, 11, 12, 13, 14,
+	16, 17, 19, 21, 23, 25, 28, 31,
+	34, 37, 41, 45, 50, 55, 60, 66,
+	73, 80, 88, 97, 107, 118, 130, 143,
+	157, 173, 190, 209, 230, 253, 279, 307,
+	337, 371, 408, 449, 494, 544, 598, 658,
+	724, 796, 876, 963, 1060, 1166, 1282, 1411,
+	1552, 1707, 1878, 2066, 2272, 2499, 2749, 3024,
+	3327, 3660, 4026, 4428, 4871, 5358, 5894, 6484,
+	7132, 7845, 8630, 9493, 10442, 11487, 12635, 13899,
+	15289, 16818, 18500, 20350, 22385, 24623, 27086, 29794,
+	32767,
+}
+
+// Markers stored instead of an encoded sample.
+const (
+	markerSmall = 0x80 // The sample equals the predicted one, and the step index decreases
+	markerLarge = 0x81 // The step index increases by 8, and the next byte is for the same channel
+)
+
+// Returns the ADPCM compression level that SCompCompress uses for its compression level.
+func Level(cmpLevel int) int {
+	switch {
+	case 0 < cmpLevel && cmpLevel <= 2:
+		return 4
+	case cmpLevel == 3:
+		return 6
+	}
+
+	return 5
+}
+
+// Compresses little-endian 16-bit PCM samples of 1 or 2 interleaved channels at a level from 2 to 7.
+//
+// Each sample is stored in a byte, with level - 1 bits for the difference to the predicted sample, so higher levels
+// give better quality. A trailing odd byte is dropped.
+func Compress(pcm []byte, channels int, level int) ([]byte, error) {
+	if channels < 1 || channels > 2 || level < 2 || level > 7 {
+		return nil, ErrInvalid
+	}
+
+	bitShift := uint(level - 1)
+	maxBit := 1 << (bitShift - 1)
+	if maxBit > 0x20 {
+		maxBit = 0x20
+	}
+	predicted := [2]int{}
+	stepIndex := [2]int{initialStepIndex, initialStepIndex}
+
+	out := make([]byte, 0, 2+len(pcm)/2+len(pcm)/16)
+	out = append(out, 0, byte(bitShift))
+
+	samples := len(pcm) / 2
+	for i := 0; i < channels && i < samples; i++ {
+		predicted[i] = int(int16(binary.LittleEndian.Uint16(pcm[2*i:])))
+		out = appendUint16(out, uint16(predicted[i]))
+	}
+
+	channel := channels - 1
+	for i := channels; i < samples; i++ {
+		sample := int(int16(binary.LittleEndian.Uint16(pcm[2*i:])))
+		channel = (channel + 1) % channels
+
+		encoded := 0
+		difference := sample - predicted[channel]
+		if difference < 0 {
+			difference = -difference
+			encoded |= 0x40
+		}
+
+		step := stepSize[stepIndex[channel]]
+		if difference < step>>level {
+			if stepIndex[channel] != 0 {
+				stepIndex[channel]--
+			}
+			out = append(out, markerSmall)
+			continue
+		}
+
+		for difference > step<<1 && stepIndex[channel] < maxStepIndex {
+			stepIndex[channel] = largeStep(stepIndex[channel])
+			step = stepSize[stepIndex[channel]]
+			out = append(out, markerLarge)
+		}
+
+		base := step >> bitShift
+		total := 0
+		for bit := 1; bit <= maxBit; bit <<= 1 {
+			if total+step <= difference {
+				total += step
+				encoded |= bit
+			}
+			step >>= 1
+		}
+
+		predicted[channel] = predict(predicted[channel], encoded, base+total)
+		out = append(out, byte(encoded))
+		stepIndex[channel] = next(stepIndex[channel], encoded)
+	}
+
+	return out, nil
+}
+
+// Decompresses data of 1 or 2 channels to little-endian 16-bit PCM samples.
+func Decompress(data []byte, channels int) ([]byte, error) {
+	if channels < 1 || channels > 2 {
+		return nil, ErrInvalid
+	}
+	if len(data) < 2+2*channels {
+		return nil, ErrCorrupt
+	}
+
+	bitShift := uint(data[1])
+	predicted := [2]int{}
+	stepIndex := [2]int{initialStepIndex, initialStepIndex}
+
+	out := make([]byte, 0, 2*len(data))
+	for i := 0; i < channels; i++ {
+		predicted[i] = int(int16(binary.LittleEndian.Uint16(data[2+2*i:])))
+		out = appendUint16(out, uint16(predicted[i]))
+	}
+
+	channel := channels - 1
+	for _, encoded := range data[2+2*channels:] {
+		channel = (channel + 1) % channels
+
+		switch encoded {
+		case markerSmall:
+			if stepIndex[channel] != 0 {
+				stepIndex[channel]--
+			}
+			out = appendUint16(out, uint16(predicted[channel]))
+
+		case markerLarge:
+			stepIndex[channel] = largeStep(stepIndex[channel])
+			channel = (channel + 1) % channels
+
+		default:
+			step := stepSize[stepIndex[channel]]
+			difference := step >> bitShift
+			for bit := 0; bit < 6; bit++ {
+				if encoded&(1<<bit) != 0 {
+					difference += step >> bit
+				}
+			}
+
+			predicted[channel] = predict(predicted[channel], int(encoded), difference)
+			out = appendUint16(out, uint16(predicted[channel]))
+			stepIndex[channel] = next(stepIndex[channel], int(encoded))
+		}
+	}
+
+	return out, nil
+}
+
+// Applies the difference to the predicted sample in the direction of the sign bit, clamped to 16 bits.
+func predict(predicted, encoded, difference int) int {
+	if encoded&0x40 != 0 {
+		if predicted-difference < -32768 {
+			return -32768
+		}
+		return predicted - difference
+	}
+
+	if predicted+difference > 32767 {
+		return 32767
+	}
+	return predicted + difference
+}
+
+func next(stepIndex, encoded int) int {
+	stepIndex += nextStep[encoded&0x1F]
+	switch {
+	case stepIndex < 0:
+		return 0
+	case stepIndex > maxStepIndex:
+		return maxStepIndex
+	}
+	return stepIndex
+}
+
+// Returns the step index after a marker of a large step.
+func largeStep(stepIndex int) int {
+	if stepIndex+8 > maxStepIndex {
+		return maxStepIndex
+	}
+	return stepIndex + 8
+}
+
+// Appends a 16-bit little-endian sample.
+func appendUint16(out []byte, sample uint16) []byte {
+	return append(out, byte(sample), byte(sample>>8))
+}
@@ -0,0 +1,116 @@
+package adpcm
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+)
+
+// Returns samples of a sine wave for each channel, at a different frequency on each, as 16-bit PCM.
+func testPCM(channels int, samples int) []byte {
+	pcm := make([]byte, 0, 2*channels*samples)
+	for i := 0; i < samples; i++ {
+		for channel := 0; channel < channels; channel++ {
+			sample := int16(20000 * math.Sin(float64(i*(channel+1))/20))
+			pcm = append(pcm, byte(sample), byte(uint16(sample)>>8))
+		}
+	}
+
+	return pcm
+}
+
+// Returns the mean absolute difference between the samples of two PCM buffers.
+func meanError(a, b []byte) float64 {
+	var total float64
+	for i := 0; i+1 < len(a); i += 2 {
+		total += math.Abs(float64(int16(binary.LittleEndian.Uint16(a[i:]))) - float64(int16(binary.LittleEndian.Uint16(b[i:]))))
+	}
+
+	return total / float64(len(a)/2)
+}
+
+func TestLevel(t *testing.T) {
+	for cmpLevel, level := range map[int]int{-1: 5, 0: 5, 1: 4, 2: 4, 3: 6, 4: 5, 9: 5} {
+		if Level(cmpLevel) != level {
+			t.Errorf("Level(%d) = %d, expected %d", cmpLevel, Level(cmpLevel), level)
+			return
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for channels := 1; channels <= 2; channels++ {
+		pcm := testPCM(channels, 4000)
+
+		previous := math.Inf(1)
+		for level := 2; level <= 7; level++ {
+			compressed, err := Compress(pcm, channels, level)
+			if err != nil {
+				t.Errorf("Compress (%d channels, level %d): %v", channels, level, err)
+				return
+			}
+			if len(compressed) >= len(pcm) {
+				t.Errorf("Compress (%d channels, level %d) returned %d bytes for %d", channels, level, len(compressed), len(pcm))
+				return
+			}
+
+			got, err := Decompress(compressed, channels)
+			if err != nil || len(got) != len(pcm) {
+				t.Errorf("Decompress (%d channels, level %d) returned %d bytes or %v, expected %d", channels, level, len(got), err, len(pcm))
+				return
+			}
+
+			// The first sample of each channel is stored as it is, and higher levels follow the signal closer
+			meanError := meanError(got, pcm)
+			if string(got[:2*channels]) != string(pcm[:2*channels]) || meanError > 2000 || level > 4 && meanError >= previous {
+				t.Errorf("Decompress (%d channels, level %d): mean error %.1f after %.1f", channels, level, meanError, previous)
+				return
+			}
+			previous = meanError
+		}
+	}
+}
+
+func TestOddSizes(t *testing.T) {
+	// A trailing odd byte is dropped, and a single sample per channel is stored as it is
+	for _, test := range []struct {
+		pcm      []byte
+		channels int
+	}{{[]byte{1, 2, 3}, 1}, {[]byte{1, 2, 3, 4, 5}, 2}} {
+		compressed, err := Compress(test.pcm, test.channels, LevelMedium)
+		if err != nil {
+			t.Errorf("Compress (%d bytes): %v", len(test.pcm), err)
+			return
+		}
+
+		got, err := Decompress(compressed, test.channels)
+		if err != nil || string(got) != string(test.pcm[:len(test.pcm)&^1]) {
+			t.Errorf("Decompress (%d bytes) returned %v or %v", len(test.pcm), got, err)
+			return
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, test := range []struct{ channels, level int }{{0, LevelMedium}, {3, LevelMedium}, {1, 1}, {2, 8}} {
+		_, err := Compress(testPCM(1, 10), test.channels, test.level)
+		if !errors.Is(err, ErrInvalid) {
+			t.Errorf("Compress (%d channels, level %d): %v, expected %v", test.channels, test.level, err, ErrInvalid)
+			return
+		}
+	}
+
+	_, err := Decompress([]byte{0, 4, 0, 0}, 3)
+	if !errors.Is(err, ErrInvalid) {
+		t.Errorf("Decompress (3 channels): %v, expected %v", err, ErrInvalid)
+		return
+	}
+
+	// The header holds the first sample of each channel
+	_, err = Decompress([]byte{0, 4, 0, 0, 0}, 2)
+	if !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Decompress of a truncated header: %v, expected %v", err, ErrCorrupt)
+		return
+	}
+}
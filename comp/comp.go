@@ -0,0 +1,58 @@
+// Compression codecs used in MPQ archives.
+//
+// The SComp* functions and the streaming wrappers call into StormLib and are not available in purego builds. Compress
+// and Decompress run the same pipeline in pure Go, with the single codecs in subpackages.
+package comp
+
+import "strings"
+
+// Combination of compression methods, stored as the first byte of compressed MPQ data.
+type Mask uint8
+
+const (
+	Huffman     Mask = 0x01 // Huffman compression (used on WAVE files only)
+	Zlib        Mask = 0x02 // ZLIB compression
+	PKWare      Mask = 0x08 // PKWARE DCL compression
+	BZip2       Mask = 0x10 // BZIP2 compression (added in Warcraft III)
+	Sparse      Mask = 0x20 // Sparse compression (added in Starcraft 2)
+	ADPCMMono   Mask = 0x40 // IMA ADPCM compression (mono)
+	ADPCMStereo Mask = 0x80 // IMA ADPCM compression (stereo)
+	LZMA        Mask = 0x12 // LZMA compression (added in Starcraft 2). This value is NOT a combination of flags
+)
+
+// Names of the compression methods, in the order they are applied when compressing.
+var maskNames = []struct {
+	mask Mask
+	name string
+}{
+	{Sparse, "sparse"},
+	{ADPCMMono, "adpcm-mono"},
+	{ADPCMStereo, "adpcm-stereo"},
+	{Huffman, "huffman"},
+	{Zlib, "zlib"},
+	{PKWare, "pkware"},
+	{BZip2, "bzip2"},
+}
+
+// Returns the names of the compression methods, such as "huffman|zlib".
+func (m Mask) String() string {
+	if m == LZMA {
+		return "lzma"
+	}
+	if m == 0 {
+		return "none"
+	}
+
+	var names []string
+	for _, n := range maskNames {
+		if m&n.mask != 0 {
+			names = append(names, n.name)
+			m &^= n.mask
+		}
+	}
+	if m != 0 {
+		names = append(names, "unknown")
+	}
+
+	return strings.Join(names, "|")
+}
@@ -0,0 +1,394 @@
+// Adaptive Huffman coding, as used by MPQ_COMPRESSION_HUFFMANN.
+//
+// This package is written in pure Go and is available in purego builds. The output is the same as that of StormLib.
+package huffman
+
+import "errors"
+
+var (
+	ErrInvalid = errors.New("huffman: invalid compression type")
+	ErrCorrupt = errors.New("huffman: corrupt data")
+)
+
+const (
+	endOfStream = 0x100 // Value marking the end of the data
+	newValue    = 0x101 // Value followed by a byte that is not in the tree yet
+	maxItems    = 0x203 // Leaves for all byte values and both markers, and the items joining them
+)
+
+// Returns the compression type that SCompCompress uses for the Huffman coding of data compressed with ADPCM at a level.
+func ADPCMType(level int) int {
+	return level + 2
+}
+
+// Compresses data. The compression type, from 0 to 8, selects the initial weights of the byte values and is stored as the
+// first byte of the result.
+//
+// With type 0 all byte values start with the same weight and the weights follow the data. The other types start with
+// weights tuned to a kind of data, and only byte values missing from the tree are added as they occur.
+func Compress(data []byte, cmpType int) ([]byte, error) {
+	t, err := newTree(cmpType)
+	if err != nil {
+		return nil, err
+	}
+
+	w := bitWriter{out: make([]byte, 0, len(data)/2+2)}
+	w.putBits(uint64(cmpType), 8)
+
+	for _, b := range data {
+		it := t.byValue[b]
+		if it == nil {
+			t.encode(&w, t.byValue[newValue])
+			w.putBits(uint64(b), 8)
+
+			err = t.insertBranch(t.head.prev.value, int(b))
+			if err != nil {
+				return nil, err
+			}
+			t.incWeights(t.byValue[b])
+			continue
+		}
+
+		t.encode(&w, it)
+		if t.adaptive {
+			t.incWeights(it)
+		}
+	}
+
+	t.encode(&w, t.byValue[endOfStream])
+	w.flush()
+
+	return w.out, nil
+}
+
+// Decompresses data up to the end marker or until size bytes are decoded.
+func Decompress(data []byte, size int) ([]byte, error) {
+	r := bitReader{data: data}
+
+	cmpType, err := r.get8Bits()
+	if err != nil {
+		return nil, err
+	}
+	t, err := newTree(cmpType)
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+
+	out := make([]byte, 0, size)
+	for len(out) < size {
+		value, err := t.decode(&r)
+		if err != nil {
+			return nil, err
+		}
+		if value == endOfStream {
+			break
+		}
+
+		if value == newValue {
+			value, err = r.get8Bits()
+			if err != nil {
+				return nil, err
+			}
+			if t.byValue[value] != nil {
+				return nil, ErrCorrupt
+			}
+
+			err = t.insertBranch(t.head.prev.value, value)
+			if err != nil {
+				return nil, err
+			}
+			if !t.adaptive {
+				t.incWeights(t.byValue[value])
+			}
+		}
+
+		out = append(out, byte(value))
+		if t.adaptive {
+			t.incWeights(t.byValue[value])
+		}
+	}
+
+	return out, nil
+}
+
+// An item of the tree, which is also linked into a list of all items sorted by descending weight.
+type item struct {
+	next    *item // Item of lower or equal weight
+	prev    *item // Item of higher or equal weight
+	value   int   // Byte value or marker of a leaf
+	weight  int
+	parent  *item // Parent item (nil for the root)
+	childLo *item // Child of lower weight (nil for a leaf); the other child is childLo.prev
+}
+
+// A Huffman tree whose shape follows the weights of the coded values.
+type tree struct {
+	items    [maxItems]item // Items in the order they were created
+	used     int            // Number of items used
+	head     item           // Head of the list: head.next is the item of highest weight, which is the root, and head.prev the lowest
+	byValue  [0x102]*item   // Leaf of each byte value and marker (nil if not in the tree)
+	adaptive bool           // The weights are incremented for every value, as with compression type 0
+}
+
+// Builds the initial tree for a compression type.
+func newTree(cmpType int) (*tree, error) {
+	if cmpType < 0 || cmpType >= len(weights) {
+		return nil, ErrInvalid
+	}
+
+	t := tree{adaptive: cmpType == 0}
+	t.head.next = &t.head
+	t.head.prev = &t.head
+
+	// Sort the leaves by weight, keeping the order of values of the same weight
+	maxWeight := 0
+	for value, weight := range weights[cmpType] {
+		if weight != 0 {
+			it := t.newItem(value, int(weight), false)
+			t.byValue[value] = it
+			maxWeight = t.fixPosition(it, maxWeight)
+		}
+	}
+	t.byValue[endOfStream] = t.newItem(endOfStream, 1, true)
+	t.byValue[newValue] = t.newItem(newValue, 1, true)
+
+	// Join pairs of items from the lowest weight up
+	childLo := t.head.prev
+	for childLo != &t.head {
+		childHi := childLo.prev
+		if childHi == &t.head {
+			break
+		}
+
+		parent := t.newItem(0, childHi.weight+childLo.weight, false)
+		childLo.parent = parent
+		childHi.parent = parent
+		parent.childLo = childLo
+		maxWeight = t.fixPosition(parent, maxWeight)
+
+		childLo = childHi.prev
+	}
+
+	return &t, nil
+}
+
+// Creates an item at the top of the list, or at the bottom if last is set. Returns nil if all items are used.
+func (t *tree) newItem(value int, weight int, last bool) *item {
+	if t.used == len(t.items) {
+		return nil
+	}
+	it := &t.items[t.used]
+	t.used++
+
+	if last {
+		t.linkAfter(t.head.prev, it)
+	} else {
+		t.linkAfter(&t.head, it)
+	}
+	it.value = value
+	it.weight = weight
+
+	return it
+}
+
+// Moves an item created at the top of the list to its place by weight, returning the highest weight so far.
+func (t *tree) fixPosition(it *item, maxWeight int) int {
+	if it.weight >= maxWeight {
+		return it.weight
+	}
+
+	higher := t.higherOrEqual(t.head.prev, it.weight)
+	t.unlink(it)
+	t.linkAfter(higher, it)
+
+	return maxWeight
+}
+
+// Returns the first item from the given one upwards whose weight is at least weight, or the head of the list.
+func (t *tree) higherOrEqual(it *item, weight int) *item {
+	for ; it != &t.head; it = it.prev {
+		if it.weight >= weight {
+			return it
+		}
+	}
+
+	return &t.head
+}
+
+// Links an item into the list right below another one.
+func (t *tree) linkAfter(prev *item, it *item) {
+	it.next = prev.next
+	it.prev = prev
+	prev.next.prev = it
+	prev.next = it
+}
+
+// Removes an item from the list.
+func (t *tree) unlink(it *item) {
+	it.prev.next = it.next
+	it.next.prev = it.prev
+	it.next = nil
+	it.prev = nil
+}
+
+// Increments the weight of an item and its parents, swapping items to keep the list sorted by weight.
+func (t *tree) incWeights(it *item) {
+	for ; it != nil; it = it.parent {
+		it.weight++
+
+		// The item swaps places with the topmost item of lower weight
+		higher := t.higherOrEqual(it.prev, it.weight)
+		swap := higher.next
+		if swap == it {
+			continue
+		}
+
+		t.unlink(swap)
+		t.linkAfter(it, swap)
+		t.unlink(it)
+		t.linkAfter(higher, it)
+
+		// Keep the lower child of each parent the lower of its children in the list
+		swapChildLo := swap.parent.childLo
+		if it.parent.childLo == it {
+			it.parent.childLo = swap
+		}
+		if swapChildLo == swap {
+			swap.parent.childLo = it
+		}
+
+		it.parent, swap.parent = swap.parent, it.parent
+	}
+}
+
+// Splits the leaf of lowest weight into a leaf for its own value and a new leaf for another value.
+func (t *tree) insertBranch(lastValue int, value int) error {
+	last := t.head.prev
+
+	childHi := t.newItem(lastValue, last.weight, true)
+	if childHi == nil {
+		return ErrCorrupt
+	}
+	childHi.parent = last
+	t.byValue[lastValue] = childHi
+
+	childLo := t.newItem(value, 0, true)
+	if childLo == nil {
+		return ErrCorrupt
+	}
+	childLo.parent = last
+	last.childLo = childLo
+	t.byValue[value] = childLo
+
+	t.incWeights(childLo)
+	return nil
+}
+
+// Writes the code of a leaf, starting with the bit below the root. A set bit selects the higher child.
+func (t *tree) encode(w *bitWriter, it *item) {
+	var bits uint64
+	var count uint
+
+	for parent := it.parent; parent != nil; it, parent = parent, parent.parent {
+		bits <<= 1
+		if parent.childLo != it {
+			bits |= 1
+		}
+		count++
+	}
+
+	w.putBits(bits, count)
+}
+
+// Reads a code from the root down to a leaf and returns its value.
+func (t *tree) decode(r *bitReader) (int, error) {
+	it := t.head.next
+	for it.childLo != nil {
+		bit, err := r.get1Bit()
+		if err != nil {
+			return 0, err
+		}
+
+		if bit != 0 {
+			it = it.childLo.prev
+		} else {
+			it = it.childLo
+		}
+	}
+
+	return it.value, nil
+}
+
+// Writes bits starting with the lowest bit of each byte.
+type bitWriter struct {
+	out   []byte
+	bits  uint64 // Bits not written yet
+	count uint   // Number of bits not written yet, less than 8 between calls
+}
+
+func (w *bitWriter) putBits(value uint64, count uint) {
+	for count > 0 {
+		n := count
+		if n > 32 {
+			n = 32
+		}
+		w.bits |= (value & (1<<n - 1)) << w.count
+		w.count += n
+		value >>= n
+		count -= n
+
+		for w.count >= 8 {
+			w.out = append(w.out, byte(w.bits))
+			w.bits >>= 8
+			w.count -= 8
+		}
+	}
+}
+
+// Writes the remaining bits, padded with zeros to a whole byte.
+func (w *bitWriter) flush() {
+	if w.count > 0 {
+		w.out = append(w.out, byte(w.bits))
+		w.bits = 0
+		w.count = 0
+	}
+}
+
+// Reads bits starting with the lowest bit of each byte.
+type bitReader struct {
+	data  []byte
+	bits  uint
+	count uint
+}
+
+func (r *bitReader) get1Bit() (uint, error) {
+	if r.count == 0 {
+		if len(r.data) == 0 {
+			return 0, ErrCorrupt
+		}
+		r.bits = uint(r.data[0])
+		r.data = r.data[1:]
+		r.count = 8
+	}
+
+	bit := r.bits & 1
+	r.bits >>= 1
+	r.count--
+	return bit, nil
+}
+
+func (r *bitReader) get8Bits() (int, error) {
+	if r.count < 8 {
+		if len(r.data) == 0 {
+			return 0, ErrCorrupt
+		}
+		r.bits |= uint(r.data[0]) << r.count
+		r.data = r.data[1:]
+		r.count += 8
+	}
+
+	value := int(r.bits & 0xFF)
+	r.bits >>= 8
+	r.count -= 8
+	return value, nil
+}
@@ -0,0 +1,128 @@
+package huffman
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// Returns data of every kind the compression types are tuned to: text, samples of ADPCM and random bytes.
+func testData() []byte {
+	data := []byte(strings.Repeat("The quick brown fox jumps over the lazy dog. ", 200))
+	for i := 0; i < 4000; i++ {
+		data = append(data, byte(i*i>>7), 0x80|byte(i&3))
+	}
+
+	random := make([]byte, 8000)
+	rand.New(rand.NewSource(1)).Read(random)
+	return append(data, random...)
+}
+
+func TestRoundTrip(t *testing.T) {
+	inputs := [][]byte{nil, {0}, {0xFF, 0xFF, 0xFF}, []byte("abcabcabc"), testData()}
+
+	for cmpType := 0; cmpType <= 8; cmpType++ {
+		for _, data := range inputs {
+			compressed, err := Compress(data, cmpType)
+			if err != nil {
+				t.Errorf("Compress (type %d, %d bytes): %v", cmpType, len(data), err)
+				return
+			}
+			if compressed[0] != byte(cmpType) {
+				t.Errorf("Compress (type %d) stored the type %d", cmpType, compressed[0])
+				return
+			}
+
+			got, err := Decompress(compressed, len(data))
+			if err != nil || !bytes.Equal(got, data) {
+				t.Errorf("Decompress (type %d, %d bytes): content differs or %v", cmpType, len(data), err)
+				return
+			}
+
+			// The end marker stops the data before the size is reached
+			got, err = Decompress(compressed, len(data)+100)
+			if err != nil || !bytes.Equal(got, data) {
+				t.Errorf("Decompress (type %d, %d bytes) past the end: content differs or %v", cmpType, len(data), err)
+				return
+			}
+		}
+	}
+}
+
+func TestADPCMType(t *testing.T) {
+	for level, cmpType := range []int{2, 3, 4, 5, 6, 7, 8} {
+		if ADPCMType(level) != cmpType {
+			t.Errorf("ADPCMType(%d) = %d, expected %d", level, ADPCMType(level), cmpType)
+			return
+		}
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	for _, cmpType := range []int{-1, 9, 256} {
+		_, err := Compress([]byte("data"), cmpType)
+		if !errors.Is(err, ErrInvalid) {
+			t.Errorf("Compress (type %d): %v, expected %v", cmpType, err, ErrInvalid)
+			return
+		}
+	}
+
+	_, err := Decompress([]byte{9, 0, 0, 0}, 4)
+	if !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Decompress (type 9): %v, expected %v", err, ErrCorrupt)
+		return
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	data := testData()
+	compressed, err := Compress(data, 0)
+	if err != nil {
+		t.Errorf("Compress: %v", err)
+		return
+	}
+
+	// Truncated data ends in the middle of a code
+	for _, n := range []int{0, 1, len(compressed) / 2, len(compressed) - 8} {
+		_, err = Decompress(compressed[:n], len(data))
+		if !errors.Is(err, ErrCorrupt) {
+			t.Errorf("Decompress (%d of %d bytes): %v, expected %v", n, len(compressed), err, ErrCorrupt)
+			return
+		}
+	}
+
+	// A new value that is already in the tree would be added a second time
+	tr, _ := newTree(0)
+	w := bitWriter{}
+	w.putBits(0, 8)
+	tr.encode(&w, tr.byValue[newValue])
+	w.putBits('a', 8)
+	w.flush()
+	_, err = Decompress(w.out, 10)
+	if !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Decompress of a new value already in the tree: %v, expected %v", err, ErrCorrupt)
+		return
+	}
+
+	// Inserting a value fails once all items are used
+	for _, used := range []int{maxItems - 1, maxItems} {
+		tr, _ = newTree(1)
+		tr.used = used
+		err = tr.insertBranch(tr.head.prev.value, 'a')
+		if !errors.Is(err, ErrCorrupt) {
+			t.Errorf("insertBranch with %d items used: %v, expected %v", used, err, ErrCorrupt)
+			return
+		}
+	}
+
+	// Random data decodes to something or fails, without panicking
+	random := rand.New(rand.NewSource(2))
+	for i := 0; i < 1000; i++ {
+		garbage := make([]byte, 1+random.Intn(200))
+		random.Read(garbage)
+		garbage[0] = byte(i % 9)
+		Decompress(garbage, 1000)
+	}
+}
@@ -0,0 +1,294 @@
+package comp
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	dsbzip2 "github.com/dsnet/compress/bzip2"
+	"github.com/slyh/go-stormlib/comp/adpcm"
+	"github.com/slyh/go-stormlib/comp/huffman"
+	"github.com/slyh/go-stormlib/comp/pkware"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+var (
+	ErrNotSupported = errors.New("comp: compression method not supported")
+	ErrCorrupt      = errors.New("comp: corrupt data")
+)
+
+// A compression method of the pipeline. The functions are nil if the method is not implemented in Go.
+type method struct {
+	mask       Mask
+	compress   func(data []byte) ([]byte, error)
+	decompress func(data []byte, size int) ([]byte, error)
+}
+
+// Compression methods in the order they are applied when compressing. Decompressing goes the other way.
+var methods = []method{
+	{Sparse, compressSparse, decompressSparse},
+	{ADPCMMono, compressADPCM(1), decompressADPCM(1)},
+	{ADPCMStereo, compressADPCM(2), decompressADPCM(2)},
+	{Huffman, compressHuffman(0), decompressHuffman},
+	{Zlib, compressZlib, decompressZlib},
+	{PKWare, compressPKWare, decompressPKWare},
+	{BZip2, compressBZip2, decompressBZip2},
+}
+
+// Mask of the methods in the pipeline.
+const knownMask = Sparse | ADPCMMono | ADPCMStereo | Huffman | Zlib | PKWare | BZip2
+
+// Compresses data with the methods in the mask, without cgo. The result starts with the mask byte, unless the data did
+// not compress, in which case a copy of the data is returned.
+//
+// Like SCompCompress, a method that does not make the data smaller is left out of the mask, and Huffman coding uses the
+// compression type 0, or the weights for ADPCM if combined with it. LZMA cannot be combined with other methods, and
+// bzip2 is left out after zlib alone, as that mask reads as LZMA.
+func Compress(data []byte, mask Mask) ([]byte, error) {
+	if mask == LZMA {
+		compressed, err := compressLZMA(data)
+		if err != nil {
+			return nil, err
+		}
+		return withMask(data, LZMA, compressed), nil
+	}
+	if mask&^knownMask != 0 {
+		return nil, fmt.Errorf("%w (%v)", ErrNotSupported, mask)
+	}
+	for _, m := range methods {
+		if mask&m.mask != 0 && m.compress == nil {
+			return nil, fmt.Errorf("%w (%v)", ErrNotSupported, m.mask)
+		}
+	}
+
+	var used Mask
+	compressed := data
+	for _, m := range methods {
+		if mask&m.mask == 0 || used|m.mask == LZMA {
+			continue
+		}
+
+		compress := m.compress
+		if m.mask == Huffman && mask&(ADPCMMono|ADPCMStereo) != 0 {
+			compress = compressHuffman(huffman.ADPCMType(adpcm.LevelMedium))
+		}
+
+		out, err := compress(compressed)
+		if err != nil {
+			return nil, fmt.Errorf("comp: %v: %w", m.mask, err)
+		}
+		if len(out) < len(compressed) {
+			compressed = out
+			used |= m.mask
+		}
+	}
+
+	return withMask(data, used, compressed), nil
+}
+
+// Decompresses data starting with a mask byte to size bytes, without cgo. Data of exactly size bytes is returned as it
+// is.
+//
+// The methods are applied in the order of SCompDecompress2, with LZMA as a method of its own.
+func Decompress(data []byte, size int) ([]byte, error) {
+	if len(data) == size {
+		return data, nil
+	}
+	if len(data) < 2 || size < 0 {
+		return nil, ErrCorrupt
+	}
+
+	mask := Mask(data[0])
+	data = data[1:]
+
+	switch {
+	case mask == LZMA:
+		var err error
+		data, err = decompressLZMA(data, size)
+		if err != nil {
+			return nil, fmt.Errorf("%w (%v: %v)", ErrCorrupt, LZMA, err)
+		}
+
+	case mask == 0 || mask&^knownMask != 0:
+		return nil, fmt.Errorf("%w (unknown mask %#x)", ErrCorrupt, uint8(mask))
+
+	default:
+		for i := len(methods) - 1; i >= 0; i-- {
+			m := methods[i]
+			if mask&m.mask == 0 {
+				continue
+			}
+			if m.decompress == nil {
+				return nil, fmt.Errorf("%w (%v)", ErrNotSupported, m.mask)
+			}
+
+			var err error
+			data, err = m.decompress(data, size)
+			if err != nil {
+				return nil, fmt.Errorf("%w (%v: %v)", ErrCorrupt, m.mask, err)
+			}
+		}
+	}
+
+	if len(data) != size {
+		return nil, fmt.Errorf("%w (%d bytes instead of %d)", ErrCorrupt, len(data), size)
+	}
+
+	return data, nil
+}
+
+// Prefixes the compressed data with the mask, or returns a copy of the data if it did not compress.
+func withMask(data []byte, mask Mask, compressed []byte) []byte {
+	if mask == 0 || 1+len(compressed) >= len(data) {
+		return append([]byte(nil), data...)
+	}
+
+	return append([]byte{byte(mask)}, compressed...)
+}
+
+// Reads at most size bytes from r.
+func readLimited(r io.Reader, size int) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, int64(size)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > size {
+		return nil, errors.New("data larger than expected")
+	}
+
+	return data, nil
+}
+
+func compressADPCM(channels int) func(data []byte) ([]byte, error) {
+	return func(data []byte) ([]byte, error) {
+		return adpcm.Compress(data, channels, adpcm.LevelMedium)
+	}
+}
+
+func decompressADPCM(channels int) func(data []byte, size int) ([]byte, error) {
+	return func(data []byte, size int) ([]byte, error) {
+		out, err := adpcm.Decompress(data, channels)
+		if err == nil && len(out) > size {
+			out = out[:size]
+		}
+		return out, err
+	}
+}
+
+func compressHuffman(cmpType int) func(data []byte) ([]byte, error) {
+	return func(data []byte) ([]byte, error) {
+		return huffman.Compress(data, cmpType)
+	}
+}
+
+func decompressHuffman(data []byte, size int) ([]byte, error) {
+	return huffman.Decompress(data, size)
+}
+
+func compressZlib(data []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+
+	w := zlib.NewWriter(&buffer)
+	_, err := w.Write(data)
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func decompressZlib(data []byte, size int) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return readLimited(r, size)
+}
+
+// Compresses with the dictionary size chosen by SCompImplode for the size of the data.
+func compressPKWare(data []byte) ([]byte, error) {
+	dictSize := pkware.Dict4K
+	switch {
+	case len(data) < 0x600:
+		dictSize = pkware.Dict1K
+	case len(data) < 0xC00:
+		dictSize = pkware.Dict2K
+	}
+
+	return pkware.Implode(data, pkware.Binary, dictSize)
+}
+
+func decompressPKWare(data []byte, size int) ([]byte, error) {
+	return readLimited(pkware.NewReader(bytes.NewReader(data)), size)
+}
+
+func compressBZip2(data []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+
+	w, err := dsbzip2.NewWriter(&buffer, &dsbzip2.WriterConfig{Level: dsbzip2.BestCompression})
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(data)
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+func decompressBZip2(data []byte, size int) ([]byte, error) {
+	return readLimited(bzip2.NewReader(bytes.NewReader(data)), size)
+}
+
+// Compresses to the layout of StormLib: a zero byte for no filter, the 5 bytes of LZMA properties and the stream,
+// without the uncompressed size or an end marker.
+func compressLZMA(data []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+
+	w, err := lzma.WriterConfig{Size: int64(len(data)), SizeInHeader: true}.NewWriter(&buffer)
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(data)
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Drop the uncompressed size from the header
+	stream := buffer.Bytes()
+	return append(append([]byte{0}, stream[:5]...), stream[lzma.HeaderLen:]...), nil
+}
+
+// Decompresses the layout of compressLZMA, adding the uncompressed size to make a classic LZMA header.
+func decompressLZMA(data []byte, size int) ([]byte, error) {
+	if len(data) < 6 || data[0] != 0 {
+		return nil, errors.New("invalid header")
+	}
+
+	header := make([]byte, lzma.HeaderLen)
+	copy(header, data[1:6])
+	binary.LittleEndian.PutUint64(header[5:], uint64(size))
+
+	r, err := lzma.NewReader(io.MultiReader(bytes.NewReader(header), bytes.NewReader(data[6:])))
+	if err != nil {
+		return nil, err
+	}
+
+	return readLimited(r, size)
+}
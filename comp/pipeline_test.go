@@ -0,0 +1,188 @@
+package comp
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// Returns data that every method compresses: text, runs of zeros and a slowly changing 16-bit signal.
+func testData() []byte {
+	data := []byte(strings.Repeat("The quick brown fox jumps over the lazy dog. ", 100))
+	data = append(data, make([]byte, 1000)...)
+	for i := 0; i < 2000; i++ {
+		sample := uint16(i * 16)
+		data = append(data, byte(sample), byte(sample>>8))
+	}
+
+	return data
+}
+
+func TestRoundTrip(t *testing.T) {
+	data := testData()
+	lossless := []Mask{Sparse, Huffman, Zlib, PKWare, BZip2}
+
+	// Every combination of the lossless methods, which includes zlib and bzip2 alone as LZMA
+	for combination := 0; combination < 1<<len(lossless); combination++ {
+		var mask Mask
+		for i, m := range lossless {
+			if combination&(1<<i) != 0 {
+				mask |= m
+			}
+		}
+
+		compressed, err := Compress(data, mask)
+		if err != nil {
+			t.Errorf("Compress (%v): %v", mask, err)
+			return
+		}
+		if mask != 0 && (len(compressed) >= len(data) || mask != LZMA && Mask(compressed[0])&^mask != 0) {
+			t.Errorf("Compress (%v) returned %d bytes with the mask %v", mask, len(compressed), Mask(compressed[0]))
+			return
+		}
+
+		got, err := Decompress(compressed, len(data))
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("Decompress (%v): content differs or %v", mask, err)
+			return
+		}
+	}
+
+	// ADPCM is lossy, but keeps the size through the other methods
+	for _, mask := range []Mask{ADPCMMono, ADPCMStereo, ADPCMMono | Huffman, ADPCMStereo | Huffman | Zlib, ADPCMMono | PKWare} {
+		compressed, err := Compress(data, mask)
+		if err != nil {
+			t.Errorf("Compress (%v): %v", mask, err)
+			return
+		}
+		if Mask(compressed[0])&(ADPCMMono|ADPCMStereo) == 0 {
+			t.Errorf("Compress (%v) left out ADPCM: %v", mask, Mask(compressed[0]))
+			return
+		}
+
+		got, err := Decompress(compressed, len(data))
+		if err != nil || len(got) != len(data) {
+			t.Errorf("Decompress (%v) returned %d bytes or %v, expected %d", mask, len(got), err, len(data))
+			return
+		}
+	}
+
+	// Data that does not compress is returned as it is
+	compressed, err := Compress([]byte("abc"), Zlib)
+	if err != nil || string(compressed) != "abc" {
+		t.Errorf("Compress of incompressible data returned %q or %v", compressed, err)
+		return
+	}
+}
+
+func TestLZMA(t *testing.T) {
+	data := testData()
+
+	compressed, err := Compress(data, LZMA)
+	if err != nil || Mask(compressed[0]) != LZMA {
+		t.Errorf("Compress (%v) returned the mask %v or %v", LZMA, Mask(compressed[0]), err)
+		return
+	}
+	got, err := Decompress(compressed, len(data))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("Decompress (%v): content differs or %v", LZMA, err)
+		return
+	}
+
+	// The mask of LZMA is not read as zlib followed by bzip2
+	zlib, err := compressZlib(data)
+	if err != nil {
+		t.Errorf("compressZlib: %v", err)
+		return
+	}
+	bzip2, err := compressBZip2(zlib)
+	if err != nil {
+		t.Errorf("compressBZip2: %v", err)
+		return
+	}
+	_, err = Decompress(append([]byte{byte(LZMA)}, bzip2...), len(data))
+	if !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Decompress of zlib and bzip2 data: %v, expected %v", err, ErrCorrupt)
+		return
+	}
+
+	// Bzip2 is left out if only zlib was used before, even where it would make the data smaller
+	repeated := []byte(strings.Repeat("The quick brown fox jumps over the lazy dog. ", 100000))
+	compressed, err = Compress(repeated, Sparse|Zlib|BZip2)
+	if err != nil || Mask(compressed[0]) != Zlib {
+		t.Errorf("Compress (%v) returned the mask %v or %v, expected %v", Sparse|Zlib|BZip2, Mask(compressed[0]), err, Zlib)
+		return
+	}
+	got, err = Decompress(compressed, len(repeated))
+	if err != nil || !bytes.Equal(got, repeated) {
+		t.Errorf("Decompress (%v): content differs or %v", Zlib, err)
+		return
+	}
+}
+
+func TestErrors(t *testing.T) {
+	data := testData()
+
+	_, err := Compress(data, 0x04)
+	if !errors.Is(err, ErrNotSupported) {
+		t.Errorf("Compress of an unknown method: %v, expected %v", err, ErrNotSupported)
+		return
+	}
+
+	compressed, err := Compress(data, Zlib)
+	if err != nil {
+		t.Errorf("Compress: %v", err)
+		return
+	}
+
+	for _, test := range []struct {
+		name string
+		data []byte
+		size int
+	}{
+		{"an unknown mask", append([]byte{0x04}, compressed[1:]...), len(data)},
+		{"an empty mask", append([]byte{0}, compressed[1:]...), len(data)},
+		{"a single byte", compressed[:1], len(data)},
+		{"truncated data", compressed[:len(compressed)/2], len(data)},
+		{"data larger than the size", compressed, len(data) - 1},
+		{"data smaller than the size", compressed, len(data) + 1},
+		{"a negative size", compressed, -1},
+	} {
+		_, err = Decompress(test.data, test.size)
+		if !errors.Is(err, ErrCorrupt) {
+			t.Errorf("Decompress of %s: %v, expected %v", test.name, err, ErrCorrupt)
+			return
+		}
+	}
+}
+
+func TestSparse(t *testing.T) {
+	inputs := [][]byte{
+		nil,
+		{0, 0},
+		{0, 0, 0},
+		make([]byte, maxZeroRun+minZeroRun+1),
+		bytes.Repeat([]byte{1}, 3*maxLiteralRun+1),
+		append(append([]byte{1, 0, 0, 2}, make([]byte, 300)...), 3),
+	}
+
+	for _, data := range inputs {
+		compressed, err := compressSparse(data)
+		if err != nil {
+			t.Errorf("compressSparse (%d bytes): %v", len(data), err)
+			return
+		}
+		got, err := decompressSparse(compressed, len(data))
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("decompressSparse (%d bytes): content differs or %v", len(data), err)
+			return
+		}
+	}
+
+	_, err := decompressSparse([]byte{0, 0, 0, 10, 0x85, 1}, 10)
+	if err == nil {
+		t.Errorf("decompressSparse of a truncated literal run succeeded")
+		return
+	}
+}
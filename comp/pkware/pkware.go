@@ -0,0 +1,167 @@
+// PKWARE Data Compression Library (DCL) implode and explode, as used by MPQ_FILE_IMPLODE and MPQ_COMPRESSION_PKWARE.
+//
+// This package is written in pure Go and is available in purego builds. The compressed format is compatible with
+// SCompImplode and SCompExplode of StormLib.
+package pkware
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+// Coding of the literals, stored as the first byte of the compressed data.
+type Mode uint8
+
+const (
+	Binary Mode = 0 // Literals are stored as plain bytes
+	ASCII  Mode = 1 // Literals are Huffman coded, which suits text
+)
+
+// Supported dictionary sizes, stored as the second byte of the compressed data.
+const (
+	Dict1K = 1024 // Dictionary of 1 KB
+	Dict2K = 2048 // Dictionary of 2 KB
+	Dict4K = 4096 // Dictionary of 4 KB
+)
+
+var (
+	ErrInvalid = errors.New("pkware: invalid mode or dictionary size")
+	ErrHeader  = errors.New("pkware: invalid header")
+	ErrCorrupt = errors.New("pkware: corrupt data")
+)
+
+const (
+	minLength   = 2     // Length of the shortest match
+	maxLength   = 518   // Length of the longest match
+	endLength   = 519   // Length marking the end of the data
+	maxDistance = 256   // Distance of the farthest match of minLength
+	maxDictSize = 4096  // Largest dictionary size
+	chunkSize   = 16384 // Number of bytes decoded or written at once
+)
+
+// Code lengths of the literals, lengths and distances, as run-length encoded pairs of (count - 1) << 4 | length.
+var (
+	literalLengths = []byte{
+		11, 124, 8, 7, 28, 7, 188, 13, 76, 4, 10, 8, 12, 10, 12, 10, 8, 23, 8,
+		9, 7, 6, 7, 8, 7, 6, 55, 8, 23, 24, 12, 11, 7, 9, 11, 12, 6, 7, 22, 5,
+		7, 24, 6, 11, 9, 6, 7, 22, 7, 11, 38, 7, 9, 8, 25, 11, 8, 11, 9, 12,
+		8, 12, 5, 38, 5, 38, 5, 11, 7, 5, 6, 21, 6, 10, 53, 8, 7, 24, 10, 27,
+		44, 253, 253, 253, 252, 252, 252, 13, 12, 45, 12, 45, 12, 61, 12, 45,
+		44, 173,
+	}
+	lengthLengths   = []byte{2, 35, 36, 53, 38, 23}
+	distanceLengths = []byte{2, 20, 53, 230, 247, 151, 248}
+)
+
+// Base and number of extra bits of the length symbols.
+var (
+	lengthBase  = [16]uint16{3, 2, 4, 5, 6, 7, 8, 9, 10, 12, 16, 24, 40, 72, 136, 264}
+	lengthExtra = [16]uint8{0, 0, 0, 0, 0, 0, 0, 0, 1, 2, 3, 4, 5, 6, 7, 8}
+)
+
+var (
+	literalCode  = newHuffman(literalLengths)
+	lengthCode   = newHuffman(lengthLengths)
+	distanceCode = newHuffman(distanceLengths)
+
+	lengthSymbol = newLengthSymbols() // Length symbol of each length up to endLength
+)
+
+// A fixed canonical Huffman code. The stream stores the bits of a code inverted, most significant bit first.
+type huffman struct {
+	codes   []uint16 // Code of each symbol, in the order of the bits in the stream
+	lengths []uint8  // Length of the code of each symbol, in bits
+	table   []uint16 // Symbol << 4 | length, indexed by the next maxBits bits of the stream
+	maxBits uint
+}
+
+// Builds a code from run-length encoded code lengths.
+func newHuffman(compact []byte) *huffman {
+	var h huffman
+	var count, next [16]int
+
+	for _, pair := range compact {
+		for i := 0; i <= int(pair>>4); i++ {
+			h.lengths = append(h.lengths, pair&15)
+		}
+		count[pair&15] += int(pair>>4) + 1
+		if uint(pair&15) > h.maxBits {
+			h.maxBits = uint(pair & 15)
+		}
+	}
+
+	code := 0
+	for length := 1; length < len(next); length++ {
+		code = (code + count[length-1]) << 1
+		next[length] = code
+	}
+
+	h.codes = make([]uint16, len(h.lengths))
+	h.table = make([]uint16, 1<<h.maxBits)
+	for symbol, length := range h.lengths {
+		code := next[length]
+		next[length]++
+
+		var bits uint16
+		for i := 0; i < int(length); i++ {
+			bits = bits<<1 | uint16(code>>i&1^1)
+		}
+		h.codes[symbol] = bits
+
+		for i := int(bits); i < len(h.table); i += 1 << length {
+			h.table[i] = uint16(symbol)<<4 | uint16(length)
+		}
+	}
+
+	return &h
+}
+
+func newLengthSymbols() []uint8 {
+	symbols := make([]uint8, endLength+1)
+	for symbol := range lengthBase {
+		for i := 0; i < 1<<lengthExtra[symbol]; i++ {
+			symbols[int(lengthBase[symbol])+i] = uint8(symbol)
+		}
+	}
+
+	return symbols
+}
+
+// Returns the number of low distance bits stored after the distance symbol for a dictionary size.
+func dictBits(dictSize int) (uint, bool) {
+	switch dictSize {
+	case Dict1K:
+		return 4, true
+	case Dict2K:
+		return 5, true
+	case Dict4K:
+		return 6, true
+	}
+
+	return 0, false
+}
+
+// Compresses data in a single call. See NewWriter.
+func Implode(data []byte, mode Mode, dictSize int) ([]byte, error) {
+	var buffer bytes.Buffer
+
+	w, err := NewWriter(&buffer, mode, dictSize)
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(data)
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// Decompresses data in a single call, up to the end marker. See NewReader.
+func Explode(data []byte) ([]byte, error) {
+	return io.ReadAll(NewReader(bytes.NewReader(data)))
+}
@@ -0,0 +1,151 @@
+package pkware
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// Returns data of every kind the writer handles: text, long runs, matches far back and random bytes.
+func testData() []byte {
+	data := []byte(strings.Repeat("The quick brown fox jumps over the lazy dog. ", 500))
+	data = append(data, make([]byte, 3000)...)
+	data = append(data, data[1000:6000]...)
+
+	random := make([]byte, 40000)
+	rand.New(rand.NewSource(1)).Read(random)
+	return append(data, random...)
+}
+
+func TestRoundTrip(t *testing.T) {
+	inputs := [][]byte{nil, []byte("a"), []byte("aa"), []byte("abcabcabc"), testData()}
+
+	for _, mode := range []Mode{Binary, ASCII} {
+		for _, dictSize := range []int{Dict1K, Dict2K, Dict4K} {
+			for _, data := range inputs {
+				compressed, err := Implode(data, mode, dictSize)
+				if err != nil {
+					t.Errorf("Implode (mode %d, dictionary %d, %d bytes): %v", mode, dictSize, len(data), err)
+					return
+				}
+				if compressed[0] != byte(mode) || compressed[1] < 4 || compressed[1] > 6 {
+					t.Errorf("Implode (mode %d, dictionary %d) wrote the header %v", mode, dictSize, compressed[:2])
+					return
+				}
+
+				got, err := Explode(compressed)
+				if err != nil || !bytes.Equal(got, data) {
+					t.Errorf("Explode (mode %d, dictionary %d, %d bytes): content differs or %v", mode, dictSize, len(data), err)
+					return
+				}
+			}
+		}
+	}
+}
+
+func TestStreaming(t *testing.T) {
+	data := testData()
+
+	// Writes of any size produce the same output as a single one
+	var buffer bytes.Buffer
+	w, err := NewWriter(&buffer, ASCII, Dict4K)
+	if err != nil {
+		t.Errorf("NewWriter: %v", err)
+		return
+	}
+	for i := 0; i < len(data); i += 777 {
+		end := i + 777
+		if end > len(data) {
+			end = len(data)
+		}
+		_, err = w.Write(data[i:end])
+		if err != nil {
+			t.Errorf("Writer.Write: %v", err)
+			return
+		}
+	}
+	err = w.Close()
+	if err != nil {
+		t.Errorf("Writer.Close: %v", err)
+		return
+	}
+
+	// Reads of a single byte see the whole data
+	got, err := io.ReadAll(io.LimitReader(NewReader(&buffer), int64(len(data)+1)))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("Reader.Read: content differs or %v", err)
+		return
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	for _, test := range []struct {
+		mode     Mode
+		dictSize int
+	}{
+		{2, Dict4K},
+		{Binary, 0},
+		{ASCII, 8192},
+	} {
+		_, err := Implode([]byte("data"), test.mode, test.dictSize)
+		if !errors.Is(err, ErrInvalid) {
+			t.Errorf("Implode (mode %d, dictionary %d): %v, expected %v", test.mode, test.dictSize, err, ErrInvalid)
+			return
+		}
+	}
+
+	for _, header := range [][]byte{{2, 6}, {0, 3}, {1, 7}} {
+		_, err := Explode(append(header, 0, 0, 0, 0))
+		if !errors.Is(err, ErrHeader) {
+			t.Errorf("Explode (header %v): %v, expected %v", header, err, ErrHeader)
+			return
+		}
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	data := testData()
+
+	for _, mode := range []Mode{Binary, ASCII} {
+		compressed, err := Implode(data, mode, Dict2K)
+		if err != nil {
+			t.Errorf("Implode: %v", err)
+			return
+		}
+
+		// Every cut before the end marker ends in the middle of a symbol or misses the marker
+		for _, n := range []int{0, 1, 2, 3, len(compressed) / 2, len(compressed) - 1} {
+			_, err = Explode(compressed[:n])
+			if !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Errorf("Explode (mode %d, %d of %d bytes): %v, expected %v", mode, n, len(compressed), err, io.ErrUnexpectedEOF)
+				return
+			}
+		}
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	// A match at the start of the data reaches before the first byte
+	var buffer bytes.Buffer
+	w, err := NewWriter(&buffer, Binary, Dict4K)
+	if err != nil {
+		t.Errorf("NewWriter: %v", err)
+		return
+	}
+	w.writeLength(10)
+	w.writeDistance(10, 100)
+	err = w.Close()
+	if err != nil {
+		t.Errorf("Writer.Close: %v", err)
+		return
+	}
+
+	_, err = Explode(buffer.Bytes())
+	if !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Explode: %v, expected %v", err, ErrCorrupt)
+		return
+	}
+}
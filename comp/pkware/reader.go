@@ -0,0 +1,206 @@
+package pkware
+
+import (
+	"bufio"
+	"io"
+)
+
+// A reader decompressing a PKWARE DCL stream, as created by a Writer or SCompImplode.
+//
+// The mode and dictionary size are read from the header of the stream. Reading stops at the end marker; if r is not
+// an io.ByteReader, it is buffered and may be read past the end of the stream.
+type Reader struct {
+	bits     bitReader
+	ascii    bool   // Literals are Huffman coded
+	dictBits uint   // Number of low distance bits, 0 until the header is read
+	window   []byte // Decompressed data, kept for the matches
+	unread   int    // Offset of the first byte of window not returned by Read
+	err      error  // Error returned once window is read, io.EOF after the end marker
+}
+
+// Creates a reader decompressing the data of r.
+func NewReader(r io.Reader) *Reader {
+	byteReader, ok := r.(io.ByteReader)
+	if !ok {
+		byteReader = bufio.NewReader(r)
+	}
+
+	return &Reader{bits: bitReader{r: byteReader}}
+}
+
+// Implementation of the io.Reader interface.
+func (r *Reader) Read(buffer []byte) (int, error) {
+	if len(buffer) == 0 {
+		return 0, nil
+	}
+
+	for r.unread == len(r.window) && r.err == nil {
+		r.decode()
+	}
+
+	n := copy(buffer, r.window[r.unread:])
+	r.unread += n
+	if n == 0 {
+		return 0, r.err
+	}
+
+	return n, nil
+}
+
+// Decodes up to chunkSize bytes, or until the end marker or an error.
+func (r *Reader) decode() {
+	if r.dictBits == 0 {
+		r.err = r.readHeader()
+		if r.err != nil {
+			return
+		}
+	}
+
+	// Drop the data that was read and is too far back for the matches
+	if len(r.window) > 2*chunkSize {
+		n := copy(r.window, r.window[len(r.window)-maxDictSize:])
+		r.window = r.window[:n]
+		r.unread = n
+	}
+
+	for produced := 0; produced < chunkSize; {
+		flag, err := r.bits.read(1)
+		if err != nil {
+			r.err = err
+			return
+		}
+
+		if flag == 0 {
+			var literal uint32
+			if r.ascii {
+				literal, err = r.bits.decode(literalCode)
+			} else {
+				literal, err = r.bits.read(8)
+			}
+			if err != nil {
+				r.err = err
+				return
+			}
+
+			r.window = append(r.window, byte(literal))
+			produced++
+			continue
+		}
+
+		symbol, err := r.bits.decode(lengthCode)
+		if err != nil {
+			r.err = err
+			return
+		}
+		extra, err := r.bits.read(uint(lengthExtra[symbol]))
+		if err != nil {
+			r.err = err
+			return
+		}
+		length := int(lengthBase[symbol]) + int(extra)
+		if length == endLength {
+			r.err = io.EOF
+			return
+		}
+
+		// Matches of minLength use 2 low distance bits, the others use those of the dictionary size
+		lowBits := r.dictBits
+		if length == minLength {
+			lowBits = 2
+		}
+		symbol, err = r.bits.decode(distanceCode)
+		if err != nil {
+			r.err = err
+			return
+		}
+		low, err := r.bits.read(lowBits)
+		if err != nil {
+			r.err = err
+			return
+		}
+		distance := int(symbol)<<lowBits + int(low) + 1
+		if distance > len(r.window) {
+			r.err = ErrCorrupt
+			return
+		}
+
+		// The source may overlap the bytes being copied
+		start := len(r.window) - distance
+		for i := 0; i < length; i++ {
+			r.window = append(r.window, r.window[start+i])
+		}
+		produced += length
+	}
+}
+
+// Reads the mode and the dictionary size.
+func (r *Reader) readHeader() error {
+	mode, err := r.bits.read(8)
+	if err != nil {
+		return err
+	}
+	bits, err := r.bits.read(8)
+	if err != nil {
+		return err
+	}
+	if Mode(mode) > ASCII || bits < 4 || bits > 6 {
+		return ErrHeader
+	}
+
+	r.ascii = Mode(mode) == ASCII
+	r.dictBits = uint(bits)
+	return nil
+}
+
+// Reads bits from a byte stream, least significant bit first.
+type bitReader struct {
+	r     io.ByteReader
+	bits  uint32 // Buffered bits, the next one in the least significant bit
+	count uint   // Number of buffered bits
+	err   error  // Error reading the next byte, returned once the buffered bits are not enough
+}
+
+// Buffers at least n bits, unless the stream ends.
+func (b *bitReader) fill(n uint) {
+	for b.count < n && b.err == nil {
+		c, err := b.r.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			b.err = err
+			return
+		}
+
+		b.bits |= uint32(c) << b.count
+		b.count += 8
+	}
+}
+
+// Reads n bits as a number, the first one in the least significant bit.
+func (b *bitReader) read(n uint) (uint32, error) {
+	b.fill(n)
+	if b.count < n {
+		return 0, b.err
+	}
+
+	value := b.bits & (1<<n - 1)
+	b.bits >>= n
+	b.count -= n
+	return value, nil
+}
+
+// Reads a symbol coded with h.
+func (b *bitReader) decode(h *huffman) (uint32, error) {
+	b.fill(h.maxBits)
+
+	entry := h.table[b.bits&(1<<h.maxBits-1)]
+	n := uint(entry & 15)
+	if n > b.count {
+		return 0, b.err
+	}
+
+	b.bits >>= n
+	b.count -= n
+	return uint32(entry >> 4), nil
+}
@@ -0,0 +1,277 @@
+package pkware
+
+import (
+	"io"
+	"io/fs"
+)
+
+const (
+	hashBits = 14  // Number of bits of the hash of two bytes
+	maxChain = 256 // Number of earlier positions tried for a match
+)
+
+// A writer compressing to a PKWARE DCL stream, readable by a Reader and SCompExplode.
+//
+// The data is compressed as it is written, keeping maxLength bytes back to find matches ahead. The end marker is
+// written on Close.
+type Writer struct {
+	w        io.Writer
+	ascii    bool   // Literals are Huffman coded
+	dictBits uint   // Number of low distance bits for matches longer than minLength
+	dictSize int    // Farthest distance of a match
+	data     []byte // Input data, from dictSize bytes before the next byte to compress
+	base     int    // Position of data[0] in the input
+	pos      int    // Offset of the next byte to compress in data
+	inserted int    // Position of the next byte to add to the hash chains
+	head     []int  // Last position of each hash of two bytes, -1 if none
+	prev     []int  // Previous position with the same hash, indexed by position modulo maxDictSize
+	out      []byte // Compressed bytes not yet written to w
+	bits     uint32 // Bits not yet added to out
+	count    uint   // Number of bits not yet added to out
+	err      error
+	closed   bool
+}
+
+// Creates a writer compressing to w with a mode and a dictionary size of Dict1K, Dict2K or Dict4K.
+//
+// Larger dictionaries compress better, while smaller ones need less memory to decompress.
+func NewWriter(w io.Writer, mode Mode, dictSize int) (*Writer, error) {
+	bits, ok := dictBits(dictSize)
+	if !ok || mode > ASCII {
+		return nil, ErrInvalid
+	}
+
+	z := Writer{
+		w:        w,
+		ascii:    mode == ASCII,
+		dictBits: bits,
+		dictSize: dictSize,
+		head:     make([]int, 1<<hashBits),
+		prev:     make([]int, maxDictSize),
+		out:      []byte{byte(mode), byte(bits)},
+	}
+	for i := range z.head {
+		z.head[i] = -1
+	}
+
+	return &z, nil
+}
+
+// Implementation of the io.Writer interface.
+func (z *Writer) Write(data []byte) (int, error) {
+	if z.closed {
+		return 0, fs.ErrClosed
+	}
+	if z.err != nil {
+		return 0, z.err
+	}
+
+	// Drop the data that is too far back for the matches
+	if z.pos > z.dictSize+chunkSize {
+		drop := z.pos - z.dictSize
+		n := copy(z.data, z.data[drop:])
+		z.data = z.data[:n]
+		z.base += drop
+		z.pos -= drop
+	}
+
+	z.data = append(z.data, data...)
+	z.compress(false)
+	if len(z.out) >= chunkSize {
+		z.flush()
+	}
+	if z.err != nil {
+		return 0, z.err
+	}
+
+	return len(data), nil
+}
+
+// Implementation of the io.Closer interface. Compresses the rest of the data and writes the end marker, without closing
+// the underlying writer.
+func (z *Writer) Close() error {
+	if z.closed {
+		return fs.ErrClosed
+	}
+	z.closed = true
+
+	if z.err != nil {
+		return z.err
+	}
+
+	z.compress(true)
+	z.writeLength(endLength)
+	if z.count > 0 {
+		z.out = append(z.out, byte(z.bits))
+		z.bits, z.count = 0, 0
+	}
+	z.flush()
+
+	return z.err
+}
+
+// Compresses the data, all of it if final is set, or else as long as there are maxLength bytes ahead.
+func (z *Writer) compress(final bool) {
+	for {
+		available := len(z.data) - z.pos
+		if available == 0 || !final && available <= maxLength {
+			return
+		}
+
+		length, distance := z.match(z.pos)
+
+		// Emit a literal instead if the next byte starts a longer match
+		if length > 0 && available > length {
+			next, _ := z.match(z.pos + 1)
+			if next > length {
+				length = 0
+			}
+		}
+
+		if length == 0 {
+			z.writeLiteral(z.data[z.pos])
+			z.pos++
+			continue
+		}
+
+		z.writeLength(length)
+		z.writeDistance(length, distance)
+		z.pos += length
+	}
+}
+
+// Finds the longest match for the data at an offset, or a length of 0 if none is shorter than the literals.
+func (z *Writer) match(offset int) (length, distance int) {
+	z.insert(z.base + offset)
+
+	limit := len(z.data) - offset
+	if limit > maxLength {
+		limit = maxLength
+	}
+	if limit < minLength {
+		return 0, 0
+	}
+
+	position := z.base + offset
+	candidate := z.head[z.hash(offset)]
+	for chain := 0; candidate >= 0 && chain < maxChain; chain++ {
+		d := position - candidate
+		if d > z.dictSize {
+			break
+		}
+
+		source := z.data[candidate-z.base:]
+		n := 0
+		for n < limit && source[n] == z.data[offset+n] {
+			n++
+		}
+		if n > length && (n > minLength || d <= maxDistance) {
+			length, distance = n, d
+			if n == limit {
+				break
+			}
+		}
+
+		candidate = z.prev[candidate%maxDictSize]
+	}
+
+	if length < minLength || z.matchCost(length, distance) >= z.literalCost(offset, length) {
+		return 0, 0
+	}
+
+	return length, distance
+}
+
+// Adds the positions before position to the hash chains.
+func (z *Writer) insert(position int) {
+	for ; z.inserted < position; z.inserted++ {
+		offset := z.inserted - z.base
+		if offset+1 >= len(z.data) {
+			continue
+		}
+
+		h := z.hash(offset)
+		z.prev[z.inserted%maxDictSize] = z.head[h]
+		z.head[h] = z.inserted
+	}
+}
+
+func (z *Writer) hash(offset int) int {
+	return (int(z.data[offset])<<6 ^ int(z.data[offset+1])) & (1<<hashBits - 1)
+}
+
+// Returns the number of bits of a match.
+func (z *Writer) matchCost(length, distance int) int {
+	symbol := lengthSymbol[length]
+	lowBits := z.dictBits
+	if length == minLength {
+		lowBits = 2
+	}
+
+	return 1 + int(lengthCode.lengths[symbol]) + int(lengthExtra[symbol]) +
+		int(distanceCode.lengths[(distance-1)>>lowBits]) + int(lowBits)
+}
+
+// Returns the number of bits of the literals at an offset.
+func (z *Writer) literalCost(offset, length int) int {
+	if !z.ascii {
+		return 9 * length
+	}
+
+	cost := length
+	for _, c := range z.data[offset : offset+length] {
+		cost += int(literalCode.lengths[c])
+	}
+
+	return cost
+}
+
+func (z *Writer) writeLiteral(c byte) {
+	z.writeBits(0, 1)
+	if z.ascii {
+		z.writeBits(uint32(literalCode.codes[c]), uint(literalCode.lengths[c]))
+	} else {
+		z.writeBits(uint32(c), 8)
+	}
+}
+
+// Writes the flag and the length of a match, or the end marker.
+func (z *Writer) writeLength(length int) {
+	symbol := lengthSymbol[length]
+
+	z.writeBits(1, 1)
+	z.writeBits(uint32(lengthCode.codes[symbol]), uint(lengthCode.lengths[symbol]))
+	z.writeBits(uint32(length-int(lengthBase[symbol])), uint(lengthExtra[symbol]))
+}
+
+func (z *Writer) writeDistance(length, distance int) {
+	lowBits := z.dictBits
+	if length == minLength {
+		lowBits = 2
+	}
+
+	symbol := (distance - 1) >> lowBits
+	z.writeBits(uint32(distanceCode.codes[symbol]), uint(distanceCode.lengths[symbol]))
+	z.writeBits(uint32(distance-1)&(1<<lowBits-1), lowBits)
+}
+
+// Writes n bits of value, least significant bit first.
+func (z *Writer) writeBits(value uint32, n uint) {
+	z.bits |= value << z.count
+	z.count += n
+	for z.count >= 8 {
+		z.out = append(z.out, byte(z.bits))
+		z.bits >>= 8
+		z.count -= 8
+	}
+}
+
+// Writes the compressed bytes to the underlying writer.
+func (z *Writer) flush() {
+	if z.err != nil || len(z.out) == 0 {
+		return
+	}
+
+	_, z.err = z.w.Write(z.out)
+	z.out = z.out[:0]
+}
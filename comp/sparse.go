@@ -0,0 +1,101 @@
+package comp
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Limits of the runs of sparse compression. A control byte with the high bit set is followed by (byte & 0x7F) + 1
+// literal bytes; otherwise it stands for byte + 3 zero bytes.
+const (
+	maxLiteralRun = 0x80
+	minZeroRun    = 3
+	maxZeroRun    = 0x7F + minZeroRun
+)
+
+// Compresses runs of zero bytes, storing the size as a 32-bit big-endian number first.
+func compressSparse(data []byte) ([]byte, error) {
+	out := make([]byte, 4)
+	binary.BigEndian.PutUint32(out, uint32(len(data)))
+
+	literals := 0 // Start of the literal bytes not yet stored
+	for i := 0; i < len(data); {
+		zeros := 0
+		for i+zeros < len(data) && data[i+zeros] == 0 {
+			zeros++
+		}
+		if zeros < minZeroRun {
+			i += maxInt(zeros, 1)
+			continue
+		}
+
+		out = appendLiterals(out, data[literals:i])
+		for zeros >= minZeroRun {
+			run := minInt(zeros, maxZeroRun)
+			out = append(out, byte(run-minZeroRun))
+			i += run
+			zeros -= run
+		}
+		literals = i
+	}
+	out = appendLiterals(out, data[literals:])
+
+	return out, nil
+}
+
+func appendLiterals(out, literals []byte) []byte {
+	for len(literals) > 0 {
+		n := minInt(len(literals), maxLiteralRun)
+		out = append(out, 0x80|byte(n-1))
+		out = append(out, literals[:n]...)
+		literals = literals[n:]
+	}
+
+	return out
+}
+
+// Decompresses data of compressSparse. Runs past the stored size are cut off, and missing bytes are left zero.
+func decompressSparse(data []byte, size int) ([]byte, error) {
+	if len(data) < 4 {
+		return nil, errors.New("missing size")
+	}
+
+	stored := binary.BigEndian.Uint32(data)
+	if uint64(stored) > uint64(size) {
+		return nil, errors.New("data larger than expected")
+	}
+
+	out := make([]byte, stored)
+	n := 0
+	for i := 4; i < len(data); {
+		control := data[i]
+		i++
+
+		if control&0x80 != 0 {
+			run := int(control&0x7F) + 1
+			if i+run > len(data) {
+				return nil, errors.New("truncated literal run")
+			}
+			n += copy(out[n:], data[i:i+run])
+			i += run
+		} else {
+			n = minInt(n+int(control)+minZeroRun, len(out))
+		}
+	}
+
+	return out, nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
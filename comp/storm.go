@@ -0,0 +1,89 @@
+//go:build !purego
+
+package comp
+
+// #cgo CFLAGS: -I${SRCDIR}/../StormLib/src/
+// #include "../lasterror.h"
+import "C"
+
+import (
+	"unsafe"
+
+	// Links StormLib and the global lock that the shims of lasterror.h take around every call
+	storm "github.com/slyh/go-stormlib"
+)
+
+// Type of the data for PKWARE DCL compression, passed as the compression type.
+const (
+	TypeBinary = 1 // Binary data
+	TypeASCII  = 2 // ASCII text
+)
+
+// Signature of the StormLib codec functions with the error code added by the shims.
+type codecFunc func(out unsafe.Pointer, outSize *C.int, in unsafe.Pointer, inSize C.int, errorCode *C.DWORD) C.int
+
+// Compresses data with PKWARE DCL implode, without a compression mask byte.
+func SCompImplode(data []byte) ([]byte, error) {
+	return call(data, outputSize(len(data)), "SCompImplode", "failed to implode data", func(out unsafe.Pointer, outSize *C.int, in unsafe.Pointer, inSize C.int, errorCode *C.DWORD) C.int {
+		return C.shimSCompImplode(out, outSize, in, inSize, errorCode)
+	})
+}
+
+// Decompresses data compressed with PKWARE DCL implode to size bytes.
+func SCompExplode(data []byte, size int) ([]byte, error) {
+	return call(data, size, "SCompExplode", "failed to explode data", func(out unsafe.Pointer, outSize *C.int, in unsafe.Pointer, inSize C.int, errorCode *C.DWORD) C.int {
+		return C.shimSCompExplode(out, outSize, in, inSize, errorCode)
+	})
+}
+
+// Compresses data with the methods in the mask. The result starts with the mask byte, unless the data did not compress.
+//
+// The compression type selects TypeBinary or TypeASCII for PKWARE DCL; the level is used by the ADPCM codecs.
+func SCompCompress(data []byte, mask Mask, cmpType int, level int) ([]byte, error) {
+	return call(data, outputSize(len(data)), "SCompCompress", "failed to compress data", func(out unsafe.Pointer, outSize *C.int, in unsafe.Pointer, inSize C.int, errorCode *C.DWORD) C.int {
+		return C.shimSCompCompress(out, outSize, in, inSize, C.uint(mask), C.int(cmpType), C.int(level), errorCode)
+	})
+}
+
+// Decompresses data starting with a mask byte to size bytes. Data of exactly size bytes is returned as it is.
+func SCompDecompress(data []byte, size int) ([]byte, error) {
+	return call(data, size, "SCompDecompress", "failed to decompress data", func(out unsafe.Pointer, outSize *C.int, in unsafe.Pointer, inSize C.int, errorCode *C.DWORD) C.int {
+		return C.shimSCompDecompress(out, outSize, in, inSize, errorCode)
+	})
+}
+
+// Works like SCompDecompress, applying the methods in the order used by newer archives.
+func SCompDecompress2(data []byte, size int) ([]byte, error) {
+	return call(data, size, "SCompDecompress2", "failed to decompress data", func(out unsafe.Pointer, outSize *C.int, in unsafe.Pointer, inSize C.int, errorCode *C.DWORD) C.int {
+		return C.shimSCompDecompress2(out, outSize, in, inSize, errorCode)
+	})
+}
+
+// Returns the size of the output buffer for compressing size bytes, leaving room for data that grows.
+func outputSize(size int) int {
+	return size + size/2 + 64
+}
+
+// Calls a codec with an output buffer of size bytes, returning the part of the buffer that was filled. Errors name the
+// StormLib function as the operation.
+//
+// The shim holds the global lock of the storm package, so the call waits for StormLib calls on any archive, but not for
+// the lock of an archive.
+func call(data []byte, size int, op string, message string, fn codecFunc) ([]byte, error) {
+	if len(data) == 0 || size <= 0 {
+		return nil, &storm.StormError{Code: storm.ERROR_INVALID_PARAMETER, Op: op, Message: message}
+	}
+
+	out := make([]byte, size)
+	outSize := C.int(size)
+
+	var errorCode C.DWORD
+	if fn(unsafe.Pointer(&out[0]), &outSize, unsafe.Pointer(&data[0]), C.int(len(data)), &errorCode) != 0 {
+		return out[:outSize], nil
+	}
+
+	if errorCode == C.ERROR_SUCCESS {
+		errorCode = C.ERROR_FILE_CORRUPT
+	}
+	return nil, &storm.StormError{Code: uint32(errorCode), Op: op, Message: message}
+}
@@ -0,0 +1,97 @@
+//go:build !purego
+
+package comp
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+)
+
+// Options for compressing data with a Writer.
+type Options struct {
+	Mask  Mask // Compression methods
+	Type  int  // Compression type, TypeBinary or TypeASCII for PKWARE DCL (TypeBinary if 0)
+	Level int  // Compression level for the ADPCM codecs
+}
+
+// A writer compressing everything written to it as a single block, as stored in a sector or a single unit file.
+//
+// The compressed block is written to the underlying writer on Close.
+type Writer struct {
+	w       io.Writer
+	options Options
+	buffer  bytes.Buffer
+	closed  bool
+}
+
+// Creates a writer compressing to w.
+func NewWriter(w io.Writer, options Options) *Writer {
+	if options.Type == 0 {
+		options.Type = TypeBinary
+	}
+
+	return &Writer{w: w, options: options}
+}
+
+// Implementation of the io.Writer interface.
+func (w *Writer) Write(data []byte) (int, error) {
+	if w.closed {
+		return 0, fs.ErrClosed
+	}
+
+	return w.buffer.Write(data)
+}
+
+// Implementation of the io.Closer interface. Compresses the written data and writes it to the underlying writer.
+func (w *Writer) Close() error {
+	if w.closed {
+		return fs.ErrClosed
+	}
+	w.closed = true
+
+	if w.buffer.Len() == 0 {
+		return nil
+	}
+
+	compressed, err := SCompCompress(w.buffer.Bytes(), w.options.Mask, w.options.Type, w.options.Level)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.w.Write(compressed)
+	return err
+}
+
+// A reader decompressing a single block, as stored in a sector or a single unit file.
+//
+// The compressed block is read from the underlying reader and decompressed on the first Read.
+type Reader struct {
+	r    io.Reader
+	size int
+	data *bytes.Reader
+	err  error
+}
+
+// Creates a reader decompressing the data of r, which starts with a mask byte, to size bytes.
+func NewReader(r io.Reader, size int) *Reader {
+	return &Reader{r: r, size: size}
+}
+
+// Implementation of the io.Reader interface.
+func (r *Reader) Read(buffer []byte) (int, error) {
+	if r.data == nil && r.err == nil {
+		var compressed, data []byte
+
+		compressed, r.err = io.ReadAll(r.r)
+		if r.err == nil && len(compressed) > 0 {
+			data, r.err = SCompDecompress(compressed, r.size)
+		}
+		r.data = bytes.NewReader(data)
+	}
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	return r.data.Read(buffer)
+}
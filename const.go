@@ -1,3 +1,5 @@
+//go:build !purego
+
 package storm
 
 // #include <StormLib.h>
@@ -33,17 +35,34 @@ const SFILE_OPEN_FROM_MPQ uint32 = C.SFILE_OPEN_FROM_MPQ     // Open the file fr
 const SFILE_OPEN_LOCAL_FILE uint32 = C.SFILE_OPEN_LOCAL_FILE // Open a local file
 
 // Flags for SFileAddFile
-const MPQ_FILE_IMPLODE uint32 = C.MPQ_FILE_IMPLODE                 // Implode method (By PKWARE Data Compression Library)
-const MPQ_FILE_COMPRESS uint32 = C.MPQ_FILE_COMPRESS               // Compress methods (By multiple methods)
-const MPQ_FILE_ENCRYPTED uint32 = C.MPQ_FILE_ENCRYPTED             // Indicates whether file is encrypted
-const MPQ_FILE_FIX_KEY uint32 = C.MPQ_FILE_FIX_KEY                 // File decryption key has to be fixed
-const MPQ_FILE_PATCH_FILE uint32 = C.MPQ_FILE_PATCH_FILE           // The file is a patch file. Raw file data begin with TPatchInfo structure
-const MPQ_FILE_SINGLE_UNIT uint32 = C.MPQ_FILE_SINGLE_UNIT         // File is stored as a single unit, rather than split into sectors (Thx, Quantam)
-const MPQ_FILE_DELETE_MARKER uint32 = C.MPQ_FILE_DELETE_MARKER     // File is a deletion marker. Used in MPQ patches, indicating that the file no longer exists.
-const MPQ_FILE_SECTOR_CRC uint32 = C.MPQ_FILE_SECTOR_CRC           // File has checksums for each sector. Ignored if file is not compressed or imploded.
-const MPQ_FILE_SIGNATURE uint32 = C.MPQ_FILE_SIGNATURE             // Present on STANDARD.SNP\(signature). The only occurence ever observed
-const MPQ_FILE_EXISTS uint32 = C.MPQ_FILE_EXISTS                   // Set if file exists, reset when the file was deleted
-const MPQ_FILE_REPLACEEXISTING uint32 = C.MPQ_FILE_REPLACEEXISTING // Replace when the file exist (SFileAddFile)
+const MPQ_FILE_IMPLODE uint32 = C.MPQ_FILE_IMPLODE                   // Implode method (By PKWARE Data Compression Library)
+const MPQ_FILE_COMPRESS uint32 = C.MPQ_FILE_COMPRESS                 // Compress methods (By multiple methods)
+const MPQ_FILE_ENCRYPTED uint32 = C.MPQ_FILE_ENCRYPTED               // Indicates whether file is encrypted
+const MPQ_FILE_FIX_KEY uint32 = C.MPQ_FILE_FIX_KEY                   // File decryption key has to be fixed
+const MPQ_FILE_PATCH_FILE uint32 = C.MPQ_FILE_PATCH_FILE             // The file is a patch file. Raw file data begin with TPatchInfo structure
+const MPQ_FILE_SINGLE_UNIT uint32 = C.MPQ_FILE_SINGLE_UNIT           // File is stored as a single unit, rather than split into sectors (Thx, Quantam)
+const MPQ_FILE_DELETE_MARKER uint32 = C.MPQ_FILE_DELETE_MARKER       // File is a deletion marker. Used in MPQ patches, indicating that the file no longer exists.
+const MPQ_FILE_SECTOR_CRC uint32 = C.MPQ_FILE_SECTOR_CRC             // File has checksums for each sector. Ignored if file is not compressed or imploded.
+const MPQ_FILE_SIGNATURE uint32 = C.MPQ_FILE_SIGNATURE               // Present on STANDARD.SNP\(signature). The only occurence ever observed
+const MPQ_FILE_EXISTS uint32 = C.MPQ_FILE_EXISTS                     // Set if file exists, reset when the file was deleted
+const MPQ_FILE_REPLACEEXISTING uint32 = C.MPQ_FILE_REPLACEEXISTING   // Replace when the file exist (SFileAddFile)
+const MPQ_FILE_DEFAULT_INTERNAL uint32 = C.MPQ_FILE_DEFAULT_INTERNAL // Use default flags for internal files
+
+// Compression types for multiple compressions
+const MPQ_COMPRESSION_HUFFMANN uint32 = C.MPQ_COMPRESSION_HUFFMANN         // Huffmann compression (used on WAVE files only)
+const MPQ_COMPRESSION_ZLIB uint32 = C.MPQ_COMPRESSION_ZLIB                 // ZLIB compression
+const MPQ_COMPRESSION_PKWARE uint32 = C.MPQ_COMPRESSION_PKWARE             // PKWARE DCL compression
+const MPQ_COMPRESSION_BZIP2 uint32 = C.MPQ_COMPRESSION_BZIP2               // BZIP2 compression (added in Warcraft III)
+const MPQ_COMPRESSION_SPARSE uint32 = C.MPQ_COMPRESSION_SPARSE             // Sparse compression (added in Starcraft 2)
+const MPQ_COMPRESSION_ADPCM_MONO uint32 = C.MPQ_COMPRESSION_ADPCM_MONO     // IMA ADPCM compression (mono)
+const MPQ_COMPRESSION_ADPCM_STEREO uint32 = C.MPQ_COMPRESSION_ADPCM_STEREO // IMA ADPCM compression (stereo)
+const MPQ_COMPRESSION_LZMA uint32 = C.MPQ_COMPRESSION_LZMA                 // LZMA compression. Added in Starcraft 2. This value is NOT a combination of flags.
+const MPQ_COMPRESSION_NEXT_SAME uint32 = C.MPQ_COMPRESSION_NEXT_SAME       // Same compression
+
+// Constants for SFileAddWave
+const MPQ_WAVE_QUALITY_HIGH uint32 = C.MPQ_WAVE_QUALITY_HIGH     // Best quality, the worst compression
+const MPQ_WAVE_QUALITY_MEDIUM uint32 = C.MPQ_WAVE_QUALITY_MEDIUM // Medium quality, medium compression
+const MPQ_WAVE_QUALITY_LOW uint32 = C.MPQ_WAVE_QUALITY_LOW       // Low quality, the best compression
 
 // Error codes
 const ERROR_SUCCESS uint32 = C.ERROR_SUCCESS
@@ -82,6 +101,20 @@ const MPQ_CREATE_ARCHIVE_V2 uint32 = C.MPQ_CREATE_ARCHIVE_V2 // Creates archive
 const MPQ_CREATE_ARCHIVE_V3 uint32 = C.MPQ_CREATE_ARCHIVE_V3 // Creates archive of version 3
 const MPQ_CREATE_ARCHIVE_V4 uint32 = C.MPQ_CREATE_ARCHIVE_V4 // Creates archive of version 4
 
+// Values for SFileCreateArchive2
+const MPQ_FORMAT_VERSION_1 uint32 = C.MPQ_FORMAT_VERSION_1 // Up to The Burning Crusade
+const MPQ_FORMAT_VERSION_2 uint32 = C.MPQ_FORMAT_VERSION_2 // The Burning Crusade and newer
+const MPQ_FORMAT_VERSION_3 uint32 = C.MPQ_FORMAT_VERSION_3 // WoW Cataclysm Beta
+const MPQ_FORMAT_VERSION_4 uint32 = C.MPQ_FORMAT_VERSION_4 // WoW Cataclysm and newer
+
+// Flags for SFileSetAttributes
+const MPQ_ATTRIBUTE_CRC32 uint32 = C.MPQ_ATTRIBUTE_CRC32         // The "(attributes)" contains CRC32 for each file
+const MPQ_ATTRIBUTE_FILETIME uint32 = C.MPQ_ATTRIBUTE_FILETIME   // The "(attributes)" contains file time for each file
+const MPQ_ATTRIBUTE_MD5 uint32 = C.MPQ_ATTRIBUTE_MD5             // The "(attributes)" contains MD5 for each file
+const MPQ_ATTRIBUTE_PATCH_BIT uint32 = C.MPQ_ATTRIBUTE_PATCH_BIT // The "(attributes)" contains a patch bit for each file
+const MPQ_ATTRIBUTE_ALL uint32 = C.MPQ_ATTRIBUTE_ALL             // Summary mask
+const MPQ_ATTRIBUTES_V1 uint32 = C.MPQ_ATTRIBUTES_V1             // (attributes) format version 1.00
+
 // Signature types
 const SIGNATURE_TYPE_NONE uint32 = C.SIGNATURE_TYPE_NONE     // The archive has no signature in it
 const SIGNATURE_TYPE_WEAK uint32 = C.SIGNATURE_TYPE_WEAK     // The archive has weak signature
@@ -105,6 +138,16 @@ const VERIFY_FILE_HAS_MD5 uint32 = C.VERIFY_FILE_HAS_MD5                   // Fi
 const VERIFY_FILE_MD5_ERROR uint32 = C.VERIFY_FILE_MD5_ERROR               // MD5 check failed
 const VERIFY_FILE_HAS_RAW_MD5 uint32 = C.VERIFY_FILE_HAS_RAW_MD5           // File has raw data MD5
 const VERIFY_FILE_RAW_MD5_ERROR uint32 = C.VERIFY_FILE_RAW_MD5_ERROR       // Raw MD5 check failed
+const VERIFY_FILE_ERROR_MASK uint32 = C.VERIFY_FILE_ERROR_MASK             // Any of the failures above
+
+// Flags for SFileVerifyRawData (for MPQs version 4.0 or higher)
+const SFILE_VERIFY_MPQ_HEADER uint32 = C.SFILE_VERIFY_MPQ_HEADER       // Verify raw MPQ header
+const SFILE_VERIFY_HET_TABLE uint32 = C.SFILE_VERIFY_HET_TABLE         // Verify raw data of the HET table
+const SFILE_VERIFY_BET_TABLE uint32 = C.SFILE_VERIFY_BET_TABLE         // Verify raw data of the BET table
+const SFILE_VERIFY_HASH_TABLE uint32 = C.SFILE_VERIFY_HASH_TABLE       // Verify raw data of the hash table
+const SFILE_VERIFY_BLOCK_TABLE uint32 = C.SFILE_VERIFY_BLOCK_TABLE     // Verify raw data of the block table
+const SFILE_VERIFY_HIBLOCK_TABLE uint32 = C.SFILE_VERIFY_HIBLOCK_TABLE // Verify raw data of the hi-block table
+const SFILE_VERIFY_FILE uint32 = C.SFILE_VERIFY_FILE                   // Verify raw data of a file
 
 // Return values for SFileVerifyArchive
 const ERROR_NO_SIGNATURE uint32 = C.ERROR_NO_SIGNATURE                     // There is no signature in the MPQ
@@ -113,3 +156,71 @@ const ERROR_WEAK_SIGNATURE_OK uint32 = C.ERROR_WEAK_SIGNATURE_OK           // Th
 const ERROR_WEAK_SIGNATURE_ERROR uint32 = C.ERROR_WEAK_SIGNATURE_ERROR     // There is a weak signature but sign check failed
 const ERROR_STRONG_SIGNATURE_OK uint32 = C.ERROR_STRONG_SIGNATURE_OK       // There is a strong signature and sign check passed
 const ERROR_STRONG_SIGNATURE_ERROR uint32 = C.ERROR_STRONG_SIGNATURE_ERROR // There is a strong signature but sign check failed
+
+// Information classes for SFileGetFileInfo (archives)
+const SFileMpqFileName uint32 = C.SFileMpqFileName                           // Name of the archive file (TCHAR [])
+const SFileMpqStreamBitmap uint32 = C.SFileMpqStreamBitmap                   // Array of bits, each bit means availability of one block (BYTE [])
+const SFileMpqUserDataOffset uint32 = C.SFileMpqUserDataOffset               // Offset of the user data header (ULONGLONG)
+const SFileMpqUserDataHeader uint32 = C.SFileMpqUserDataHeader               // Raw (unfixed) user data header (TMPQUserData)
+const SFileMpqUserData uint32 = C.SFileMpqUserData                           // MPQ USer data, without the header (BYTE [])
+const SFileMpqHeaderOffset uint32 = C.SFileMpqHeaderOffset                   // Offset of the MPQ header (ULONGLONG)
+const SFileMpqHeaderSize uint32 = C.SFileMpqHeaderSize                       // Fixed size of the MPQ header
+const SFileMpqHeader uint32 = C.SFileMpqHeader                               // Raw (unfixed) archive header (TMPQHeader)
+const SFileMpqHetTableOffset uint32 = C.SFileMpqHetTableOffset               // Offset of the HET table, relative to MPQ header (ULONGLONG)
+const SFileMpqHetTableSize uint32 = C.SFileMpqHetTableSize                   // Compressed size of the HET table (ULONGLONG)
+const SFileMpqHetHeader uint32 = C.SFileMpqHetHeader                         // HET table header (TMPQHetHeader)
+const SFileMpqHetTable uint32 = C.SFileMpqHetTable                           // HET table as pointer. Must be freed using SFileFreeFileInfo
+const SFileMpqBetTableOffset uint32 = C.SFileMpqBetTableOffset               // Offset of the BET table, relative to MPQ header (ULONGLONG)
+const SFileMpqBetTableSize uint32 = C.SFileMpqBetTableSize                   // Compressed size of the BET table (ULONGLONG)
+const SFileMpqBetHeader uint32 = C.SFileMpqBetHeader                         // BET table header, followed by the flags (TMPQBetHeader + DWORD[])
+const SFileMpqBetTable uint32 = C.SFileMpqBetTable                           // BET table as pointer. Must be freed using SFileFreeFileInfo
+const SFileMpqHashTableOffset uint32 = C.SFileMpqHashTableOffset             // Hash table offset, relative to MPQ header (ULONGLONG)
+const SFileMpqHashTableSize64 uint32 = C.SFileMpqHashTableSize64             // Compressed size of the hash table (ULONGLONG)
+const SFileMpqHashTableSize uint32 = C.SFileMpqHashTableSize                 // Size of the hash table, in entries (DWORD)
+const SFileMpqHashTable uint32 = C.SFileMpqHashTable                         // Raw (unfixed) hash table (TMPQBlock [])
+const SFileMpqBlockTableOffset uint32 = C.SFileMpqBlockTableOffset           // Block table offset, relative to MPQ header (ULONGLONG)
+const SFileMpqBlockTableSize64 uint32 = C.SFileMpqBlockTableSize64           // Compressed size of the block table (ULONGLONG)
+const SFileMpqBlockTableSize uint32 = C.SFileMpqBlockTableSize               // Size of the block table, in entries (DWORD)
+const SFileMpqBlockTable uint32 = C.SFileMpqBlockTable                       // Raw (unfixed) block table (TMPQBlock [])
+const SFileMpqHiBlockTableOffset uint32 = C.SFileMpqHiBlockTableOffset       // Hi-block table offset, relative to MPQ header (ULONGLONG)
+const SFileMpqHiBlockTableSize64 uint32 = C.SFileMpqHiBlockTableSize64       // Compressed size of the hi-block table (ULONGLONG)
+const SFileMpqHiBlockTable uint32 = C.SFileMpqHiBlockTable                   // The hi-block table (USHORT [])
+const SFileMpqSignatures uint32 = C.SFileMpqSignatures                       // Signatures present in the MPQ (DWORD)
+const SFileMpqStrongSignatureOffset uint32 = C.SFileMpqStrongSignatureOffset // Byte offset of the strong signature, relative to begin of the file (ULONGLONG)
+const SFileMpqStrongSignatureSize uint32 = C.SFileMpqStrongSignatureSize     // Size of the strong signature (DWORD)
+const SFileMpqStrongSignature uint32 = C.SFileMpqStrongSignature             // The strong signature (BYTE [])
+const SFileMpqArchiveSize64 uint32 = C.SFileMpqArchiveSize64                 // Archive size from the header (ULONGLONG)
+const SFileMpqArchiveSize uint32 = C.SFileMpqArchiveSize                     // Archive size from the header (DWORD)
+const SFileMpqMaxFileCount uint32 = C.SFileMpqMaxFileCount                   // Max number of files in the archive (DWORD)
+const SFileMpqFileTableSize uint32 = C.SFileMpqFileTableSize                 // Number of entries in the file table (DWORD)
+const SFileMpqSectorSize uint32 = C.SFileMpqSectorSize                       // Sector size (DWORD)
+const SFileMpqNumberOfFiles uint32 = C.SFileMpqNumberOfFiles                 // Number of files (DWORD)
+const SFileMpqRawChunkSize uint32 = C.SFileMpqRawChunkSize                   // Size of the raw data chunk for MD5
+const SFileMpqStreamFlags uint32 = C.SFileMpqStreamFlags                     // Stream flags (DWORD)
+const SFileMpqFlags uint32 = C.SFileMpqFlags                                 // Nonzero if the MPQ is read only (DWORD)
+
+// Information classes for SFileGetFileInfo (files)
+const SFileInfoPatchChain uint32 = C.SFileInfoPatchChain             // Chain of patches where the file is (TCHAR [])
+const SFileInfoFileEntry uint32 = C.SFileInfoFileEntry               // The file entry for the file (TFileEntry)
+const SFileInfoHashEntry uint32 = C.SFileInfoHashEntry               // Hash table entry for the file (TMPQHash)
+const SFileInfoHashIndex uint32 = C.SFileInfoHashIndex               // Index of the hash table entry (DWORD)
+const SFileInfoNameHash1 uint32 = C.SFileInfoNameHash1               // The first name hash in the hash table (DWORD)
+const SFileInfoNameHash2 uint32 = C.SFileInfoNameHash2               // The second name hash in the hash table (DWORD)
+const SFileInfoNameHash3 uint32 = C.SFileInfoNameHash3               // 64-bit file name hash for the HET/BET tables (ULONGLONG)
+const SFileInfoLocale uint32 = C.SFileInfoLocale                     // File locale (DWORD)
+const SFileInfoFileIndex uint32 = C.SFileInfoFileIndex               // Block index (DWORD)
+const SFileInfoByteOffset uint32 = C.SFileInfoByteOffset             // File position in the archive (ULONGLONG)
+const SFileInfoFileTime uint32 = C.SFileInfoFileTime                 // File time (ULONGLONG)
+const SFileInfoFileSize uint32 = C.SFileInfoFileSize                 // Size of the file (DWORD)
+const SFileInfoCompressedSize uint32 = C.SFileInfoCompressedSize     // Compressed file size (DWORD)
+const SFileInfoFlags uint32 = C.SFileInfoFlags                       // File flags from (DWORD)
+const SFileInfoEncryptionKey uint32 = C.SFileInfoEncryptionKey       // File encryption key
+const SFileInfoEncryptionKeyRaw uint32 = C.SFileInfoEncryptionKeyRaw // Unfixed value of the file key
+const SFileInfoCRC32 uint32 = C.SFileInfoCRC32                       // CRC32 of the file
+
+// Work types for the compact callback
+const CCB_CHECKING_FILES uint32 = C.CCB_CHECKING_FILES             // Checking archive (processed = current, total = total)
+const CCB_CHECKING_HASH_TABLE uint32 = C.CCB_CHECKING_HASH_TABLE   // Checking hash table (processed = current, total = total)
+const CCB_COPYING_NON_MPQ_DATA uint32 = C.CCB_COPYING_NON_MPQ_DATA // Copying non-MPQ data: No params used
+const CCB_COMPACTING_FILES uint32 = C.CCB_COMPACTING_FILES         // Compacting archive (processed = current, total = total)
+const CCB_CLOSING_ARCHIVE uint32 = C.CCB_CLOSING_ARCHIVE           // Closing archive: No params used
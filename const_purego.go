@@ -0,0 +1,81 @@
+//go:build purego
+
+package storm
+
+// Constants of the purego build, with the values StormLib has on non-Windows systems.
+
+const MAX_PATH uint32 = 1024
+
+// Flags for SFileOpenArchive
+const STREAM_PROVIDER_FLAT uint32 = 0x00000000    // Stream is linear with no offset mapping
+const STREAM_PROVIDER_PARTIAL uint32 = 0x00000010 // Stream is partial file (.part)
+const STREAM_PROVIDER_MPQE uint32 = 0x00000020    // Stream is an encrypted MPQ
+const STREAM_PROVIDER_BLOCK4 uint32 = 0x00000030  // 0x4000 per block, text MD5 after each block, max 0x2000 blocks per file
+
+const BASE_PROVIDER_FILE uint32 = 0x00000000 // Base data source is a file
+const BASE_PROVIDER_MAP uint32 = 0x00000001  // Base data source is memory-mapped file
+const BASE_PROVIDER_HTTP uint32 = 0x00000002 // Base data source is a file on web server
+
+const STREAM_FLAG_READ_ONLY uint32 = 0x00000100         // Stream is read only
+const STREAM_FLAG_WRITE_SHARE uint32 = 0x00000200       // This flag causes the writable MPQ being open for write share. Use with caution. If two applications write to an open MPQ simultaneously, the MPQ data get corrupted.
+const STREAM_FLAG_USE_BITMAP uint32 = 0x00000400        // If the file has a file bitmap, load it and use it
+const MPQ_OPEN_NO_LISTFILE uint32 = 0x00010000          // Don't load the internal listfile
+const MPQ_OPEN_NO_ATTRIBUTES uint32 = 0x00020000        // Don't open the attributes
+const MPQ_OPEN_NO_HEADER_SEARCH uint32 = 0x00040000     // Don't search for the MPQ header past the begin of the file
+const MPQ_OPEN_FORCE_MPQ_V1 uint32 = 0x00080000         // Always open the archive as MPQ v 1.00, ignore the "wFormatVersion" variable in the header
+const MPQ_OPEN_CHECK_SECTOR_CRC uint32 = 0x00100000     // On files with MPQ_FILE_SECTOR_CRC, the CRC will be checked when reading file
+const MPQ_OPEN_READ_ONLY uint32 = STREAM_FLAG_READ_ONLY // This flag is deprecated. Use STREAM_FLAG_READ_ONLY instead.
+
+// Values for SFileOpenFile
+const SFILE_OPEN_FROM_MPQ uint32 = 0x00000000   // Open the file from the MPQ archive
+const SFILE_OPEN_LOCAL_FILE uint32 = 0xFFFFFFFF // Open a local file
+
+// File flags, see FileFindData.FileFlags
+const MPQ_FILE_IMPLODE uint32 = 0x00000100       // Implode method (By PKWARE Data Compression Library)
+const MPQ_FILE_COMPRESS uint32 = 0x00000200      // Compress methods (By multiple methods)
+const MPQ_FILE_ENCRYPTED uint32 = 0x00010000     // Indicates whether file is encrypted
+const MPQ_FILE_FIX_KEY uint32 = 0x00020000       // File decryption key has to be fixed
+const MPQ_FILE_PATCH_FILE uint32 = 0x00100000    // The file is a patch file. Raw file data begin with TPatchInfo structure
+const MPQ_FILE_SINGLE_UNIT uint32 = 0x01000000   // File is stored as a single unit, rather than split into sectors (Thx, Quantam)
+const MPQ_FILE_DELETE_MARKER uint32 = 0x02000000 // File is a deletion marker. Used in MPQ patches, indicating that the file no longer exists.
+const MPQ_FILE_SECTOR_CRC uint32 = 0x04000000    // File has checksums for each sector. Ignored if file is not compressed or imploded.
+const MPQ_FILE_SIGNATURE uint32 = 0x10000000     // Present on STANDARD.SNP\(signature). The only occurence ever observed
+const MPQ_FILE_EXISTS uint32 = 0x80000000        // Set if file exists, reset when the file was deleted
+
+// Compression types, found in the first byte of compressed sectors
+const MPQ_COMPRESSION_HUFFMANN uint32 = 0x01     // Huffmann compression (used on WAVE files only)
+const MPQ_COMPRESSION_ZLIB uint32 = 0x02         // ZLIB compression
+const MPQ_COMPRESSION_PKWARE uint32 = 0x08       // PKWARE DCL compression
+const MPQ_COMPRESSION_BZIP2 uint32 = 0x10        // BZIP2 compression (added in Warcraft III)
+const MPQ_COMPRESSION_SPARSE uint32 = 0x20       // Sparse compression (added in Starcraft 2)
+const MPQ_COMPRESSION_ADPCM_MONO uint32 = 0x40   // IMA ADPCM compression (mono)
+const MPQ_COMPRESSION_ADPCM_STEREO uint32 = 0x80 // IMA ADPCM compression (stereo)
+const MPQ_COMPRESSION_LZMA uint32 = 0x12         // LZMA compression. Added in Starcraft 2. This value is NOT a combination of flags.
+
+// Error codes
+const ERROR_SUCCESS uint32 = 0
+const ERROR_FILE_NOT_FOUND uint32 = 2
+const ERROR_ACCESS_DENIED uint32 = 1
+const ERROR_INVALID_HANDLE uint32 = 9
+const ERROR_NOT_ENOUGH_MEMORY uint32 = 12
+const ERROR_NOT_SUPPORTED uint32 = 95
+const ERROR_INVALID_PARAMETER uint32 = 22
+const ERROR_NEGATIVE_SEEK uint32 = 29
+const ERROR_DISK_FULL uint32 = 28
+const ERROR_ALREADY_EXISTS uint32 = 17
+const ERROR_INSUFFICIENT_BUFFER uint32 = 105
+const ERROR_BAD_FORMAT uint32 = 1000
+const ERROR_NO_MORE_FILES uint32 = 1001
+const ERROR_HANDLE_EOF uint32 = 1002
+const ERROR_CAN_NOT_COMPLETE uint32 = 1003
+const ERROR_FILE_CORRUPT uint32 = 1004
+
+// Return value for SFileGetFileSize and SFileSetFilePointer
+const SFILE_INVALID_SIZE uint32 = 0xFFFFFFFF
+const SFILE_INVALID_POS uint32 = 0xFFFFFFFF
+const SFILE_INVALID_ATTRIBUTES uint32 = 0xFFFFFFFF
+
+// Move methods for SFileSetFilePointer
+const FILE_BEGIN uint32 = 0   // The starting point is 0 (zero) or the beginning of the file.
+const FILE_CURRENT uint32 = 1 // The starting point is the current file pointer.
+const FILE_END uint32 = 2     // The starting point is the current end of file.
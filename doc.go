@@ -0,0 +1,6 @@
+// Go bindings for StormLib, a library for manipulating MPQ archives.
+//
+// Building with the purego tag replaces the bindings with a read-only implementation in pure Go, for platforms without
+// cgo or a StormLib build. It opens archives, and reads and finds their files, including the file system view, pools
+// and extracting; the functions that create, write or verify archives are left out.
+package storm
@@ -1,22 +1,113 @@
 package storm
 
-// #include <StormLib.h>
-import "C"
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+)
 
+var (
+	ErrNotFound           = errors.New("storm: file not found")                                     // Matches ERROR_FILE_NOT_FOUND
+	ErrAccessDenied       = errors.New("storm: access denied")                                      // Matches ERROR_ACCESS_DENIED
+	ErrInvalidHandle      = errors.New("storm: invalid handle")                                     // Matches ERROR_INVALID_HANDLE
+	ErrOutOfMemory        = errors.New("storm: not enough memory")                                  // Matches ERROR_NOT_ENOUGH_MEMORY
+	ErrNotSupported       = errors.New("storm: not supported")                                      // Matches ERROR_NOT_SUPPORTED
+	ErrInvalidParameter   = errors.New("storm: invalid parameter")                                  // Matches ERROR_INVALID_PARAMETER
+	ErrNegativeSeek       = errors.New("storm: negative seek")                                      // Matches ERROR_NEGATIVE_SEEK
+	ErrDiskFull           = errors.New("storm: disk full")                                          // Matches ERROR_DISK_FULL
+	ErrAlreadyExists      = errors.New("storm: file already exists")                                // Matches ERROR_ALREADY_EXISTS
+	ErrInsufficientBuffer = errors.New("storm: insufficient buffer")                                // Matches ERROR_INSUFFICIENT_BUFFER
+	ErrBadFormat          = errors.New("storm: bad format")                                         // Matches ERROR_BAD_FORMAT
+	ErrNoMoreFiles        = errors.New("storm: no more files")                                      // Matches ERROR_NO_MORE_FILES
+	ErrCanNotComplete     = errors.New("storm: operation can not be completed")                     // Matches ERROR_CAN_NOT_COMPLETE
+	ErrFileCorrupt        = errors.New("storm: file corrupt")                                       // Matches ERROR_FILE_CORRUPT
+	ErrReadOnly           = errors.New("storm: archive is read-only")                               // Matches ERROR_ACCESS_DENIED, which is returned when writing to a read-only archive
+	ErrFileSizeExceeded   = errors.New("storm: data exceeds the declared file size")                // More data written than declared when creating the file
+	ErrFileIncomplete     = errors.New("storm: file finished before its declared size was written") // Less data written than declared when creating the file
+)
+
+// Sentinel errors for the StormLib error codes.
+var codeErrors = map[uint32]error{
+	ERROR_FILE_NOT_FOUND:      ErrNotFound,
+	ERROR_ACCESS_DENIED:       ErrAccessDenied,
+	ERROR_INVALID_HANDLE:      ErrInvalidHandle,
+	ERROR_NOT_ENOUGH_MEMORY:   ErrOutOfMemory,
+	ERROR_NOT_SUPPORTED:       ErrNotSupported,
+	ERROR_INVALID_PARAMETER:   ErrInvalidParameter,
+	ERROR_NEGATIVE_SEEK:       ErrNegativeSeek,
+	ERROR_DISK_FULL:           ErrDiskFull,
+	ERROR_ALREADY_EXISTS:      ErrAlreadyExists,
+	ERROR_INSUFFICIENT_BUFFER: ErrInsufficientBuffer,
+	ERROR_BAD_FORMAT:          ErrBadFormat,
+	ERROR_NO_MORE_FILES:       ErrNoMoreFiles,
+	ERROR_CAN_NOT_COMPLETE:    ErrCanNotComplete,
+	ERROR_FILE_CORRUPT:        ErrFileCorrupt,
+}
+
+// Error returned by StormLib. If Op is set, the error is formatted like *fs.PathError.
 type StormError struct {
-	Code    uint32
-	Message string
+	Code    uint32 // StormLib error code, see ERROR_*
+	Op      string // Operation that failed (empty if unknown)
+	Path    string // Name of the file or archive the operation was done on (empty if unknown)
+	Message string // Description of the error
 }
 
 // Implementation of the error interface.
 func (err *StormError) Error() string {
-	return err.Message
+	switch {
+	case err.Op != "" && err.Path != "":
+		return fmt.Sprintf("storm: %s %s: %s (code: %d)", err.Op, err.Path, err.Message, err.Code)
+	case err.Op != "":
+		return fmt.Sprintf("storm: %s: %s (code: %d)", err.Op, err.Message, err.Code)
+	}
+
+	return fmt.Sprintf("storm: %s (code: %d)", err.Message, err.Code)
+}
+
+// Creates an error for an operation on a file or archive, with a message more specific than the error code.
+func newStormError(code uint32, op string, path string, message string) *StormError {
+	return &StormError{
+		Code:    code,
+		Op:      op,
+		Path:    path,
+		Message: message,
+	}
 }
 
-func newStormError(code uint32, message string) *StormError {
+// Creates an error for an operation on a file or archive, described by the error code.
+func newPathError(code uint32, op string, path string) *StormError {
+	message := fmt.Sprintf("error %d", code)
+	if err, ok := codeErrors[code]; ok {
+		message = strings.TrimPrefix(err.Error(), "storm: ")
+	}
+
 	return &StormError{
 		Code:    code,
-		Message: fmt.Sprintf("storm: %s (code: %d)", message, code),
+		Op:      op,
+		Path:    path,
+		Message: message,
 	}
 }
+
+// Reports whether the error matches one of the sentinel errors, for use with errors.Is.
+//
+// The error also matches fs.ErrNotExist, fs.ErrExist, fs.ErrPermission, fs.ErrInvalid and io.EOF for the corresponding codes.
+func (err *StormError) Is(target error) bool {
+	switch target {
+	case ErrReadOnly, fs.ErrPermission:
+		return err.Code == ERROR_ACCESS_DENIED
+	case fs.ErrNotExist:
+		return err.Code == ERROR_FILE_NOT_FOUND
+	case fs.ErrExist:
+		return err.Code == ERROR_ALREADY_EXISTS
+	case fs.ErrInvalid:
+		return err.Code == ERROR_INVALID_PARAMETER || err.Code == ERROR_INVALID_HANDLE
+	case io.EOF:
+		return err.Code == ERROR_HANDLE_EOF
+	}
+
+	codeErr, ok := codeErrors[err.Code]
+	return ok && codeErr == target
+}
@@ -0,0 +1,25 @@
+//go:build !purego
+
+package storm
+
+import "runtime/cgo"
+
+// Returns the handles of the progress callbacks registered on an archive, 0 for those not set.
+func CallbackHandles(a *Archive) (addFile uintptr, compact uintptr, download uintptr) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	return uintptr(a.addFileCallback), uintptr(a.compactCallback), uintptr(a.downloadCallback)
+}
+
+// Reports whether a callback handle has not been deleted yet.
+func HandleLive(handle uintptr) (live bool) {
+	defer func() {
+		if recover() != nil {
+			live = false
+		}
+	}()
+
+	cgo.Handle(handle).Value()
+	return true
+}
@@ -0,0 +1,299 @@
+package storm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// Options for extracting files from an archive.
+type ExtractOptions struct {
+	Mask          string                                // Mask of the files to extract ("*" if empty)
+	Workers       int                                   // Number of files extracted in parallel, each through its own archive handle (GOMAXPROCS if 0)
+	Overwrite     bool                                  // Replace existing files instead of skipping them
+	PreserveTimes bool                                  // Set the modification time of the extracted files to their file time in the archive
+	PathMapper    func(fileName string) (string, error) // Maps a name in the archive to a slash-separated path below the destination, or "" to skip the file (SafePath if nil)
+	Progress      func(progress ExtractProgress)        // Called after each file, one call at a time (none if nil)
+}
+
+// Progress of extracting files, passed to ExtractOptions.Progress.
+type ExtractProgress struct {
+	FileName string // Name of the file in the archive
+	Path     string // Path of the extracted file on the local drive (empty if skipped by the path mapper)
+	Done     int    // Number of files processed so far, including this one
+	Total    int    // Number of files to process
+	Skipped  bool   // The file was skipped, because it exists or by the path mapper
+	Err      error  // Error extracting the file (nil if extracted or skipped)
+}
+
+// Error extracting a single file.
+type ExtractError struct {
+	FileName string // Name of the file in the archive
+	Path     string // Path of the extracted file on the local drive (empty if not mapped)
+	Err      error
+}
+
+// Implementation of the error interface.
+func (err *ExtractError) Error() string {
+	return fmt.Sprintf("storm: extract %s: %v", err.FileName, err.Err)
+}
+
+// Returns the underlying error, for use with errors.Is and errors.As.
+func (err *ExtractError) Unwrap() error {
+	return err.Err
+}
+
+// Report of extracting files from an archive.
+type ExtractReport struct {
+	Extracted int             // Number of files extracted
+	Skipped   int             // Number of files skipped, because they exist or by the path mapper
+	Bytes     int64           // Number of bytes written
+	Errors    []*ExtractError // Errors of the files that could not be extracted
+}
+
+// Converts a name in the archive to a slash-separated relative path, rejecting names that would escape the destination
+// or that Windows maps to a device or another name.
+func SafePath(fileName string) (string, error) {
+	name := strings.ReplaceAll(fileName, "\\", "/")
+	if name == "" || !isLocal(filepath.FromSlash(name)) || strings.Contains(name, ":") {
+		return "", newPathError(ERROR_INVALID_PARAMETER, "map", fileName)
+	}
+
+	for _, part := range strings.Split(name, "/") {
+		if part == "" || part == "." || part == ".." {
+			return "", newPathError(ERROR_INVALID_PARAMETER, "map", fileName)
+		}
+	}
+
+	return name, nil
+}
+
+// Extracts the files matching the mask to a directory on the local drive, in parallel.
+//
+// Pending changes are flushed first, then each worker reads through its own read-only handle to the archive file, opened
+// with the flags of the archive, so workers do not wait for each other on the archive lock. Their calls into StormLib
+// still run one at a time, as described at Pool, while writing the extracted files overlaps.
+//
+// Errors of single files are collected in the report; the returned error is set only if the files could not be
+// enumerated or the context was canceled, in which case the partial report is returned.
+func (a *Archive) ExtractAll(ctx context.Context, destDir string, options ExtractOptions) (*ExtractReport, error) {
+	var report ExtractReport
+	var files []FileFindData
+
+	if options.Mask == "" {
+		options.Mask = "*"
+	}
+	if options.Workers <= 0 {
+		options.Workers = runtime.GOMAXPROCS(0)
+	}
+	if options.PathMapper == nil {
+		options.PathMapper = SafePath
+	}
+
+	// A file stored under several locales is found once for each of them
+	seen := make(map[string]bool)
+	err := a.forEachFile(options.Mask, func(findFileData *FileFindData) {
+		if !seen[findFileData.FileName] {
+			seen[findFileData.FileName] = true
+			files = append(files, *findFileData)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if options.Workers > len(files) {
+		options.Workers = len(files)
+	}
+
+	// A single worker reads through the archive itself, more workers through a pool of their own handles
+	var pool *Pool
+	if options.Workers > 1 {
+		err = a.SFileFlushArchive()
+		if err != nil {
+			return nil, err
+		}
+		pool, err = OpenPool(a.name, options.Workers, a.flags)
+		if err != nil {
+			return nil, err
+		}
+		defer pool.Close()
+	}
+
+	var mutex sync.Mutex // Guards report and the calls to options.Progress
+	var wg sync.WaitGroup
+
+	jobs := make(chan FileFindData)
+	for i := 0; i < options.Workers; i++ {
+		worker := a
+		if pool != nil {
+			worker = <-pool.archives
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for findFileData := range jobs {
+				fileName := findFileData.FileName
+				path, written, skipped, err := worker.extractTo(destDir, &findFileData, &options)
+
+				mutex.Lock()
+				switch {
+				case err != nil:
+					report.Errors = append(report.Errors, &ExtractError{FileName: fileName, Path: path, Err: err})
+				case skipped:
+					report.Skipped++
+				default:
+					report.Extracted++
+					report.Bytes += written
+				}
+				if options.Progress != nil {
+					options.Progress(ExtractProgress{
+						FileName: fileName,
+						Path:     path,
+						Done:     report.Extracted + report.Skipped + len(report.Errors),
+						Total:    len(files),
+						Skipped:  skipped,
+						Err:      err,
+					})
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	for _, findFileData := range files {
+		err = ctx.Err()
+		if err != nil {
+			break
+		}
+		select {
+		case jobs <- findFileData:
+		case <-ctx.Done():
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		return &report, err
+	}
+
+	return &report, nil
+}
+
+// Extracts a single file below the destination directory.
+func (a *Archive) extractTo(destDir string, findFileData *FileFindData, options *ExtractOptions) (path string, written int64, skipped bool, err error) {
+	fileName := findFileData.FileName
+
+	name, err := options.PathMapper(fileName)
+	if err != nil {
+		return "", 0, false, err
+	}
+	if name == "" {
+		return "", 0, true, nil
+	}
+	if !isLocal(filepath.FromSlash(name)) {
+		return "", 0, false, newPathError(ERROR_INVALID_PARAMETER, "map", name)
+	}
+	path = filepath.Join(destDir, filepath.FromSlash(name))
+
+	reader, err := a.SFileOpenFileEx(fileName, SFILE_OPEN_FROM_MPQ)
+	if err != nil {
+		return path, 0, false, err
+	}
+	defer reader.SFileCloseFile()
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return path, 0, false, err
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !options.Overwrite {
+		flags |= os.O_EXCL
+	}
+	file, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		if errors.Is(err, fs.ErrExist) && !options.Overwrite {
+			return path, 0, true, nil
+		}
+		return path, 0, false, err
+	}
+
+	written, err = reader.WriteTo(file)
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return path, written, false, err
+	}
+
+	if options.PreserveTimes {
+		modTime := findFileData.FileTime()
+		if !modTime.IsZero() {
+			err = os.Chtimes(path, modTime, modTime)
+			if err != nil {
+				return path, written, false, err
+			}
+		}
+	}
+
+	return path, written, false, nil
+}
+
+// Reports whether the path is relative, not empty and does not leave the directory it is resolved against, like
+// filepath.IsLocal. As archives come from Windows, elements that Windows maps elsewhere are rejected on every platform.
+func isLocal(path string) bool {
+	if path == "" || filepath.IsAbs(path) || filepath.VolumeName(path) != "" || os.IsPathSeparator(path[0]) {
+		return false
+	}
+
+	path = filepath.Clean(path)
+	if path == ".." || strings.HasPrefix(path, ".."+string(filepath.Separator)) {
+		return false
+	}
+
+	for _, element := range strings.Split(path, string(filepath.Separator)) {
+		if isReservedName(element) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Reports whether a path element is a device name on Windows, such as NUL or COM1.txt, or ends in a dot or a space,
+// which Windows drops.
+func isReservedName(element string) bool {
+	if element == "." || element == ".." {
+		return false
+	}
+	if strings.HasSuffix(element, ".") || strings.HasSuffix(element, " ") {
+		return true
+	}
+
+	// The extension and spaces before it do not change the device
+	if i := strings.IndexByte(element, '.'); i >= 0 {
+		element = element[:i]
+	}
+	element = strings.ToUpper(strings.TrimRight(element, " "))
+
+	switch element {
+	case "CON", "PRN", "AUX", "NUL", "CONIN$", "CONOUT$":
+		return true
+	}
+
+	return len(element) == 4 && (strings.HasPrefix(element, "COM") || strings.HasPrefix(element, "LPT")) && '0' <= element[3] && element[3] <= '9'
+}
@@ -1,29 +1,30 @@
+//go:build !purego
+
 package storm
 
-// #include <StormLib.h>
+// #include "lasterror.h"
 import "C"
-import "unsafe"
 
-type FileFinder struct {
-	handle C.HANDLE
-}
+import (
+	"runtime"
+	"unsafe"
+)
 
-type FileFindData struct {
-	FileName   string // Name of the found file
-	PlainName  string // Plain name of the found file
-	HashIndex  uint32 // Hash table index for the file
-	BlockIndex uint32 // Block table index for the file
-	FileSize   uint32 // Uncompressed size of the file, in bytes
-	FileFlags  uint32 // MPQ file flags
-	CompSize   uint32 // Compressed file size
-	FileTimeLo uint32 // Low 32-bits of the file time (0 if not present)
-	FileTimeHi uint32 // High 32-bits of the file time (0 if not present)
-	Locale     uint32 // Locale version
+type FileFinder struct {
+	handle  C.HANDLE
+	archive *Archive // Archive being searched, kept alive while the search is open
+	name    string   // Search mask, used in errors
 }
 
 // Finds a first file matching the specification.
 func (a *Archive) SFileFindFirstFile(mask string, listFile string) (*FileFinder, *FileFindData, error) {
-	var f FileFinder
+	err := a.lock("find")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer a.unlock()
+
+	f := FileFinder{archive: a, name: mask}
 	var findFileData FileFindData
 
 	cFindFileData := new(C.SFILE_FIND_DATA)
@@ -33,36 +34,78 @@ func (a *Archive) SFileFindFirstFile(mask string, listFile string) (*FileFinder,
 	defer C.free(unsafe.Pointer(cMask))
 	defer C.free(unsafe.Pointer(cListFile))
 
-	f.handle = C.SFileFindFirstFile(a.handle, cMask, cFindFileData, cListFile)
+	var errorCode C.DWORD
+	f.handle = C.shimSFileFindFirstFile(a.handle, cMask, cFindFileData, cListFile, &errorCode)
 	if f.handle != nil {
 		goFindFileData(cFindFileData, &findFileData)
+		a.addChild(f.handle, releaseFileFinder)
+		runtime.SetFinalizer(&f, (*FileFinder).finalize)
 		return &f, &findFileData, nil
 	}
 
-	return nil, nil, newStormError(uint32(C.GetLastError()), "SFileFindFirstFile")
+	return nil, nil, newPathError(uint32(errorCode), "find", mask)
 }
 
 // Finds a next file matching the specification.
 func (f *FileFinder) SFileFindNextFile() (*FileFindData, error) {
+	err := f.archive.lockHandle(&f.handle, "find", f.name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.archive.unlock()
+
 	var findFileData FileFindData
 
 	cFindFileData := new(C.SFILE_FIND_DATA)
 
-	if C.SFileFindNextFile(f.handle, cFindFileData) != 0 {
+	var errorCode C.DWORD
+	if C.shimSFileFindNextFile(f.handle, cFindFileData, &errorCode) != 0 {
 		goFindFileData(cFindFileData, &findFileData)
 		return &findFileData, nil
 	}
 
-	return nil, newStormError(uint32(C.GetLastError()), "SFileFindNextFile")
+	return nil, newPathError(uint32(errorCode), "find", f.name)
 }
 
-// Stops searching in MPQ.
+// Stops searching in MPQ. The handle is released even if the close fails.
 func (f *FileFinder) SFileFindClose() error {
-	if C.SFileFindClose(f.handle) != 0 {
+	err := f.archive.lockHandle(&f.handle, "close", f.name)
+	if err != nil {
+		return err
+	}
+	defer f.archive.unlock()
+
+	var errorCode C.DWORD
+	result := C.shimSFileFindClose(f.handle, &errorCode)
+
+	f.archive.removeChild(f.handle)
+	f.handle = nil
+	runtime.SetFinalizer(f, nil)
+
+	if result != 0 {
 		return nil
 	}
 
-	return newStormError(uint32(C.GetLastError()), "failed to close file finder")
+	return newPathError(uint32(errorCode), "close", f.name)
+}
+
+// Implementation of the io.Closer interface.
+func (f *FileFinder) Close() error {
+	return f.SFileFindClose()
+}
+
+// Closes the search if it was not closed before it became unreachable.
+func (f *FileFinder) finalize() {
+	if f.handle != nil {
+		reportLeak("file finder", f.name)
+		f.SFileFindClose()
+	}
+}
+
+// Closes a search handle left open when its archive is closed.
+func releaseFileFinder(handle C.HANDLE) {
+	var errorCode C.DWORD
+	C.shimSFileFindClose(handle, &errorCode)
 }
 
 func goFindFileData(c *C.SFILE_FIND_DATA, g *FileFindData) {
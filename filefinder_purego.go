@@ -0,0 +1,107 @@
+//go:build purego
+
+package storm
+
+import (
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/slyh/go-stormlib/internal/mpq"
+)
+
+type FileFinder struct {
+	handle  *mpq.Finder
+	archive *Archive // Archive being searched, kept alive while the search is open
+	name    string   // Search mask, used in errors
+}
+
+// Finds a first file matching the specification.
+func (a *Archive) SFileFindFirstFile(mask string, listFile string) (*FileFinder, *FileFindData, error) {
+	err := a.lock("find")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer a.unlock()
+
+	// Like StormLib, a list file that cannot be read is ignored
+	if listFile != "" {
+		if data, err := os.ReadFile(listFile); err == nil {
+			a.handle.AddListFile(data)
+		}
+	}
+
+	f := FileFinder{handle: a.handle.Find(mask), archive: a, name: mask}
+
+	entry, ok := f.handle.Next()
+	if !ok {
+		return nil, nil, newPathError(ERROR_NO_MORE_FILES, "find", mask)
+	}
+
+	runtime.SetFinalizer(&f, (*FileFinder).finalize)
+	return &f, newFindFileData(entry), nil
+}
+
+// Finds a next file matching the specification.
+func (f *FileFinder) SFileFindNextFile() (*FileFindData, error) {
+	err := lockHandle(f.archive, &f.handle, "find", f.name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.archive.unlock()
+
+	entry, ok := f.handle.Next()
+	if !ok {
+		return nil, newPathError(ERROR_NO_MORE_FILES, "find", f.name)
+	}
+
+	return newFindFileData(entry), nil
+}
+
+// Stops searching in MPQ.
+func (f *FileFinder) SFileFindClose() error {
+	err := lockHandle(f.archive, &f.handle, "close", f.name)
+	if err != nil {
+		return err
+	}
+	defer f.archive.unlock()
+
+	f.handle = nil
+	runtime.SetFinalizer(f, nil)
+
+	return nil
+}
+
+// Implementation of the io.Closer interface.
+func (f *FileFinder) Close() error {
+	return f.SFileFindClose()
+}
+
+// Closes the search if it was not closed before it became unreachable.
+func (f *FileFinder) finalize() {
+	if f.handle != nil {
+		reportLeak("file finder", f.name)
+		f.SFileFindClose()
+	}
+}
+
+func newFindFileData(entry mpq.Entry) *FileFindData {
+	g := FileFindData{
+		FileName:   entry.Name,
+		PlainName:  entry.Name[strings.LastIndexAny(entry.Name, "\\/")+1:],
+		HashIndex:  entry.HashIndex,
+		BlockIndex: entry.BlockIndex,
+		FileSize:   entry.FileSize,
+		FileFlags:  entry.Flags,
+		CompSize:   entry.CompSize,
+		FileTimeLo: uint32(entry.FileTime),
+		FileTimeHi: uint32(entry.FileTime >> 32),
+		Locale:     uint32(entry.Locale),
+	}
+
+	if len(g.FileName) > int(MAX_PATH) {
+		g.FileName = g.FileName[:MAX_PATH]
+	}
+
+	return &g
+}
@@ -1,107 +1,248 @@
+//go:build !purego
+
 package storm
 
-// #include <StormLib.h>
+// #include "lasterror.h"
 import "C"
 
 import (
-	"io"
+	"io/fs"
+	"runtime"
+	"strings"
+	"sync"
 	"unsafe"
 )
 
+// A file opened for reading from an archive.
+//
+// A FileReader may be used from multiple goroutines. ReadAt leaves the file pointer unchanged, while Read and Seek share it.
 type FileReader struct {
-	handle C.HANDLE
+	handle  C.HANDLE
+	archive *Archive   // Archive the file belongs to, kept alive while the file is open
+	name    string     // Name of the file, used in errors
+	mutex   sync.Mutex // Serializes the use of the file pointer
 }
 
 // Opens a file from MPQ archive.
 func (a *Archive) SFileOpenFileEx(fileName string, searchScope uint32) (*FileReader, error) {
-	var f FileReader
+	err := a.lock("open")
+	if err != nil {
+		return nil, err
+	}
+	defer a.unlock()
+
+	f := FileReader{archive: a, name: fileName}
 
 	cFileName := C.CString(fileName)
 	defer C.free(unsafe.Pointer(cFileName))
 
-	if C.SFileOpenFileEx(a.handle, cFileName, C.DWORD(searchScope), &f.handle) != 0 {
+	var errorCode C.DWORD
+	if C.shimSFileOpenFileEx(a.handle, cFileName, C.DWORD(searchScope), &f.handle, &errorCode) != 0 {
+		a.addChild(f.handle, releaseFileReader)
+		runtime.SetFinalizer(&f, (*FileReader).finalize)
 		return &f, nil
 	}
 
-	return nil, newStormError(uint32(C.GetLastError()), "failed to open file")
+	return nil, newPathError(uint32(errorCode), "open", fileName)
 }
 
 // Retrieves a size of the file within archive.
 func (f *FileReader) SFileGetFileSize() (fileSize uint64, err error) {
+	err = f.archive.lockHandle(&f.handle, "get size", f.name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.archive.unlock()
+
 	var fileSizeHigh C.DWORD
 
-	fileSizeLow := C.SFileGetFileSize(f.handle, &fileSizeHigh)
+	var errorCode C.DWORD
+	fileSizeLow := C.shimSFileGetFileSize(f.handle, &fileSizeHigh, &errorCode)
 	if fileSizeLow != C.SFILE_INVALID_SIZE {
 		return uint64(fileSizeHigh)<<32 | uint64(fileSizeLow), nil
 	}
 
-	return 0, newStormError(uint32(C.GetLastError()), "failed to get file size")
+	return 0, newPathError(uint32(errorCode), "get size", f.name)
 }
 
 // Sets current position in an open file.
 func (f *FileReader) SFileSetFilePointer(filePos uint64, moveMethod uint32) (pos uint64, err error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.setFilePointer(filePos, moveMethod)
+}
+
+func (f *FileReader) setFilePointer(filePos uint64, moveMethod uint32) (pos uint64, err error) {
+	err = f.archive.lockHandle(&f.handle, "seek", f.name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.archive.unlock()
+
 	filePosHigh := C.LONG(filePos >> 32)
 	filePosLow := C.DWORD(filePos)
 
-	filePosLow = C.SFileSetFilePointer(f.handle, C.LONG(filePosLow), &filePosHigh, C.DWORD(moveMethod))
+	var errorCode C.DWORD
+	filePosLow = C.shimSFileSetFilePointer(f.handle, C.LONG(filePosLow), &filePosHigh, C.DWORD(moveMethod), &errorCode)
 	if filePosLow != C.SFILE_INVALID_SIZE {
 		return uint64(filePosHigh)<<32 | uint64(filePosLow), nil
 	}
 
-	return 0, newStormError(uint32(C.GetLastError()), "failed to set file pointer")
+	return 0, newPathError(uint32(errorCode), "seek", f.name)
 }
 
 // Reads data from the file.
 func (f *FileReader) SFileReadFile(buffer []uint8) (n uint32, err error) {
-	var read C.DWORD
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.readFile(buffer)
+}
 
-	if C.SFileReadFile(f.handle, unsafe.Pointer(&buffer[0]), C.DWORD(len(buffer)), &read, nil) != 0 {
+func (f *FileReader) readFile(buffer []uint8) (n uint32, err error) {
+	err = f.archive.lockHandle(&f.handle, "read", f.name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.archive.unlock()
+
+	var read, errorCode C.DWORD
+
+	if len(buffer) == 0 {
+		return 0, nil
+	}
+
+	if C.shimSFileReadFile(f.handle, unsafe.Pointer(&buffer[0]), C.DWORD(len(buffer)), &read, &errorCode) != 0 {
 		return uint32(read), nil
 	}
 
-	return uint32(read), newStormError(uint32(C.GetLastError()), "failed to read file")
+	return uint32(read), newPathError(uint32(errorCode), "read", f.name)
 }
 
-// Implementation of the io.Reader interface.
-func (f *FileReader) Read(buffer []byte) (int, error) {
-	n, err := f.SFileReadFile(buffer)
-	if err != nil && err.(*StormError).Code == ERROR_HANDLE_EOF {
-		err = io.EOF
+// Returns information about the open file.
+func (f *FileReader) Stat() (fs.FileInfo, error) {
+	fileName, err := f.SFileGetFileName()
+	if err != nil {
+		return nil, err
+	}
+
+	fileSize, err := f.SFileGetFileSize()
+	if err != nil {
+		return nil, err
 	}
-	return int(n), err
+
+	err = f.archive.lockHandle(&f.handle, "stat", f.name)
+	if err != nil {
+		return nil, err
+	}
+	q := infoQuery{handle: f.handle, name: f.name}
+	fileTime := q.uint64(SFileInfoFileTime, true)
+	f.archive.unlock()
+	if q.err != nil {
+		return nil, q.err
+	}
+
+	return fileInfo{&fsEntry{
+		name:         fileName[strings.LastIndex(fileName, "\\")+1:],
+		archivedName: fileName,
+		size:         int64(fileSize),
+		modTime:      fileTimeToTime(fileTime),
+	}}, nil
 }
 
-// Closes an open file.
+// Closes an open file. The handle is released even if the close fails.
 func (f *FileReader) SFileCloseFile() error {
-	if C.SFileCloseFile(f.handle) != 0 {
+	err := f.archive.lockHandle(&f.handle, "close", f.name)
+	if err != nil {
+		return err
+	}
+	defer f.archive.unlock()
+
+	var errorCode C.DWORD
+	result := C.shimSFileCloseFile(f.handle, &errorCode)
+
+	f.archive.removeChild(f.handle)
+	f.handle = nil
+	runtime.SetFinalizer(f, nil)
+
+	if result != 0 {
+		return nil
+	}
+
+	return newPathError(uint32(errorCode), "close", f.name)
+}
+
+// Implementation of the io.Closer interface.
+func (f *FileReader) Close() error {
+	return f.SFileCloseFile()
+}
+
+// Closes the file if it was not closed before it became unreachable.
+func (f *FileReader) finalize() {
+	if f.handle != nil {
+		reportLeak("file reader", f.name)
+		f.SFileCloseFile()
+	}
+}
+
+// Closes a file handle left open when its archive is closed.
+func releaseFileReader(handle C.HANDLE) {
+	var errorCode C.DWORD
+	C.shimSFileCloseFile(handle, &errorCode)
+}
+
+// Changes the locale of an open file. The archive must be writable.
+func (f *FileReader) SetLocale(locale Locale) error {
+	err := f.archive.lockHandle(&f.handle, "set locale", f.name)
+	if err != nil {
+		return err
+	}
+	defer f.archive.unlock()
+
+	var errorCode C.DWORD
+	if C.shimSFileSetFileLocale(f.handle, C.LCID(locale), &errorCode) != 0 {
 		return nil
 	}
 
-	return newStormError(uint32(C.GetLastError()), "failed to close file")
+	return newPathError(uint32(errorCode), "set locale", f.name)
 }
 
 // Quick check if the file exists within MPQ archive, without opening it.
 func (a *Archive) SFileHasFile(fileName string) (bool, error) {
+	err := a.lock("check")
+	if err != nil {
+		return false, err
+	}
+	defer a.unlock()
+
 	cFileName := C.CString(fileName)
 	defer C.free(unsafe.Pointer(cFileName))
 
-	if C.SFileHasFile(a.handle, cFileName) != 0 {
+	var errorCode C.DWORD
+	if C.shimSFileHasFile(a.handle, cFileName, &errorCode) != 0 {
 		return true, nil
 	}
 
-	errorCode := C.GetLastError()
 	if errorCode == C.ERROR_FILE_NOT_FOUND {
 		return false, nil
 	}
 
-	return false, newStormError(uint32(errorCode), "failed to check if file exists")
+	return false, newPathError(uint32(errorCode), "check", fileName)
 }
 
 // Retrieves name of an open file.
 func (f *FileReader) SFileGetFileName() (fileName string, err error) {
+	err = f.archive.lockHandle(&f.handle, "get name", f.name)
+	if err != nil {
+		return "", err
+	}
+	defer f.archive.unlock()
+
 	buffer := make([]uint8, MAX_PATH)
 
-	if C.SFileGetFileName(f.handle, (*C.char)(unsafe.Pointer(&buffer[0]))) != 0 {
+	var errorCode C.DWORD
+	if C.shimSFileGetFileName(f.handle, (*C.char)(unsafe.Pointer(&buffer[0])), &errorCode) != 0 {
 		fileName = C.GoString((*C.char)(unsafe.Pointer(&buffer[0])))
 		if len(fileName) > int(MAX_PATH) {
 			fileName = fileName[:MAX_PATH]
@@ -109,43 +250,63 @@ func (f *FileReader) SFileGetFileName() (fileName string, err error) {
 		return fileName, nil
 	}
 
-	return "", newStormError(uint32(C.GetLastError()), "failed to get file name")
+	return "", newPathError(uint32(errorCode), "get name", f.name)
 }
 
 // Verifies a file against its extended attributes.
 //
 // Return zero when no problerms were found. The function will return a nil err and non-zero result if the file can be opened but not passing the verifications.
 func (a *Archive) SFileVerifyFile(fileName string, flags uint32) (result uint32, err error) {
+	err = a.lock("verify")
+	if err != nil {
+		return 0, err
+	}
+	defer a.unlock()
+
 	cFileName := C.CString(fileName)
 	defer C.free(unsafe.Pointer(cFileName))
 
-	result = uint32(C.SFileVerifyFile(a.handle, cFileName, C.DWORD(flags)))
+	var errorCode C.DWORD
+	result = uint32(C.shimSFileVerifyFile(a.handle, cFileName, C.DWORD(flags), &errorCode))
 	if result == 0 {
 		return result, nil
 	}
 
 	if result&VERIFY_OPEN_ERROR == VERIFY_OPEN_ERROR {
-		return result, newStormError(uint32(C.GetLastError()), "failed to open the file")
+		return result, newPathError(uint32(errorCode), "verify", fileName)
 	}
 
 	return result, nil
 }
 
-// Verifies the digital signature of an archive.
+// Verifies the digital signature of an archive. A closed archive gives ERROR_VERIFY_FAILED; use VerifySignature to tell
+// it apart.
 func (a *Archive) SFileVerifyArchive() (result uint32) {
-	return uint32(C.SFileVerifyArchive(a.handle))
+	signature, err := a.VerifySignature()
+	if err != nil {
+		return ERROR_VERIFY_FAILED
+	}
+
+	return uint32(signature)
 }
 
 // Extracts a file from MPQ to the local drive.
 func (a *Archive) SFileExtractFile(toExtract string, extracted string, searchScope uint32) error {
+	err := a.lock("extract")
+	if err != nil {
+		return err
+	}
+	defer a.unlock()
+
 	cToExtract := C.CString(toExtract)
 	cExtracted := C.CString(extracted)
 	defer C.free(unsafe.Pointer(cToExtract))
 	defer C.free(unsafe.Pointer(cExtracted))
 
-	if C.SFileExtractFile(a.handle, cToExtract, cExtracted, C.DWORD(searchScope)) != 0 {
+	var errorCode C.DWORD
+	if C.shimSFileExtractFile(a.handle, cToExtract, cExtracted, C.DWORD(searchScope), &errorCode) != 0 {
 		return nil
 	}
 
-	return newStormError(uint32(C.GetLastError()), "failed to extract file")
+	return newPathError(uint32(errorCode), "extract", toExtract)
 }
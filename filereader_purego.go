@@ -0,0 +1,202 @@
+//go:build purego
+
+package storm
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/slyh/go-stormlib/internal/mpq"
+)
+
+// A file opened for reading from an archive.
+//
+// A FileReader may be used from multiple goroutines. ReadAt leaves the file pointer unchanged, while Read and Seek share it.
+type FileReader struct {
+	handle  *mpq.File
+	archive *Archive   // Archive the file belongs to, kept alive while the file is open
+	name    string     // Name of the file, used in errors
+	mutex   sync.Mutex // Serializes the use of the file pointer
+	pos     uint64     // File pointer, guarded by mutex
+}
+
+// Opens a file from MPQ archive. Files on the local drive are not supported.
+func (a *Archive) SFileOpenFileEx(fileName string, searchScope uint32) (*FileReader, error) {
+	err := a.lock("open")
+	if err != nil {
+		return nil, err
+	}
+	defer a.unlock()
+
+	if searchScope == SFILE_OPEN_LOCAL_FILE {
+		return nil, newPathError(ERROR_NOT_SUPPORTED, "open", fileName)
+	}
+
+	handle, err := a.handle.OpenFile(fileName)
+	if err != nil {
+		return nil, mpqError(err, "open", fileName)
+	}
+
+	f := FileReader{handle: handle, archive: a, name: fileName}
+	runtime.SetFinalizer(&f, (*FileReader).finalize)
+	return &f, nil
+}
+
+// Retrieves a size of the file within archive.
+func (f *FileReader) SFileGetFileSize() (fileSize uint64, err error) {
+	err = lockHandle(f.archive, &f.handle, "get size", f.name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.archive.unlock()
+
+	return f.handle.Size(), nil
+}
+
+// Sets current position in an open file.
+func (f *FileReader) SFileSetFilePointer(filePos uint64, moveMethod uint32) (pos uint64, err error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.setFilePointer(filePos, moveMethod)
+}
+
+func (f *FileReader) setFilePointer(filePos uint64, moveMethod uint32) (pos uint64, err error) {
+	err = lockHandle(f.archive, &f.handle, "seek", f.name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.archive.unlock()
+
+	size := f.handle.Size()
+
+	var newPos int64
+	switch moveMethod {
+	case FILE_BEGIN:
+		newPos = int64(filePos)
+	case FILE_CURRENT:
+		newPos = int64(f.pos) + int64(filePos)
+	case FILE_END:
+		newPos = int64(size) + int64(filePos)
+	default:
+		return 0, newPathError(ERROR_INVALID_PARAMETER, "seek", f.name)
+	}
+
+	if newPos < 0 {
+		return 0, newPathError(ERROR_NEGATIVE_SEEK, "seek", f.name)
+	}
+
+	f.pos = uint64(newPos)
+	if f.pos > size {
+		f.pos = size
+	}
+	return f.pos, nil
+}
+
+// Reads data from the file.
+func (f *FileReader) SFileReadFile(buffer []uint8) (n uint32, err error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.readFile(buffer)
+}
+
+func (f *FileReader) readFile(buffer []uint8) (n uint32, err error) {
+	err = lockHandle(f.archive, &f.handle, "read", f.name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.archive.unlock()
+
+	if len(buffer) == 0 {
+		return 0, nil
+	}
+
+	read, err := f.handle.ReadAt(buffer, int64(f.pos))
+	f.pos += uint64(read)
+
+	switch {
+	case errors.Is(err, io.EOF):
+		return uint32(read), newPathError(ERROR_HANDLE_EOF, "read", f.name)
+	case err != nil:
+		return uint32(read), mpqError(err, "read", f.name)
+	}
+
+	return uint32(read), nil
+}
+
+// Returns information about the open file.
+func (f *FileReader) Stat() (fs.FileInfo, error) {
+	err := lockHandle(f.archive, &f.handle, "stat", f.name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.archive.unlock()
+
+	fileName := f.handle.Name()
+
+	return fileInfo{&fsEntry{
+		name:         fileName[strings.LastIndex(fileName, "\\")+1:],
+		archivedName: fileName,
+		size:         int64(f.handle.Size()),
+		modTime:      fileTimeToTime(f.handle.FileTime()),
+	}}, nil
+}
+
+// Closes an open file.
+func (f *FileReader) SFileCloseFile() error {
+	err := lockHandle(f.archive, &f.handle, "close", f.name)
+	if err != nil {
+		return err
+	}
+	defer f.archive.unlock()
+
+	f.handle = nil
+	runtime.SetFinalizer(f, nil)
+
+	return nil
+}
+
+// Implementation of the io.Closer interface.
+func (f *FileReader) Close() error {
+	return f.SFileCloseFile()
+}
+
+// Closes the file if it was not closed before it became unreachable.
+func (f *FileReader) finalize() {
+	if f.handle != nil {
+		reportLeak("file reader", f.name)
+		f.SFileCloseFile()
+	}
+}
+
+// Quick check if the file exists within MPQ archive, without opening it.
+func (a *Archive) SFileHasFile(fileName string) (bool, error) {
+	err := a.lock("check")
+	if err != nil {
+		return false, err
+	}
+	defer a.unlock()
+
+	return a.handle.HasFile(fileName), nil
+}
+
+// Retrieves name of an open file.
+func (f *FileReader) SFileGetFileName() (fileName string, err error) {
+	err = lockHandle(f.archive, &f.handle, "get name", f.name)
+	if err != nil {
+		return "", err
+	}
+	defer f.archive.unlock()
+
+	fileName = f.handle.Name()
+	if len(fileName) > int(MAX_PATH) {
+		fileName = fileName[:MAX_PATH]
+	}
+
+	return fileName, nil
+}
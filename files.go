@@ -0,0 +1,118 @@
+package storm
+
+import (
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+type FileFindData struct {
+	FileName   string // Name of the found file
+	PlainName  string // Plain name of the found file
+	HashIndex  uint32 // Hash table index for the file
+	BlockIndex uint32 // Block table index for the file
+	FileSize   uint32 // Uncompressed size of the file, in bytes
+	FileFlags  uint32 // MPQ file flags
+	CompSize   uint32 // Compressed file size
+	FileTimeLo uint32 // Low 32-bits of the file time (0 if not present)
+	FileTimeHi uint32 // High 32-bits of the file time (0 if not present)
+	Locale     uint32 // Locale version
+}
+
+// Returns the file time of the found file, or the zero time if not present.
+func (d *FileFindData) FileTime() time.Time {
+	return fileTimeToTime(uint64(d.FileTimeHi)<<32 | uint64(d.FileTimeLo))
+}
+
+// Calls fn for each file matching the mask, using only the list files already added to the archive.
+func (a *Archive) forEachFile(mask string, fn func(findFileData *FileFindData)) error {
+	return a.walkFiles(mask, nil, func(findFileData *FileFindData) bool {
+		fn(findFileData)
+		return true
+	})
+}
+
+// Calls fn for each file matching the mask, reading the list files in addition to the list files already added to the
+// archive, which keeps their names as SFileAddListFile does. Returning false from fn stops the search.
+func (a *Archive) walkFiles(mask string, listFile []io.Reader, fn func(findFileData *FileFindData) bool) error {
+	listFilePath := ""
+	if len(listFile) > 0 {
+		var err error
+		listFilePath, err = spillListFile(listFile)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(listFilePath)
+	}
+
+	finder, findFileData, err := a.SFileFindFirstFile(mask, listFilePath)
+	if err != nil {
+		if errors.Is(err, ErrNoMoreFiles) {
+			return nil
+		}
+		return err
+	}
+	defer finder.SFileFindClose()
+
+	for {
+		if !fn(findFileData) {
+			return nil
+		}
+
+		findFileData, err = finder.SFileFindNextFile()
+		if err != nil {
+			if errors.Is(err, ErrNoMoreFiles) {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// Returns the files matching the mask.
+//
+// The optional list files are read in addition to the list files already added to the archive. Like a list file passed to
+// SFileFindFirstFile, their names are added to the archive as by SFileAddListFile: later searches find the files by
+// those names, and a writable archive stores them in its (listfile) when it is saved with changes.
+func (a *Archive) ListFiles(mask string, listFile ...io.Reader) ([]FileFindData, error) {
+	var files []FileFindData
+	err := a.walkFiles(mask, listFile, func(findFileData *FileFindData) bool {
+		files = append(files, *findFileData)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+// Writes the list files to a temporary file, since StormLib only reads list files from the local drive.
+func spillListFile(listFile []io.Reader) (string, error) {
+	f, err := os.CreateTemp("", "storm-listfile-*.txt")
+	if err != nil {
+		return "", err
+	}
+
+	for _, r := range listFile {
+		_, err = io.Copy(f, r)
+		if err == nil {
+			_, err = f.WriteString("\r\n")
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	return f.Name(), nil
+}
@@ -0,0 +1,23 @@
+//go:build go1.23
+
+package storm
+
+import (
+	"io"
+	"iter"
+)
+
+// Returns an iterator over the files matching the mask. The search handle is closed when the iteration stops.
+//
+// The optional list files are read in addition to the list files already added to the archive, and their names are kept
+// by the archive as described at ListFiles.
+func (a *Archive) Files(mask string, listFile ...io.Reader) iter.Seq2[FileFindData, error] {
+	return func(yield func(FileFindData, error) bool) {
+		err := a.walkFiles(mask, listFile, func(findFileData *FileFindData) bool {
+			return yield(*findFileData, nil)
+		})
+		if err != nil {
+			yield(FileFindData{}, err)
+		}
+	}
+}
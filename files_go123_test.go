@@ -0,0 +1,49 @@
+//go:build go1.23 && !purego
+
+package storm_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	storm "github.com/slyh/go-stormlib"
+)
+
+func TestFiles(t *testing.T) {
+	archive, err := storm.SFileCreateArchive(filepath.Join(t.TempDir(), "test.mpq"), storm.MPQ_CREATE_LISTFILE, 16)
+	if err != nil {
+		t.Errorf("SFileCreateArchive: %v", err)
+		return
+	}
+	defer archive.SFileCloseArchive()
+
+	for _, name := range []string{"test1.txt", "test2.txt", "other.txt"} {
+		err = archive.CreateFromReader(name, strings.NewReader(name), uint32(len(name)), storm.WriteOptions{})
+		if err != nil {
+			t.Errorf("Archive.CreateFromReader: %v", err)
+			return
+		}
+	}
+
+	var names []string
+	for findFileData, err := range archive.Files("test*.txt") {
+		if err != nil {
+			t.Errorf("Files: %v", err)
+			return
+		}
+		names = append(names, findFileData.FileName)
+	}
+	if len(names) != 2 {
+		t.Errorf("Files: expected 2 files, got %v", names)
+		return
+	}
+
+	for range archive.Files("*") {
+		break
+	}
+
+	for findFileData, err := range archive.Files("nothing*") {
+		t.Errorf("Files: expected no files, got %v, %v", findFileData, err)
+	}
+}
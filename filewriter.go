@@ -1,41 +1,350 @@
+//go:build !purego
+
 package storm
 
-// #include <StormLib.h>
+// #include "lasterror.h"
 import "C"
-import "unsafe"
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"unsafe"
+)
 
 type FileWriter struct {
-	handle C.HANDLE
+	handle      C.HANDLE
+	archive     *Archive // Archive the file belongs to, kept alive while the file is open
+	name        string   // Name of the file in the archive, used in errors
+	size        uint32   // File size declared on creation
+	written     uint32   // Number of bytes written so far
+	compression uint32   // Compression used by Write
+}
+
+// Options for creating a file in the archive.
+type WriteOptions struct {
+	FileTime    uint64 // File time as FILETIME (0 if not present)
+	Locale      Locale // File locale
+	Flags       uint32 // MPQ_FILE_* flags
+	Compression uint32 // Compression of the file data, used if the flags include MPQ_FILE_COMPRESS
 }
 
 // Creates a new file in MPQ and prepares it for writing data.
 func (a *Archive) SFileCreateFile(archivedName string, fileTime uint64, fileSize uint32, locale uint32, flags uint32) (*FileWriter, error) {
-	var f FileWriter
+	err := a.lock("create")
+	if err != nil {
+		return nil, err
+	}
+	defer a.unlock()
+
+	f := FileWriter{archive: a, name: archivedName}
 
 	cArchivedName := C.CString(archivedName)
 	defer C.free(unsafe.Pointer(cArchivedName))
 
-	if C.SFileCreateFile(a.handle, cArchivedName, C.ULONGLONG(fileTime), C.DWORD(fileSize), C.LCID(locale), C.DWORD(flags), &f.handle) != 0 {
+	f.size = fileSize
+
+	var errorCode C.DWORD
+	if C.shimSFileCreateFile(a.handle, cArchivedName, C.ULONGLONG(fileTime), C.DWORD(fileSize), C.LCID(locale), C.DWORD(flags), &f.handle, &errorCode) != 0 {
+		a.addChild(f.handle, releaseFileWriter)
+		runtime.SetFinalizer(&f, (*FileWriter).finalize)
 		return &f, nil
 	}
 
-	return nil, newStormError(uint32(C.GetLastError()), "failed to create file")
+	return nil, newPathError(uint32(errorCode), "create", archivedName)
 }
 
 // Writes data to the file within MPQ.
 func (f *FileWriter) SFileWriteFile(buffer []uint8, compression uint32) error {
-	if C.SFileWriteFile(f.handle, unsafe.Pointer(&buffer[0]), C.DWORD(len(buffer)), C.DWORD(compression)) != 0 {
+	err := f.archive.lockHandle(&f.handle, "write", f.name)
+	if err != nil {
+		return err
+	}
+	defer f.archive.unlock()
+
+	if len(buffer) == 0 {
 		return nil
 	}
 
-	return newStormError(uint32(C.GetLastError()), "failed to write file")
+	var errorCode C.DWORD
+	if C.shimSFileWriteFile(f.handle, unsafe.Pointer(&buffer[0]), C.DWORD(len(buffer)), C.DWORD(compression), &errorCode) != 0 {
+		f.written += uint32(len(buffer))
+		return nil
+	}
+
+	return newPathError(uint32(errorCode), "write", f.name)
 }
 
-// Finalizes writing file to the MPQ.
+// Finalizes writing file to the MPQ. The handle is released even if the finalization fails.
 func (f *FileWriter) SFileFinishFile() error {
-	if C.SFileFinishFile(f.handle) != 0 {
+	err := f.archive.lockHandle(&f.handle, "finish", f.name)
+	if err != nil {
+		return err
+	}
+	defer f.archive.unlock()
+
+	var errorCode C.DWORD
+	result := C.shimSFileFinishFile(f.handle, &errorCode)
+
+	f.archive.removeChild(f.handle)
+	f.handle = nil
+	runtime.SetFinalizer(f, nil)
+
+	if result != 0 {
+		return nil
+	}
+
+	return newPathError(uint32(errorCode), "finish", f.name)
+}
+
+// Finishes the file if it was not closed before it became unreachable.
+func (f *FileWriter) finalize() {
+	if f.handle != nil {
+		reportLeak("file writer", f.name)
+		f.SFileFinishFile()
+	}
+}
+
+// Finishes a file handle left open when its archive is closed. A file not written completely is left out of the
+// archive.
+func releaseFileWriter(handle C.HANDLE) {
+	var errorCode C.DWORD
+	C.shimSFileFinishFile(handle, &errorCode)
+}
+
+// Implementation of the io.Writer interface.
+//
+// Data beyond the size declared on creation is not written and ErrFileSizeExceeded is returned.
+func (f *FileWriter) Write(buffer []byte) (int, error) {
+	data := buffer
+	if remaining := f.size - f.written; uint64(len(data)) > uint64(remaining) {
+		data = data[:remaining]
+	}
+
+	err := f.SFileWriteFile(data, f.compression)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(data) < len(buffer) {
+		return len(data), fmt.Errorf("%w (size: %d)", ErrFileSizeExceeded, f.size)
+	}
+
+	return len(data), nil
+}
+
+// Implementation of the io.ReaderFrom interface.
+//
+// Reads until io.EOF, failing with ErrFileSizeExceeded if the reader has more data than the size declared on creation.
+func (f *FileWriter) ReadFrom(r io.Reader) (n int64, err error) {
+	buffer := make([]byte, 64*1024)
+
+	for {
+		chunk := buffer
+		if remaining := f.size - f.written; uint64(remaining) < uint64(len(chunk)) {
+			// Read one byte more than needed to detect oversized input
+			chunk = chunk[:remaining+1]
+		}
+
+		read, readErr := r.Read(chunk)
+		if read > 0 {
+			written, err := f.Write(chunk[:read])
+			n += int64(written)
+			if err != nil {
+				return n, err
+			}
+		}
+		if readErr == io.EOF {
+			return n, nil
+		}
+		if readErr != nil {
+			return n, readErr
+		}
+	}
+}
+
+// Implementation of the io.Closer interface.
+//
+// Finalizes the file, failing with ErrFileIncomplete if less data than the size declared on creation was written. The
+// error of SFileFinishFile, if any, is then part of its message.
+func (f *FileWriter) Close() error {
+	if f.handle == nil {
+		return closedError("close", f.name)
+	}
+
+	err := f.SFileFinishFile()
+
+	if f.written < f.size {
+		if err != nil {
+			return fmt.Errorf("%w (written: %d, size: %d: %v)", ErrFileIncomplete, f.written, f.size, err)
+		}
+		return fmt.Errorf("%w (written: %d, size: %d)", ErrFileIncomplete, f.written, f.size)
+	}
+
+	return err
+}
+
+// Creates a file in the archive from the data of a reader, which must provide exactly size bytes.
+func (a *Archive) CreateFromReader(archivedName string, r io.Reader, size uint32, options WriteOptions) error {
+	f, err := a.SFileCreateFile(archivedName, options.FileTime, size, uint32(options.Locale), options.Flags)
+	if err != nil {
+		return err
+	}
+	f.compression = options.Compression
+
+	_, err = f.ReadFrom(r)
+	if err != nil {
+		f.SFileFinishFile()
+		return err
+	}
+
+	return f.Close()
+}
+
+// Options for adding a file from the local drive.
+type AddOptions struct {
+	Flags           uint32  // MPQ_FILE_* flags
+	Compression     uint32  // Compression of the first sector, see MPQ_COMPRESSION_*
+	CompressionNext *uint32 // Compression of the following sectors, 0 for none (nil for MPQ_COMPRESSION_NEXT_SAME)
+	Wave            bool    // Compress the file as WAVE data according to WaveQuality, instead of Compression
+	WaveQuality     uint32  // Quality of the WAVE compression, see MPQ_WAVE_QUALITY_*
+	Locale          Locale  // File locale
+}
+
+// Adds a file from the local drive to the archive, choosing the compression for the first and the following sectors.
+func (a *Archive) SFileAddFileEx(fileName string, archivedName string, flags uint32, compression uint32, compressionNext uint32) error {
+	err := a.lock("add")
+	if err != nil {
+		return err
+	}
+	defer a.unlock()
+
+	cFileName := C.CString(fileName)
+	cArchivedName := C.CString(archivedName)
+	defer C.free(unsafe.Pointer(cFileName))
+	defer C.free(unsafe.Pointer(cArchivedName))
+
+	var errorCode C.DWORD
+	if C.shimSFileAddFileEx(a.handle, cFileName, cArchivedName, C.DWORD(flags), C.DWORD(compression), C.DWORD(compressionNext), &errorCode) != 0 {
+		return nil
+	}
+
+	return newPathError(uint32(errorCode), "add", fileName)
+}
+
+// Adds a file from the local drive to the archive.
+func (a *Archive) SFileAddFile(fileName string, archivedName string, flags uint32) error {
+	err := a.lock("add")
+	if err != nil {
+		return err
+	}
+	defer a.unlock()
+
+	cFileName := C.CString(fileName)
+	cArchivedName := C.CString(archivedName)
+	defer C.free(unsafe.Pointer(cFileName))
+	defer C.free(unsafe.Pointer(cArchivedName))
+
+	var errorCode C.DWORD
+	if C.shimSFileAddFile(a.handle, cFileName, cArchivedName, C.DWORD(flags), &errorCode) != 0 {
+		return nil
+	}
+
+	return newPathError(uint32(errorCode), "add", fileName)
+}
+
+// Adds a WAVE file from the local drive to the archive.
+func (a *Archive) SFileAddWave(fileName string, archivedName string, flags uint32, quality uint32) error {
+	err := a.lock("add")
+	if err != nil {
+		return err
+	}
+	defer a.unlock()
+
+	cFileName := C.CString(fileName)
+	cArchivedName := C.CString(archivedName)
+	defer C.free(unsafe.Pointer(cFileName))
+	defer C.free(unsafe.Pointer(cArchivedName))
+
+	var errorCode C.DWORD
+	if C.shimSFileAddWave(a.handle, cFileName, cArchivedName, C.DWORD(flags), C.DWORD(quality), &errorCode) != 0 {
+		return nil
+	}
+
+	return newPathError(uint32(errorCode), "add", fileName)
+}
+
+// Adds a file from the local drive to the archive.
+//
+// Unlike the raw bindings, the locale is taken from the options instead of the global locale.
+func (a *Archive) AddFile(fileName string, archivedName string, options AddOptions) error {
+	err := a.lock("add")
+	if err != nil {
+		return err
+	}
+	defer a.unlock()
+
+	cFileName := C.CString(fileName)
+	cArchivedName := C.CString(archivedName)
+	defer C.free(unsafe.Pointer(cFileName))
+	defer C.free(unsafe.Pointer(cArchivedName))
+
+	var errorCode C.DWORD
+	var result bool
+
+	if options.Wave {
+		result = C.shimSFileAddWaveLocale(a.handle, cFileName, cArchivedName, C.DWORD(options.Flags), C.DWORD(options.WaveQuality), C.LCID(options.Locale), &errorCode) != 0
+	} else {
+		compressionNext := MPQ_COMPRESSION_NEXT_SAME
+		if options.CompressionNext != nil {
+			compressionNext = *options.CompressionNext
+		}
+		result = C.shimSFileAddFileExLocale(a.handle, cFileName, cArchivedName, C.DWORD(options.Flags), C.DWORD(options.Compression), C.DWORD(compressionNext), C.LCID(options.Locale), &errorCode) != 0
+	}
+
+	if result {
+		return nil
+	}
+
+	return newPathError(uint32(errorCode), "add", fileName)
+}
+
+// Removes a file from the archive.
+func (a *Archive) Remove(fileName string, searchScope uint32) error {
+	err := a.lock("remove")
+	if err != nil {
+		return err
+	}
+	defer a.unlock()
+
+	cFileName := C.CString(fileName)
+	defer C.free(unsafe.Pointer(cFileName))
+
+	var errorCode C.DWORD
+	if C.shimSFileRemoveFile(a.handle, cFileName, C.DWORD(searchScope), &errorCode) != 0 {
+		return nil
+	}
+
+	return newPathError(uint32(errorCode), "remove", fileName)
+}
+
+// Renames a file within the archive.
+func (a *Archive) Rename(oldFileName string, newFileName string) error {
+	err := a.lock("rename")
+	if err != nil {
+		return err
+	}
+	defer a.unlock()
+
+	cOldFileName := C.CString(oldFileName)
+	cNewFileName := C.CString(newFileName)
+	defer C.free(unsafe.Pointer(cOldFileName))
+	defer C.free(unsafe.Pointer(cNewFileName))
+
+	var errorCode C.DWORD
+	if C.shimSFileRenameFile(a.handle, cOldFileName, cNewFileName, &errorCode) != 0 {
 		return nil
 	}
 
-	return newStormError(uint32(C.GetLastError()), "failed to finish file")
+	return newPathError(uint32(errorCode), "rename", oldFileName)
 }
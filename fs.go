@@ -0,0 +1,297 @@
+package storm
+
+import (
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Read-only file system view of an archive.
+//
+// The directory tree is synthesized from the backslash-separated file names found in the archive when the view is created.
+// Names which are not valid fs paths are left out, and so is a file whose name is also used as a directory.
+type ArchiveFS struct {
+	archive *Archive
+	entries map[string]*fsEntry // Entries keyed by slash-separated path, "." is the root
+}
+
+// A file or a synthesized directory in the tree.
+type fsEntry struct {
+	name         string     // Base name of the entry
+	archivedName string     // Name of the file within the archive, empty for directories
+	dir          bool       // Whether the entry is a directory
+	size         int64      // Uncompressed size of the file
+	modTime      time.Time  // File time (zero if not present)
+	children     []*fsEntry // Directory entries sorted by name
+}
+
+// Returns a read-only file system view of the archive.
+func (a *Archive) FS() (*ArchiveFS, error) {
+	fsys := ArchiveFS{
+		archive: a,
+		entries: map[string]*fsEntry{".": {name: ".", dir: true}},
+	}
+
+	err := a.forEachFile("*", fsys.add)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range fsys.entries {
+		sort.Slice(entry.children, func(i, j int) bool {
+			return entry.children[i].name < entry.children[j].name
+		})
+	}
+
+	return &fsys, nil
+}
+
+// Adds a found file and its parent directories to the tree.
+func (fsys *ArchiveFS) add(findFileData *FileFindData) {
+	name := strings.ReplaceAll(findFileData.FileName, "\\", "/")
+	if name == "." || !fs.ValidPath(name) {
+		return
+	}
+	if _, ok := fsys.entries[name]; ok {
+		return
+	}
+
+	entry := &fsEntry{
+		name:         path.Base(name),
+		archivedName: findFileData.FileName,
+		size:         int64(findFileData.FileSize),
+		modTime:      findFileData.FileTime(),
+	}
+
+	for {
+		parentName := path.Dir(name)
+		parent, ok := fsys.entries[parentName]
+		if ok && !parent.dir {
+			// A file is in the way, the directory replaces it
+			fsys.remove(parentName)
+			ok = false
+		}
+
+		fsys.entries[name] = entry
+		if ok {
+			parent.children = append(parent.children, entry)
+			return
+		}
+
+		parent = &fsEntry{name: path.Base(parentName), dir: true, children: []*fsEntry{entry}}
+		name, entry = parentName, parent
+	}
+}
+
+// Removes a file from the tree.
+func (fsys *ArchiveFS) remove(name string) {
+	delete(fsys.entries, name)
+
+	parent := fsys.entries[path.Dir(name)]
+	for i, child := range parent.children {
+		if child.name == path.Base(name) {
+			parent.children = append(parent.children[:i], parent.children[i+1:]...)
+			return
+		}
+	}
+}
+
+func (fsys *ArchiveFS) lookup(op string, name string) (*fsEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	entry, ok := fsys.entries[name]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+
+	return entry, nil
+}
+
+// Implementation of the fs.FS interface.
+func (fsys *ArchiveFS) Open(name string) (fs.File, error) {
+	entry, err := fsys.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if entry.dir {
+		return &fsDir{entry: entry}, nil
+	}
+
+	reader, err := fsys.archive.SFileOpenFileEx(entry.archivedName, SFILE_OPEN_FROM_MPQ)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	return &fsFile{FileReader: reader, entry: entry}, nil
+}
+
+// Implementation of the fs.ReadDirFS interface.
+func (fsys *ArchiveFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entry, err := fsys.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !entry.dir {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	list := make([]fs.DirEntry, len(entry.children))
+	for i, child := range entry.children {
+		list[i] = fileInfo{child}
+	}
+
+	return list, nil
+}
+
+// Implementation of the fs.StatFS interface.
+func (fsys *ArchiveFS) Stat(name string) (fs.FileInfo, error) {
+	entry, err := fsys.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return fileInfo{entry}, nil
+}
+
+// Implementation of the fs.ReadFileFS interface.
+func (fsys *ArchiveFS) ReadFile(name string) ([]byte, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if _, ok := file.(*fsDir); ok {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrInvalid}
+	}
+
+	buffer, err := io.ReadAll(file)
+	if err != nil {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: err}
+	}
+
+	return buffer, nil
+}
+
+// A file opened through ArchiveFS.
+type fsFile struct {
+	*FileReader
+	entry *fsEntry
+}
+
+// Implementation of the fs.File interface.
+func (f *fsFile) Stat() (fs.FileInfo, error) {
+	return fileInfo{f.entry}, nil
+}
+
+// Implementation of the fs.File interface.
+func (f *fsFile) Close() error {
+	return f.SFileCloseFile()
+}
+
+// A directory opened through ArchiveFS.
+type fsDir struct {
+	entry  *fsEntry
+	offset int
+}
+
+// Implementation of the fs.File interface.
+func (d *fsDir) Stat() (fs.FileInfo, error) {
+	return fileInfo{d.entry}, nil
+}
+
+// Implementation of the fs.File interface.
+func (d *fsDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.entry.name, Err: fs.ErrInvalid}
+}
+
+// Implementation of the fs.File interface.
+func (d *fsDir) Close() error {
+	return nil
+}
+
+// Implementation of the fs.ReadDirFile interface.
+func (d *fsDir) ReadDir(count int) ([]fs.DirEntry, error) {
+	children := d.entry.children[d.offset:]
+	if count > 0 && len(children) == 0 {
+		return nil, io.EOF
+	}
+	if count > 0 && count < len(children) {
+		children = children[:count]
+	}
+
+	list := make([]fs.DirEntry, len(children))
+	for i, child := range children {
+		list[i] = fileInfo{child}
+	}
+	d.offset += len(children)
+
+	return list, nil
+}
+
+// Implementation of the fs.FileInfo and fs.DirEntry interfaces.
+type fileInfo struct {
+	entry *fsEntry
+}
+
+func (fi fileInfo) Name() string {
+	return fi.entry.name
+}
+
+func (fi fileInfo) Size() int64 {
+	return fi.entry.size
+}
+
+func (fi fileInfo) Mode() fs.FileMode {
+	if fi.entry.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+func (fi fileInfo) ModTime() time.Time {
+	return fi.entry.modTime
+}
+
+func (fi fileInfo) IsDir() bool {
+	return fi.entry.dir
+}
+
+func (fi fileInfo) Sys() any {
+	return nil
+}
+
+func (fi fileInfo) Type() fs.FileMode {
+	return fi.Mode().Type()
+}
+
+func (fi fileInfo) Info() (fs.FileInfo, error) {
+	return fi, nil
+}
+
+// January 1, 1970 as FILETIME, in 100-nanosecond intervals since January 1, 1601 (UTC).
+const fileTimeUnixEpoch = 116444736000000000
+
+// Converts a FILETIME to a time.Time. Zero means not present.
+func fileTimeToTime(fileTime uint64) time.Time {
+	if fileTime == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, (int64(fileTime)-fileTimeUnixEpoch)*100)
+}
+
+// Converts a time.Time to a FILETIME. The zero time means not present.
+func timeToFileTime(t time.Time) uint64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return uint64(t.UnixNano()/100 + fileTimeUnixEpoch)
+}
@@ -1,3 +1,8 @@
 module github.com/slyh/go-stormlib
 
-go 1.18
\ No newline at end of file
+go 1.18
+
+require (
+	github.com/dsnet/compress v0.0.1
+	github.com/ulikunitz/xz v0.5.15
+)
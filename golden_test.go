@@ -0,0 +1,146 @@
+package storm_test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	storm "github.com/slyh/go-stormlib"
+)
+
+// The archives in testdata are written by the tests of internal/mpq with -update, their expected output by the tests of
+// this package with -update in cgo builds.
+var update = flag.Bool("update", false, "write the expected output of the archives in testdata")
+
+// Expected output of an archive.
+type golden struct {
+	Files []goldenFile // Files found by ListFiles("*"), in its order
+	Paths []string     // Paths of ArchiveFS, in the order of fs.WalkDir
+}
+
+type goldenFile struct {
+	storm.FileFindData
+	MD5        string `json:",omitempty"` // MD5 of the content read with SFileOpenFileEx
+	Unreadable bool   `json:",omitempty"` // The file could not be opened or read
+}
+
+// Compares the output of every archive in testdata with its JSON file, or writes the JSON files if write is set.
+func testGoldens(t *testing.T, write bool) {
+	archives, err := filepath.Glob(filepath.Join("testdata", "*.mpq"))
+	if err != nil || len(archives) == 0 {
+		t.Errorf("no archives in testdata: %v", err)
+		return
+	}
+
+	for _, archivePath := range archives {
+		t.Run(filepath.Base(archivePath), func(t *testing.T) {
+			got, err := readGolden(archivePath)
+			if err != nil {
+				t.Errorf("%s: %v", archivePath, err)
+				return
+			}
+
+			data, err := json.MarshalIndent(got, "", "\t")
+			if err != nil {
+				t.Errorf("json.MarshalIndent: %v", err)
+				return
+			}
+			data = append(data, '\n')
+
+			goldenPath := strings.TrimSuffix(archivePath, ".mpq") + ".json"
+			if write {
+				err = os.WriteFile(goldenPath, data, 0o644)
+				if err != nil {
+					t.Errorf("WriteFile: %v", err)
+				}
+				return
+			}
+
+			expected, err := os.ReadFile(goldenPath)
+			if err != nil {
+				t.Errorf("ReadFile: %v", err)
+				return
+			}
+			if !bytes.Equal(data, expected) {
+				t.Errorf("%s differs from %s. Got:\n%s", archivePath, goldenPath, data)
+				return
+			}
+		})
+	}
+}
+
+// Lists, reads and walks the files of an archive.
+func readGolden(archivePath string) (*golden, error) {
+	archive, err := storm.SFileOpenArchive(archivePath, storm.STREAM_FLAG_READ_ONLY)
+	if err != nil {
+		return nil, fmt.Errorf("SFileOpenArchive: %w", err)
+	}
+	defer archive.SFileCloseArchive()
+
+	list, err := archive.ListFiles("*")
+	if err != nil {
+		return nil, fmt.Errorf("Archive.ListFiles: %w", err)
+	}
+
+	var g golden
+	readable := true
+	for _, findFileData := range list {
+		file := goldenFile{FileFindData: findFileData}
+
+		// The error messages differ between the backends, so only the failure is recorded
+		content, err := readArchiveFile(archive, findFileData.FileName)
+		if err != nil {
+			file.Unreadable = true
+			readable = false
+		} else {
+			file.MD5 = fmt.Sprintf("%x", md5.Sum(content))
+		}
+
+		g.Files = append(g.Files, file)
+	}
+
+	fsys, err := archive.FS()
+	if err != nil {
+		return nil, fmt.Errorf("Archive.FS: %w", err)
+	}
+
+	err = fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			g.Paths = append(g.Paths, path)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("fs.WalkDir: %w", err)
+	}
+
+	// TestFS reads every file, which fails for encrypted files of unknown name
+	if readable {
+		err = fstest.TestFS(fsys, g.Paths...)
+		if err != nil {
+			return nil, fmt.Errorf("fstest.TestFS: %w", err)
+		}
+	}
+
+	return &g, nil
+}
+
+// Reads a whole file of the archive.
+func readArchiveFile(archive *storm.Archive, fileName string) ([]byte, error) {
+	reader, err := archive.SFileOpenFileEx(fileName, storm.SFILE_OPEN_FROM_MPQ)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.SFileCloseFile()
+
+	return io.ReadAll(reader)
+}
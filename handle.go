@@ -0,0 +1,35 @@
+package storm
+
+import (
+	"io/fs"
+	"sync/atomic"
+)
+
+// Function called when a handle is released by a finalizer, see SetLeakHook. Holds a func(resource, name string), which
+// is nil if no hook is set.
+var leakHook atomic.Value
+
+// Sets a function to be called when a finalizer releases an archive, file or search handle that was not closed. A nil function removes the hook.
+//
+// It is meant for tests that check for leaked handles. The function is called on the finalizer goroutine.
+func SetLeakHook(fn func(resource string, name string)) {
+	leakHook.Store(fn)
+}
+
+// Reports a handle released by a finalizer to the leak hook.
+func reportLeak(resource string, name string) {
+	fn, _ := leakHook.Load().(func(resource string, name string))
+	if fn != nil {
+		fn(resource, name)
+	}
+}
+
+// Returns the error for an operation on a closed archive, file or search handle.
+func closedError(op string, name string) error {
+	return &fs.PathError{Op: op, Path: name, Err: fs.ErrClosed}
+}
+
+// Unlocks the archive after a call into StormLib.
+func (a *Archive) unlock() {
+	a.mutex.Unlock()
+}
@@ -0,0 +1,239 @@
+//go:build !purego
+
+package storm
+
+// #include "lasterror.h"
+import "C"
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+	"unsafe"
+)
+
+// Information about an open archive, as reported by SFileGetFileInfo.
+type ArchiveInfo struct {
+	FileName              string // Name of the archive file
+	UserDataOffset        uint64 // Offset of the user data header (0 if not present)
+	HeaderOffset          uint64 // Offset of the MPQ header
+	HeaderSize            uint32 // Fixed size of the MPQ header
+	HetTableOffset        uint64 // Offset of the HET table, relative to MPQ header (0 if not present)
+	HetTableSize          uint64 // Compressed size of the HET table (0 if not present)
+	BetTableOffset        uint64 // Offset of the BET table, relative to MPQ header (0 if not present)
+	BetTableSize          uint64 // Compressed size of the BET table (0 if not present)
+	HashTableOffset       uint64 // Hash table offset, relative to MPQ header
+	HashTableSize         uint32 // Size of the hash table, in entries
+	BlockTableOffset      uint64 // Block table offset, relative to MPQ header
+	BlockTableSize        uint32 // Size of the block table, in entries
+	HiBlockTableOffset    uint64 // Hi-block table offset, relative to MPQ header (0 if not present)
+	ArchiveSize           uint64 // Archive size from the header
+	MaxFileCount          uint32 // Max number of files in the archive
+	FileTableSize         uint32 // Number of entries in the file table
+	SectorSize            uint32 // Sector size
+	NumberOfFiles         uint32 // Number of files
+	RawChunkSize          uint32 // Size of the raw data chunk for MD5 (0 if not present)
+	StreamFlags           uint32 // Stream flags
+	Flags                 uint32 // Nonzero if the MPQ is read only
+	Signatures            uint32 // Signatures present in the MPQ, see SIGNATURE_TYPE_*
+	StrongSignatureOffset uint64 // Byte offset of the strong signature (0 if not present)
+	StrongSignatureSize   uint32 // Size of the strong signature (0 if not present)
+}
+
+// Information about an open file, as reported by SFileGetFileInfo.
+type FileInfo struct {
+	PatchChain     []string // Chain of patches where the file is
+	HashIndex      uint32   // Index of the hash table entry
+	NameHash1      uint32   // The first name hash in the hash table (0 if not present)
+	NameHash2      uint32   // The second name hash in the hash table (0 if not present)
+	NameHash3      uint64   // 64-bit file name hash for the HET/BET tables (0 if not present)
+	Locale         uint32   // File locale
+	FileIndex      uint32   // Block index
+	ByteOffset     uint64   // File position in the archive
+	FileTime       uint64   // File time (0 if not present)
+	FileSize       uint32   // Size of the file
+	CompressedSize uint32   // Compressed file size
+	Flags          uint32   // MPQ file flags
+	FileKey        uint32   // File encryption key
+	FileKeyRaw     uint32   // Unfixed value of the file key
+	CRC32          uint32   // CRC32 of the file (0 if not present)
+}
+
+// Retrieves raw information about an open archive.
+func (a *Archive) SFileGetFileInfo(infoClass uint32) ([]byte, error) {
+	err := a.lock("get info")
+	if err != nil {
+		return nil, err
+	}
+	defer a.unlock()
+
+	return getFileInfo(a.handle, infoClass, a.name)
+}
+
+// Retrieves raw information about an open file.
+func (f *FileReader) SFileGetFileInfo(infoClass uint32) ([]byte, error) {
+	err := f.archive.lockHandle(&f.handle, "get info", f.name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.archive.unlock()
+
+	return getFileInfo(f.handle, infoClass, f.name)
+}
+
+// Retrieves information about an open archive.
+func (a *Archive) Info() (*ArchiveInfo, error) {
+	err := a.lock("get info")
+	if err != nil {
+		return nil, err
+	}
+	defer a.unlock()
+
+	var info ArchiveInfo
+	q := infoQuery{handle: a.handle, name: a.name}
+
+	info.FileName = q.string(SFileMpqFileName)
+	info.UserDataOffset = q.uint64(SFileMpqUserDataOffset, true)
+	info.HeaderOffset = q.uint64(SFileMpqHeaderOffset, false)
+	info.HeaderSize = q.uint32(SFileMpqHeaderSize, false)
+	info.HetTableOffset = q.uint64(SFileMpqHetTableOffset, true)
+	info.HetTableSize = q.uint64(SFileMpqHetTableSize, true)
+	info.BetTableOffset = q.uint64(SFileMpqBetTableOffset, true)
+	info.BetTableSize = q.uint64(SFileMpqBetTableSize, true)
+	info.HashTableOffset = q.uint64(SFileMpqHashTableOffset, true)
+	info.HashTableSize = q.uint32(SFileMpqHashTableSize, true)
+	info.BlockTableOffset = q.uint64(SFileMpqBlockTableOffset, true)
+	info.BlockTableSize = q.uint32(SFileMpqBlockTableSize, true)
+	info.HiBlockTableOffset = q.uint64(SFileMpqHiBlockTableOffset, true)
+	info.ArchiveSize = q.uint64(SFileMpqArchiveSize64, false)
+	info.MaxFileCount = q.uint32(SFileMpqMaxFileCount, false)
+	info.FileTableSize = q.uint32(SFileMpqFileTableSize, false)
+	info.SectorSize = q.uint32(SFileMpqSectorSize, false)
+	info.NumberOfFiles = q.uint32(SFileMpqNumberOfFiles, false)
+	info.RawChunkSize = q.uint32(SFileMpqRawChunkSize, true)
+	info.StreamFlags = q.uint32(SFileMpqStreamFlags, false)
+	info.Flags = q.uint32(SFileMpqFlags, false)
+	info.Signatures = q.uint32(SFileMpqSignatures, false)
+	info.StrongSignatureOffset = q.uint64(SFileMpqStrongSignatureOffset, true)
+	info.StrongSignatureSize = q.uint32(SFileMpqStrongSignatureSize, true)
+
+	if q.err != nil {
+		return nil, q.err
+	}
+
+	return &info, nil
+}
+
+// Retrieves information about an open file.
+func (f *FileReader) Info() (*FileInfo, error) {
+	err := f.archive.lockHandle(&f.handle, "get info", f.name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.archive.unlock()
+
+	var info FileInfo
+	q := infoQuery{handle: f.handle, name: f.name}
+
+	info.PatchChain = q.strings(SFileInfoPatchChain, true)
+	info.HashIndex = q.uint32(SFileInfoHashIndex, true)
+	info.NameHash1 = q.uint32(SFileInfoNameHash1, true)
+	info.NameHash2 = q.uint32(SFileInfoNameHash2, true)
+	info.NameHash3 = q.uint64(SFileInfoNameHash3, true)
+	info.Locale = q.uint32(SFileInfoLocale, false)
+	info.FileIndex = q.uint32(SFileInfoFileIndex, false)
+	info.ByteOffset = q.uint64(SFileInfoByteOffset, false)
+	info.FileTime = q.uint64(SFileInfoFileTime, true)
+	info.FileSize = q.uint32(SFileInfoFileSize, false)
+	info.CompressedSize = q.uint32(SFileInfoCompressedSize, false)
+	info.Flags = q.uint32(SFileInfoFlags, false)
+	info.FileKey = q.uint32(SFileInfoEncryptionKey, false)
+	info.FileKeyRaw = q.uint32(SFileInfoEncryptionKeyRaw, false)
+	info.CRC32 = q.uint32(SFileInfoCRC32, true)
+
+	if q.err != nil {
+		return nil, q.err
+	}
+
+	return &info, nil
+}
+
+// Calls SFileGetFileInfo, growing the buffer until the whole value fits. The name of the archive or file is used in errors.
+func getFileInfo(handle C.HANDLE, infoClass uint32, name string) ([]byte, error) {
+	var lengthNeeded, errorCode C.DWORD
+
+	buffer := make([]byte, 8)
+	for {
+		if C.shimSFileGetFileInfo(handle, C.SFileInfoClass(infoClass), unsafe.Pointer(&buffer[0]), C.DWORD(len(buffer)), &lengthNeeded, &errorCode) != 0 {
+			return buffer[:lengthNeeded], nil
+		}
+
+		if uint32(errorCode) != ERROR_INSUFFICIENT_BUFFER || int(lengthNeeded) <= len(buffer) {
+			return nil, newPathError(uint32(errorCode), "get info", name)
+		}
+
+		buffer = make([]byte, lengthNeeded)
+	}
+}
+
+// Runs a series of SFileGetFileInfo queries, keeping the first error.
+type infoQuery struct {
+	handle C.HANDLE
+	name   string // Name of the archive or file, used in errors
+	err    error
+}
+
+// Queries a value. If the class is optional, ERROR_FILE_NOT_FOUND and ERROR_INVALID_PARAMETER, which StormLib returns
+// for classes that do not apply to the handle, are treated as an empty value.
+func (q *infoQuery) get(infoClass uint32, optional bool) []byte {
+	if q.err != nil {
+		return nil
+	}
+
+	buffer, err := getFileInfo(q.handle, infoClass, q.name)
+	if err != nil {
+		if optional && (errors.Is(err, ErrNotFound) || errors.Is(err, ErrInvalidParameter)) {
+			return nil
+		}
+		q.err = err
+		return nil
+	}
+
+	return buffer
+}
+
+func (q *infoQuery) uint32(infoClass uint32, optional bool) uint32 {
+	buffer := q.get(infoClass, optional)
+	if len(buffer) < 4 {
+		return 0
+	}
+
+	return binary.LittleEndian.Uint32(buffer)
+}
+
+func (q *infoQuery) uint64(infoClass uint32, optional bool) uint64 {
+	buffer := q.get(infoClass, optional)
+	if len(buffer) < 8 {
+		return 0
+	}
+
+	return binary.LittleEndian.Uint64(buffer)
+}
+
+func (q *infoQuery) string(infoClass uint32) string {
+	return strings.TrimRight(string(q.get(infoClass, false)), "\x00")
+}
+
+// Queries a list of NUL-separated strings terminated by an empty string.
+func (q *infoQuery) strings(infoClass uint32, optional bool) []string {
+	var list []string
+
+	for _, s := range strings.Split(string(q.get(infoClass, optional)), "\x00") {
+		if s == "" {
+			break
+		}
+		list = append(list, s)
+	}
+
+	return list
+}
@@ -0,0 +1,456 @@
+package mpq
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"math/bits"
+	"strings"
+
+	"github.com/slyh/go-stormlib/comp"
+	"github.com/slyh/go-stormlib/comp/pkware"
+)
+
+const testSectorShift = 3 // Sectors of 4 KiB
+
+// A file of an archive built by testArchive.
+type testFile struct {
+	name     string
+	data     []byte
+	flags    uint32    // File* flags, FileExists is added
+	mask     comp.Mask // Compression of the sectors if FileCompress is set
+	locale   uint16
+	fileTime uint64
+}
+
+// An archive to build for the tests, with the parts of the format that Open reads.
+type testArchive struct {
+	version     int // Format version from 1 to 4
+	files       []testFile
+	listFile    bool   // Add a (listfile) with the names of the files
+	attributes  bool   // Add an (attributes) with the file times
+	het         bool   // Add HET and BET tables, for versions 3 and 4
+	noHashTable bool   // Leave out the hash and block tables, for versions 3 and 4 with HET and BET tables
+	gap         uint64 // Size of a hole before the file data, to place the data and tables above 4 GiB
+}
+
+// An archive image, which reads as zeros between its parts.
+type testImage struct {
+	parts []testPart
+	size  int64
+}
+
+type testPart struct {
+	pos  int64
+	data []byte
+}
+
+// Builds the archive. Files come first, then the HET, BET, hash, block and hi-block tables.
+func (b *testArchive) build() (*testImage, error) {
+	files := append([]testFile(nil), b.files...)
+	if b.listFile {
+		var names []string
+		for _, f := range files {
+			names = append(names, f.name)
+		}
+		files = append(files, testFile{
+			name:  "(listfile)",
+			data:  []byte(strings.Join(names, "\r\n") + "\r\n"),
+			flags: FileCompress | FileEncrypted | FileFixKey,
+			mask:  comp.Zlib,
+		})
+	}
+	if b.attributes {
+		files = append(files, testFile{
+			name:  "(attributes)",
+			data:  testAttributes(files),
+			flags: FileCompress | FileEncrypted | FileFixKey,
+			mask:  comp.Zlib,
+		})
+	}
+
+	headerSize := [...]uint64{0x20, 0x2C, 0x44, maxHeaderSize}[b.version-1]
+	img := testImage{}
+	pos := headerSize + b.gap
+
+	entries := make([]fileEntry, len(files))
+	for i := range files {
+		f := &files[i]
+		data, err := testEncodeFile(f, pos)
+		if err != nil {
+			return nil, err
+		}
+		img.put(pos, data)
+
+		entries[i] = fileEntry{pos: pos, cmpSize: uint32(len(data)), fileSize: uint32(len(f.data)), flags: f.flags | FileExists}
+		pos += uint64(len(data))
+	}
+
+	var hetPos, hetSize, betPos, betSize uint64
+	if b.het {
+		het := testHETTable(files)
+		hetPos, hetSize = pos, uint64(len(het))
+		img.put(pos, het)
+		pos += hetSize
+
+		bet := testBETTable(files, entries)
+		betPos, betSize = pos, uint64(len(bet))
+		img.put(pos, bet)
+		pos += betSize
+	}
+
+	var hashPos, blockPos, hiBlockPos, hashCount, blockCount, hiBlockSize uint64
+	if !b.noHashTable {
+		hashes := testHashTable(files)
+		hashPos, hashCount = pos, uint64(len(hashes)/16)
+		img.put(pos, hashes)
+		pos += uint64(len(hashes))
+
+		blocks := make([]byte, 16*len(entries))
+		hiBlocks := make([]byte, 2*len(entries))
+		for i, entry := range entries {
+			binary.LittleEndian.PutUint32(blocks[16*i:], uint32(entry.pos))
+			binary.LittleEndian.PutUint32(blocks[16*i+4:], entry.cmpSize)
+			binary.LittleEndian.PutUint32(blocks[16*i+8:], entry.fileSize)
+			binary.LittleEndian.PutUint32(blocks[16*i+12:], entry.flags)
+			binary.LittleEndian.PutUint16(hiBlocks[2*i:], uint16(entry.pos>>32))
+		}
+		encrypt(blocks, keyBlockTable)
+		blockPos, blockCount = pos, uint64(len(entries))
+		img.put(pos, blocks)
+		pos += uint64(len(blocks))
+
+		if b.version >= 2 && pos > 0xFFFFFFFF {
+			hiBlockPos, hiBlockSize = pos, uint64(len(hiBlocks))
+			img.put(pos, hiBlocks)
+			pos += hiBlockSize
+		}
+	}
+
+	h := make([]byte, headerSize)
+	binary.LittleEndian.PutUint32(h, idMPQ)
+	binary.LittleEndian.PutUint32(h[4:], uint32(headerSize))
+	binary.LittleEndian.PutUint32(h[8:], uint32(pos))
+	binary.LittleEndian.PutUint16(h[12:], uint16(b.version-1))
+	binary.LittleEndian.PutUint16(h[14:], testSectorShift)
+	binary.LittleEndian.PutUint32(h[16:], uint32(hashPos))
+	binary.LittleEndian.PutUint32(h[20:], uint32(blockPos))
+	binary.LittleEndian.PutUint32(h[24:], uint32(hashCount))
+	binary.LittleEndian.PutUint32(h[28:], uint32(blockCount))
+	if b.version >= 2 {
+		binary.LittleEndian.PutUint64(h[32:], hiBlockPos)
+		binary.LittleEndian.PutUint16(h[40:], uint16(hashPos>>32))
+		binary.LittleEndian.PutUint16(h[42:], uint16(blockPos>>32))
+	}
+	if b.version >= 3 {
+		binary.LittleEndian.PutUint64(h[44:], pos)
+		binary.LittleEndian.PutUint64(h[52:], betPos)
+		binary.LittleEndian.PutUint64(h[60:], hetPos)
+	}
+	if b.version >= 4 {
+		binary.LittleEndian.PutUint64(h[68:], 16*hashCount)
+		binary.LittleEndian.PutUint64(h[76:], 16*blockCount)
+		binary.LittleEndian.PutUint64(h[84:], hiBlockSize)
+		binary.LittleEndian.PutUint64(h[92:], hetSize)
+		binary.LittleEndian.PutUint64(h[100:], betSize)
+	}
+	img.put(0, h)
+
+	img.size = int64(pos)
+	return &img, nil
+}
+
+// Returns the data of an (attributes) file with CRC32 and file times, whose own entry is left empty.
+func testAttributes(files []testFile) []byte {
+	count := len(files) + 1
+	data := make([]byte, 8+12*count)
+	binary.LittleEndian.PutUint32(data, 100)
+	binary.LittleEndian.PutUint32(data[4:], 0x03)
+	for i, f := range files {
+		binary.LittleEndian.PutUint32(data[8+4*i:], crc32.ChecksumIEEE(f.data))
+		binary.LittleEndian.PutUint64(data[8+4*count+8*i:], f.fileTime)
+	}
+
+	return data
+}
+
+// Returns the stored data of a file at a position relative to the header.
+func testEncodeFile(f *testFile, pos uint64) ([]byte, error) {
+	size := uint32(len(f.data))
+	encrypted := f.flags&FileEncrypted != 0
+	compressed := f.flags&(FileImplode|FileCompress) != 0
+
+	var key uint32
+	if encrypted {
+		key = fileKey(f.name, pos, size, f.flags)
+	}
+
+	if f.flags&FileSingleUnit != 0 {
+		data := append([]byte(nil), f.data...)
+		if compressed {
+			packed, err := testCompress(f, data)
+			if err != nil {
+				return nil, err
+			}
+			if len(packed) < len(data) {
+				data = packed
+			}
+		}
+		if encrypted {
+			encrypt(data, key)
+		}
+		return data, nil
+	}
+
+	sectorSize := uint32(0x200) << testSectorShift
+	count := (size + sectorSize - 1) / sectorSize
+
+	var out []byte
+	if compressed {
+		out = make([]byte, 4*(count+1))
+	}
+	for i := uint32(0); i < count; i++ {
+		end := (i + 1) * sectorSize
+		if end > size {
+			end = size
+		}
+		sector := append([]byte(nil), f.data[i*sectorSize:end]...)
+
+		if compressed {
+			packed, err := testCompress(f, sector)
+			if err != nil {
+				return nil, err
+			}
+			if len(packed) < len(sector) {
+				sector = packed
+			}
+			binary.LittleEndian.PutUint32(out[4*i:], uint32(len(out)))
+		}
+		if encrypted {
+			encrypt(sector, key+i)
+		}
+		out = append(out, sector...)
+	}
+
+	if compressed {
+		binary.LittleEndian.PutUint32(out[4*count:], uint32(len(out)))
+		if encrypted {
+			encrypt(out[:4*(count+1)], key-1)
+		}
+	}
+
+	return out, nil
+}
+
+// Compresses a sector or single unit file.
+func testCompress(f *testFile, data []byte) ([]byte, error) {
+	if f.flags&FileImplode != 0 {
+		return pkware.Implode(data, pkware.Binary, pkware.Dict4K)
+	}
+
+	return comp.Compress(data, f.mask)
+}
+
+// Returns the encrypted hash table of the files, with twice as many entries as files rounded up to a power of 2.
+func testHashTable(files []testFile) []byte {
+	count := uint32(4)
+	for count < 2*uint32(len(files)) {
+		count <<= 1
+	}
+
+	table := make([]byte, 16*count)
+	for i := range table {
+		table[i] = 0xFF
+	}
+
+	for i, f := range files {
+		index := hashString(f.name, hashTableIndex) % count
+		for binary.LittleEndian.Uint32(table[16*index+12:]) != hashEntryFree {
+			index = (index + 1) % count
+		}
+
+		entry := table[16*index:]
+		binary.LittleEndian.PutUint32(entry, hashString(f.name, hashNameA))
+		binary.LittleEndian.PutUint32(entry[4:], hashString(f.name, hashNameB))
+		binary.LittleEndian.PutUint16(entry[8:], f.locale)
+		binary.LittleEndian.PutUint16(entry[10:], 0)
+		binary.LittleEndian.PutUint32(entry[12:], uint32(i))
+	}
+
+	encrypt(table, keyHashTable)
+	return table
+}
+
+// Returns the HET table of the files with its common header, using 64-bit name hashes.
+func testHETTable(files []testFile) []byte {
+	totalCount := 2 * uint64(len(files))
+	indexSize := testBitsFor(uint64(len(files) - 1))
+
+	nameHashes := make([]byte, totalCount)
+	indexes := make([]byte, (totalCount*uint64(indexSize)+7)/8)
+	for i, f := range files {
+		hash := hashJenkins(f.name) | 1<<63
+		entry := hash % totalCount
+		for nameHashes[entry] != 0 {
+			entry = (entry + 1) % totalCount
+		}
+
+		nameHashes[entry] = byte(hash >> 56)
+		testWriteBits(indexes, entry*uint64(indexSize), indexSize, uint64(i))
+	}
+
+	data := make([]byte, 32, 32+len(nameHashes)+len(indexes))
+	for i, value := range []uint32{uint32(cap(data)), uint32(len(files)), uint32(totalCount), 64, indexSize, 0, indexSize, uint32(len(indexes))} {
+		binary.LittleEndian.PutUint32(data[4*i:], value)
+	}
+	data = append(append(data, nameHashes...), indexes...)
+
+	return testExtTable(idHET, data, keyHashTable)
+}
+
+// Returns the BET table of the file entries with its common header, storing the lower 56 bits of the name hashes.
+func testBETTable(files []testFile, entries []fileEntry) []byte {
+	var flags []uint32
+	flagIndexes := map[uint32]int{}
+	var maxPos uint64
+	var maxFileSize, maxCmpSize uint32
+	for _, entry := range entries {
+		if _, ok := flagIndexes[entry.flags]; !ok {
+			flagIndexes[entry.flags] = len(flags)
+			flags = append(flags, entry.flags)
+		}
+		if entry.pos > maxPos {
+			maxPos = entry.pos
+		}
+		if entry.fileSize > maxFileSize {
+			maxFileSize = entry.fileSize
+		}
+		if entry.cmpSize > maxCmpSize {
+			maxCmpSize = entry.cmpSize
+		}
+	}
+
+	bitCount := [4]uint32{testBitsFor(maxPos), testBitsFor(uint64(maxFileSize)), testBitsFor(uint64(maxCmpSize)), testBitsFor(uint64(len(flags) - 1))}
+	var bitIndex [4]uint32
+	entrySize := uint32(0)
+	for i, count := range bitCount {
+		bitIndex[i] = entrySize
+		entrySize += count
+	}
+
+	count := uint64(len(entries))
+	table := make([]byte, (count*uint64(entrySize)+7)/8)
+	nameHashes := make([]byte, (count*56+7)/8)
+	for i, entry := range entries {
+		values := [4]uint64{entry.pos, uint64(entry.fileSize), uint64(entry.cmpSize), uint64(flagIndexes[entry.flags])}
+		for j, value := range values {
+			testWriteBits(table, uint64(i)*uint64(entrySize)+uint64(bitIndex[j]), bitCount[j], value)
+		}
+		testWriteBits(nameHashes, uint64(i)*56, 56, hashJenkins(files[i].name)&(1<<56-1))
+	}
+
+	size := 76 + 4*len(flags) + len(table) + len(nameHashes)
+	fields := []uint32{
+		uint32(size), uint32(count), 0x10, entrySize,
+		bitIndex[0], bitIndex[1], bitIndex[2], bitIndex[3], 0,
+		bitCount[0], bitCount[1], bitCount[2], bitCount[3], 0,
+		56, 0, 56, uint32(len(nameHashes)), uint32(len(flags)),
+	}
+
+	data := make([]byte, 4*(len(fields)+len(flags)), size)
+	for i, value := range append(fields, flags...) {
+		binary.LittleEndian.PutUint32(data[4*i:], value)
+	}
+	data = append(append(data, table...), nameHashes...)
+
+	return testExtTable(idBET, data, keyBlockTable)
+}
+
+// Prefixes the data of a HET or BET table with the common header and encrypts it.
+func testExtTable(signature uint32, data []byte, key uint32) []byte {
+	encrypt(data, key)
+
+	header := make([]byte, 12)
+	binary.LittleEndian.PutUint32(header, signature)
+	binary.LittleEndian.PutUint32(header[4:], 1)
+	binary.LittleEndian.PutUint32(header[8:], uint32(len(data)))
+
+	return append(header, data...)
+}
+
+// Returns the number of bits needed to store a value, at least 1.
+func testBitsFor(value uint64) uint32 {
+	if value == 0 {
+		return 1
+	}
+
+	return uint32(bits.Len64(value))
+}
+
+// Writes count bits of a value at a bit position of a bit array, least significant bit first.
+func testWriteBits(data []byte, pos uint64, count uint32, value uint64) {
+	for i := uint32(0); i < count; i++ {
+		bit := pos + uint64(i)
+		if value>>i&1 != 0 {
+			data[bit/8] |= 1 << (bit % 8)
+		}
+	}
+}
+
+// Encrypts the whole 32-bit words of data in place, reversing decrypt.
+func encrypt(data []byte, key uint32) {
+	seed := uint32(0xEEEEEEEE)
+
+	for i := 0; i+4 <= len(data); i += 4 {
+		seed += cryptTable[keyMix+int(key&0xFF)]
+		value := binary.LittleEndian.Uint32(data[i:])
+		binary.LittleEndian.PutUint32(data[i:], value^(key+seed))
+
+		key = (^key<<21 + 0x11111111) | key>>11
+		seed = value + seed + seed<<5 + 3
+	}
+}
+
+// Places data at a position of the image.
+func (img *testImage) put(pos uint64, data []byte) {
+	img.parts = append(img.parts, testPart{pos: int64(pos), data: data})
+}
+
+// Implementation of the io.ReaderAt interface.
+func (img *testImage) ReadAt(buffer []byte, offset int64) (int, error) {
+	if offset >= img.size {
+		return 0, io.EOF
+	}
+
+	n := len(buffer)
+	if int64(n) > img.size-offset {
+		n = int(img.size - offset)
+	}
+	for i := range buffer[:n] {
+		buffer[i] = 0
+	}
+
+	for _, part := range img.parts {
+		start, end := part.pos, part.pos+int64(len(part.data))
+		if end <= offset || start >= offset+int64(n) {
+			continue
+		}
+		if start < offset {
+			copy(buffer[:n], part.data[offset-start:])
+		} else {
+			copy(buffer[start-offset:n], part.data)
+		}
+	}
+
+	if n < len(buffer) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Returns the whole image, for archives without a hole.
+func (img *testImage) bytes() []byte {
+	data := make([]byte, img.size)
+	img.ReadAt(data, 0)
+	return data
+}
@@ -0,0 +1,205 @@
+package mpq
+
+import (
+	"encoding/binary"
+	"math/bits"
+	"strings"
+)
+
+// Offsets of the parts of the crypt table, selecting the kind of hash or the decryption.
+const (
+	hashTableIndex = 0x000 // Index into the hash table
+	hashNameA      = 0x100 // First check value of a hash entry
+	hashNameB      = 0x200 // Second check value of a hash entry
+	hashFileKey    = 0x300 // Encryption key of a file or table
+	keyMix         = 0x400 // Mixing values for the decryption
+)
+
+// Keys of the hash and block tables, the hashes of "(hash table)" and "(block table)".
+const (
+	keyHashTable  = 0xC3AF3770
+	keyBlockTable = 0xEC83B3A3
+)
+
+var cryptTable = newCryptTable()
+
+func newCryptTable() *[0x500]uint32 {
+	var table [0x500]uint32
+
+	seed := uint32(0x00100001)
+	for index1 := 0; index1 < 0x100; index1++ {
+		for index2 := index1; index2 < len(table); index2 += 0x100 {
+			seed = (seed*125 + 3) % 0x2AAAAB
+			high := seed & 0xFFFF
+			seed = (seed*125 + 3) % 0x2AAAAB
+			low := seed & 0xFFFF
+
+			table[index2] = high<<16 | low
+		}
+	}
+
+	return &table
+}
+
+// Returns the hash of a file name, case-insensitive and with slashes matching backslashes.
+func hashString(name string, hashType int) uint32 {
+	seed1 := uint32(0x7FED7FED)
+	seed2 := uint32(0xEEEEEEEE)
+
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case 'a' <= c && c <= 'z':
+			c -= 'a' - 'A'
+		case c == '/':
+			c = '\\'
+		}
+
+		seed1 = cryptTable[hashType+int(c)] ^ (seed1 + seed2)
+		seed2 = uint32(c) + seed1 + seed2 + seed2<<5 + 3
+	}
+
+	return seed1
+}
+
+// Decrypts the whole 32-bit words of data in place. Trailing bytes are not encrypted.
+func decrypt(data []byte, key uint32) {
+	seed := uint32(0xEEEEEEEE)
+
+	for i := 0; i+4 <= len(data); i += 4 {
+		seed += cryptTable[keyMix+int(key&0xFF)]
+		value := binary.LittleEndian.Uint32(data[i:]) ^ (key + seed)
+		binary.LittleEndian.PutUint32(data[i:], value)
+
+		key = (^key<<21 + 0x11111111) | key>>11
+		seed = value + seed + seed<<5 + 3
+	}
+}
+
+// Returns the key of a file, computed from its name without the directory.
+func fileKey(name string, pos uint64, fileSize uint32, flags uint32) uint32 {
+	name = name[strings.LastIndexAny(name, "\\/")+1:]
+
+	key := hashString(name, hashFileKey)
+	if flags&FileFixKey != 0 {
+		key = (key + uint32(pos)) ^ fileSize
+	}
+
+	return key
+}
+
+// Finds the key of a file of unknown name from its encrypted sector offsets, whose first value is the size of the
+// table. Returns false if no key decrypts the table to sane offsets.
+func detectFileKey(offsets []byte, tableSize uint32, sectorSize uint32) (uint32, bool) {
+	if len(offsets) < 8 {
+		return 0, false
+	}
+	first := binary.LittleEndian.Uint32(offsets)
+
+	for i := 0; i < 0x100; i++ {
+		// The table is encrypted with the file key - 1, and the first word only depends on the low byte of the key
+		key := (first ^ tableSize) - (0xEEEEEEEE + cryptTable[keyMix+i])
+		if key&0xFF != uint32(i) {
+			continue
+		}
+
+		check := append([]byte(nil), offsets[:8]...)
+		decrypt(check, key)
+		second := binary.LittleEndian.Uint32(check[4:])
+		if binary.LittleEndian.Uint32(check) == tableSize && second >= tableSize && second-tableSize <= sectorSize+4 {
+			return key + 1, true
+		}
+	}
+
+	return 0, false
+}
+
+// Returns the 64-bit Jenkins hash of a file name, as used by the HET table. The name is lowercased and slashes are
+// turned into backslashes.
+func hashJenkins(name string) uint64 {
+	key := []byte(name)
+	for i, c := range key {
+		switch {
+		case 'A' <= c && c <= 'Z':
+			key[i] = c + 'a' - 'A'
+		case c == '/':
+			key[i] = '\\'
+		}
+	}
+
+	primary, secondary := uint32(1), uint32(2)
+	hashLittle2(key, &secondary, &primary)
+
+	return uint64(primary)<<32 | uint64(secondary)
+}
+
+// The hashlittle2 function of lookup3.c by Bob Jenkins, reading the key byte by byte. On return, pc and pb hold the
+// primary and secondary hash; on entry, their seeds.
+func hashLittle2(key []byte, pc *uint32, pb *uint32) {
+	a := 0xDEADBEEF + uint32(len(key)) + *pc
+	b, c := a, a+*pb
+
+	for len(key) > 12 {
+		a += binary.LittleEndian.Uint32(key)
+		b += binary.LittleEndian.Uint32(key[4:])
+		c += binary.LittleEndian.Uint32(key[8:])
+		a, b, c = mixJenkins(a, b, c)
+		key = key[12:]
+	}
+
+	if len(key) == 0 {
+		*pc, *pb = c, b
+		return
+	}
+
+	var tail [12]byte
+	copy(tail[:], key)
+	a += binary.LittleEndian.Uint32(tail[:])
+	b += binary.LittleEndian.Uint32(tail[4:])
+	c += binary.LittleEndian.Uint32(tail[8:])
+	a, b, c = finalJenkins(a, b, c)
+
+	*pc, *pb = c, b
+}
+
+func mixJenkins(a, b, c uint32) (uint32, uint32, uint32) {
+	a -= c
+	a ^= bits.RotateLeft32(c, 4)
+	c += b
+	b -= a
+	b ^= bits.RotateLeft32(a, 6)
+	a += c
+	c -= b
+	c ^= bits.RotateLeft32(b, 8)
+	b += a
+	a -= c
+	a ^= bits.RotateLeft32(c, 16)
+	c += b
+	b -= a
+	b ^= bits.RotateLeft32(a, 19)
+	a += c
+	c -= b
+	c ^= bits.RotateLeft32(b, 4)
+	b += a
+
+	return a, b, c
+}
+
+func finalJenkins(a, b, c uint32) (uint32, uint32, uint32) {
+	c ^= b
+	c -= bits.RotateLeft32(b, 14)
+	a ^= c
+	a -= bits.RotateLeft32(c, 11)
+	b ^= a
+	b -= bits.RotateLeft32(a, 25)
+	c ^= b
+	c -= bits.RotateLeft32(b, 16)
+	a ^= c
+	a -= bits.RotateLeft32(c, 4)
+	b ^= a
+	b -= bits.RotateLeft32(a, 14)
+	c ^= b
+	c -= bits.RotateLeft32(b, 24)
+
+	return a, b, c
+}
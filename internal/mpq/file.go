@@ -0,0 +1,215 @@
+package mpq
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/slyh/go-stormlib/comp"
+	"github.com/slyh/go-stormlib/comp/pkware"
+)
+
+// A file open for reading from an archive.
+//
+// A File is not safe for concurrent use.
+type File struct {
+	archive  *Archive
+	entry    *fileEntry
+	index    int    // Index of the file entry
+	name     string // Name of the file, or its pseudo name
+	key      uint32
+	keyKnown bool     // Whether the key is known, otherwise it is detected from the sector offsets
+	unit     uint32   // Size of a sector, or of the whole file if stored as a single unit
+	offsets  []uint32 // Positions of the compressed sectors relative to the file data, nil until loaded
+	sector   int      // Index of the sector in data, -1 if none
+	data     []byte   // Last sector read
+}
+
+// Opens a file by its name or pseudo name.
+func (a *Archive) OpenFile(name string) (*File, error) {
+	index, ok := a.fileIndex(name)
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	entry := &a.files[index]
+	switch {
+	case entry.flags&FileDeleteMarker != 0:
+		return nil, ErrNotFound
+	case entry.flags&FilePatchFile != 0:
+		return nil, fmt.Errorf("%w (patch file)", ErrNotSupported)
+	}
+
+	f := File{archive: a, entry: entry, index: index, name: name, unit: a.sectorSize, sector: -1}
+	if entry.name != "" {
+		f.name = entry.name
+	}
+
+	if entry.flags&FileEncrypted != 0 && (entry.name != "" || !isPseudoName(name)) {
+		f.key = fileKey(f.name, entry.pos, entry.fileSize, entry.flags)
+		f.keyKnown = true
+	}
+	if entry.flags&FileSingleUnit != 0 {
+		f.unit = entry.fileSize
+	}
+
+	return &f, nil
+}
+
+// Returns the name of the file, or its pseudo name if the name is not known.
+func (f *File) Name() string {
+	return f.name
+}
+
+// Returns the uncompressed size of the file.
+func (f *File) Size() uint64 {
+	return uint64(f.entry.fileSize)
+}
+
+// Returns the file time of the file as FILETIME, or 0 if not present.
+func (f *File) FileTime() uint64 {
+	return f.entry.fileTime
+}
+
+// Implementation of the io.ReaderAt interface.
+func (f *File) ReadAt(buffer []byte, offset int64) (int, error) {
+	if offset < 0 {
+		return 0, errors.New("mpq: negative offset")
+	}
+
+	size := int64(f.entry.fileSize)
+	n := 0
+	for n < len(buffer) && offset+int64(n) < size {
+		pos := offset + int64(n)
+		sector := int(pos / int64(f.unit))
+
+		data, err := f.readSector(sector)
+		if err != nil {
+			return n, err
+		}
+		n += copy(buffer[n:], data[pos-int64(sector)*int64(f.unit):])
+	}
+
+	if n < len(buffer) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+// Returns the decrypted and decompressed data of a sector, reading it unless it was the last one read.
+func (f *File) readSector(sector int) ([]byte, error) {
+	if sector == f.sector {
+		return f.data, nil
+	}
+
+	entry := f.entry
+	size := entry.fileSize - uint32(sector)*f.unit
+	if size > f.unit {
+		size = f.unit
+	}
+	compressed := entry.flags&(FileImplode|FileCompress) != 0
+
+	var data []byte
+	var err error
+	switch {
+	case entry.flags&FileSingleUnit != 0:
+		data, err = f.archive.read(entry.pos, uint64(entry.cmpSize))
+
+	case compressed:
+		err = f.loadOffsets()
+		if err == nil {
+			start, end := f.offsets[sector], f.offsets[sector+1]
+			data, err = f.archive.read(entry.pos+uint64(start), uint64(end-start))
+		}
+
+	default:
+		data, err = f.archive.read(entry.pos+uint64(sector)*uint64(f.unit), uint64(size))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if entry.flags&FileEncrypted != 0 {
+		if !f.keyKnown {
+			return nil, fmt.Errorf("%w (unknown file key)", ErrNotSupported)
+		}
+		decrypt(data, f.key+uint32(sector))
+	}
+
+	if compressed && len(data) < int(size) {
+		data, err = decompress(data, int(size), entry.flags)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(data) != int(size) {
+		return nil, fmt.Errorf("%w (sector %d of %s has %d bytes instead of %d)", ErrCorrupt, sector, f.name, len(data), size)
+	}
+
+	f.sector, f.data = sector, data
+	return data, nil
+}
+
+// Decompresses a sector, either imploded or compressed with the methods of its mask byte. Imploded data is read up to
+// one byte past the size, so that a sector that decompresses to more is caught without exploding all of it.
+func decompress(data []byte, size int, flags uint32) ([]byte, error) {
+	var err error
+	if flags&FileImplode != 0 {
+		data, err = io.ReadAll(io.LimitReader(pkware.NewReader(bytes.NewReader(data)), int64(size)+1))
+	} else {
+		data, err = comp.Decompress(data, size)
+	}
+
+	switch {
+	case errors.Is(err, comp.ErrNotSupported):
+		return nil, fmt.Errorf("%w (%v)", ErrNotSupported, err)
+	case err != nil:
+		return nil, fmt.Errorf("%w (%v)", ErrCorrupt, err)
+	}
+
+	return data, nil
+}
+
+// Loads the sector offsets of a compressed file, detecting the key from them if it is not known.
+func (f *File) loadOffsets() error {
+	if f.offsets != nil {
+		return nil
+	}
+
+	entry := f.entry
+	count := (entry.fileSize + f.unit - 1) / f.unit
+	tableSize := 4 * (count + 1)
+	if entry.flags&FileSectorCRC != 0 {
+		// The offsets end with the position of the checksums
+		tableSize += 4
+	}
+
+	data, err := f.archive.read(entry.pos, uint64(4*(count+1)))
+	if err != nil {
+		return err
+	}
+
+	if entry.flags&FileEncrypted != 0 {
+		if !f.keyKnown {
+			f.key, f.keyKnown = detectFileKey(data, tableSize, f.unit)
+			if !f.keyKnown {
+				return fmt.Errorf("%w (unknown file key)", ErrNotSupported)
+			}
+		}
+		decrypt(data, f.key-1)
+	}
+
+	offsets := make([]uint32, count+1)
+	for i := range offsets {
+		offsets[i] = binary.LittleEndian.Uint32(data[4*i:])
+		if i > 0 && (offsets[i] < offsets[i-1] || offsets[i]-offsets[i-1] > f.unit) || offsets[i] > entry.cmpSize {
+			return fmt.Errorf("%w (invalid sector offsets of %s)", ErrCorrupt, f.name)
+		}
+	}
+
+	f.offsets = offsets
+	return nil
+}
@@ -0,0 +1,176 @@
+package mpq
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A file found in an archive.
+type Entry struct {
+	Name       string // Name of the file, or its pseudo name if the name is not known
+	HashIndex  uint32 // Index of the hash entry, 0xFFFFFFFF for archives without a hash table
+	BlockIndex uint32 // Index of the file entry
+	FileSize   uint32 // Uncompressed size of the file
+	CompSize   uint32 // Compressed size of the file
+	Flags      uint32 // File flags, see File*
+	FileTime   uint64 // FILETIME from (attributes), 0 if not present
+	Locale     uint16 // Locale of the file, 0 for archives without a hash table
+}
+
+// A search for the files matching a mask.
+type Finder struct {
+	archive *Archive
+	mask    string
+	next    int // Index of the next hash entry or, without a hash table, file entry to check
+}
+
+// Starts a search for the files matching a mask with the wildcards '*' and '?'. The mask is not case-sensitive.
+func (a *Archive) Find(mask string) *Finder {
+	return &Finder{archive: a, mask: mask}
+}
+
+// Returns the next file matching the mask, or false if there are no more files.
+//
+// Files are returned in the order of the hash table, once for each locale. Without a hash table, they are returned in
+// the order of the file table.
+func (f *Finder) Next() (Entry, bool) {
+	a := f.archive
+
+	for ; f.hasNext(); f.next++ {
+		hashIndex := uint32(hashEntryFree)
+		blockIndex := uint32(f.next)
+		var locale uint16
+		if a.hashes != nil {
+			hashIndex = uint32(f.next)
+			blockIndex = a.hashes[f.next].blockIndex
+			locale = a.hashes[f.next].locale
+		}
+
+		if !a.exists(blockIndex) {
+			continue
+		}
+
+		file := &a.files[blockIndex]
+		name := file.name
+		if name == "" {
+			name = pseudoName(int(blockIndex))
+		}
+		if !MatchMask(name, f.mask) {
+			continue
+		}
+
+		f.next++
+		return Entry{
+			Name:       name,
+			HashIndex:  hashIndex,
+			BlockIndex: blockIndex,
+			FileSize:   file.fileSize,
+			CompSize:   file.cmpSize,
+			Flags:      file.flags,
+			FileTime:   file.fileTime,
+			Locale:     locale,
+		}, true
+	}
+
+	return Entry{}, false
+}
+
+func (f *Finder) hasNext() bool {
+	if f.archive.hashes != nil {
+		return f.next < len(f.archive.hashes)
+	}
+	return f.next < len(f.archive.files)
+}
+
+// Adds the names of a list file, separated by line breaks or semicolons.
+func (a *Archive) AddListFile(data []byte) {
+	a.AddNames(strings.FieldsFunc(string(data), func(r rune) bool {
+		return r == '\r' || r == '\n' || r == ';'
+	}))
+}
+
+// Adds names of files, so that they are found by their names instead of their pseudo names. Names of files that are
+// not in the archive are ignored.
+func (a *Archive) AddNames(names []string) {
+	for _, name := range names {
+		name = strings.TrimLeft(name, " \t")
+		if name == "" {
+			continue
+		}
+
+		if a.hashes == nil {
+			if index, ok := a.het.lookup(name, a.files); ok {
+				a.files[index].name = name
+			}
+			continue
+		}
+
+		// Name the files of all locales
+		a.forEachHash(name, func(index int) bool {
+			a.files[a.hashes[index].blockIndex].name = name
+			return true
+		})
+	}
+}
+
+// Returns the name used for a file whose name is not known, as StormLib does.
+func pseudoName(index int) string {
+	return fmt.Sprintf("File%08d.xxx", index)
+}
+
+// Parses a name in the form of pseudoName, with any extension.
+func parsePseudoName(name string) (int, bool) {
+	if !isPseudoName(name) {
+		return 0, false
+	}
+
+	index, err := strconv.Atoi(name[4:12])
+	return index, err == nil
+}
+
+func isPseudoName(name string) bool {
+	if len(name) < 13 || !strings.EqualFold(name[:4], "File") || name[12] != '.' {
+		return false
+	}
+
+	for _, c := range name[4:12] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Reports whether a name matches a mask with the wildcards '*' and '?', ignoring case.
+func MatchMask(name string, mask string) bool {
+	name = strings.ToLower(name)
+	mask = strings.ToLower(mask)
+
+	// Position after the last '*' in the mask, and the position in the name it matched up to
+	star, starName := -1, 0
+	i, j := 0, 0
+	for i < len(name) {
+		switch {
+		case j < len(mask) && mask[j] == '*':
+			star, starName = j+1, i
+			j++
+		case j < len(mask) && (mask[j] == '?' || mask[j] == name[i]):
+			i++
+			j++
+		case star >= 0:
+			// Let the last '*' match one more byte
+			starName++
+			i, j = starName, star
+		default:
+			return false
+		}
+	}
+
+	for j < len(mask) && mask[j] == '*' {
+		j++
+	}
+
+	return j == len(mask)
+}
@@ -0,0 +1,158 @@
+package mpq
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// HET table, mapping Jenkins hashes of the names to the indexes of the BET table.
+type hetTable struct {
+	nameHashes     []byte // Top 8 bits of the name hash of each entry, 0 for a free entry
+	indexes        []byte // Bit array of the file indexes of the entries
+	indexSizeTotal uint32 // Size of an entry of indexes, in bits
+	indexSize      uint32 // Used bits of an entry of indexes
+	nameHashBits   uint32 // Size of the name hashes, in bits
+	andMask        uint64
+	orMask         uint64
+}
+
+// Parses the HET table data following the common header.
+func parseHET(data []byte) (*hetTable, error) {
+	if len(data) < 32 {
+		return nil, fmt.Errorf("%w (HET table too small)", ErrBadFormat)
+	}
+
+	totalCount := binary.LittleEndian.Uint32(data[8:])
+	h := hetTable{
+		nameHashBits:   binary.LittleEndian.Uint32(data[12:]),
+		indexSizeTotal: binary.LittleEndian.Uint32(data[16:]),
+		indexSize:      binary.LittleEndian.Uint32(data[24:]),
+	}
+	indexTableSize := binary.LittleEndian.Uint32(data[28:])
+
+	if totalCount == 0 || h.nameHashBits < 8 || h.nameHashBits > 64 || h.indexSize > 32 || h.indexSize > h.indexSizeTotal ||
+		uint64(len(data)) < 32+uint64(totalCount)+uint64(indexTableSize) ||
+		uint64(indexTableSize)*8 < uint64(totalCount)*uint64(h.indexSizeTotal) {
+		return nil, fmt.Errorf("%w (invalid HET table)", ErrBadFormat)
+	}
+
+	h.nameHashes = data[32 : 32+totalCount]
+	h.indexes = data[32+totalCount : 32+totalCount+indexTableSize]
+
+	h.andMask = ^uint64(0)
+	if h.nameHashBits < 64 {
+		h.andMask = 1<<h.nameHashBits - 1
+	}
+	h.orMask = 1 << (h.nameHashBits - 1)
+
+	return &h, nil
+}
+
+// Returns the index of the file entry of a name.
+func (h *hetTable) lookup(name string, files []fileEntry) (int, bool) {
+	hash := hashJenkins(name)&h.andMask | h.orMask
+	hash1 := byte(hash >> (h.nameHashBits - 8))
+
+	count := uint64(len(h.nameHashes))
+	start := hash % count
+	for i := uint64(0); i < count; i++ {
+		entry := (start + i) % count
+		if h.nameHashes[entry] == 0 {
+			break
+		}
+		if h.nameHashes[entry] != hash1 {
+			continue
+		}
+
+		index := h.fileIndex(entry)
+		if index < uint64(len(files)) && files[index].nameHash == hash && files[index].flags&FileExists != 0 {
+			return int(index), true
+		}
+	}
+
+	return 0, false
+}
+
+// Returns the file index of an entry.
+func (h *hetTable) fileIndex(entry uint64) uint64 {
+	return readBits(h.indexes, entry*uint64(h.indexSizeTotal), h.indexSize)
+}
+
+// Parses the BET table data following the common header into the file table, adding the name hashes of the HET table.
+func parseBET(data []byte, het *hetTable) ([]fileEntry, error) {
+	if len(data) < 76 {
+		return nil, fmt.Errorf("%w (BET table too small)", ErrBadFormat)
+	}
+
+	field := func(i int) uint32 {
+		return binary.LittleEndian.Uint32(data[4*i:])
+	}
+	entryCount := field(1)
+	entrySize := field(3)
+	bitIndex := [4]uint32{field(4), field(5), field(6), field(7)} // File position, file size, compressed size, flag index
+	bitCount := [4]uint32{field(9), field(10), field(11), field(12)}
+	nameHashTotal := field(14)
+	nameHashCount := field(16)
+	nameHashArraySize := field(17)
+	flagCount := field(18)
+
+	for i := range bitIndex {
+		if bitCount[i] > 64 || bitIndex[i]+bitCount[i] > entrySize || i > 0 && bitCount[i] > 32 {
+			return nil, fmt.Errorf("%w (invalid BET table)", ErrBadFormat)
+		}
+	}
+
+	flagsEnd := 76 + 4*uint64(flagCount)
+	tableEnd := flagsEnd + (uint64(entrySize)*uint64(entryCount)+7)/8
+	if uint64(len(data)) < tableEnd+uint64(nameHashArraySize) || nameHashCount > nameHashTotal || nameHashCount > 56 ||
+		uint64(nameHashArraySize)*8 < uint64(nameHashTotal)*uint64(entryCount) || entryCount > uint32(len(data)) {
+		return nil, fmt.Errorf("%w (invalid BET table)", ErrBadFormat)
+	}
+
+	flags := data[76:flagsEnd]
+	table := data[flagsEnd:tableEnd]
+	nameHashes := data[tableEnd : tableEnd+uint64(nameHashArraySize)]
+
+	files := make([]fileEntry, entryCount)
+	for i := range files {
+		entry := uint64(i) * uint64(entrySize)
+		field := func(j int) uint64 {
+			return readBits(table, entry+uint64(bitIndex[j]), bitCount[j])
+		}
+
+		files[i] = fileEntry{
+			pos:      field(0),
+			fileSize: uint32(field(1)),
+			cmpSize:  uint32(field(2)),
+		}
+		if flagIndex := field(3); flagIndex < uint64(flagCount) {
+			files[i].flags = binary.LittleEndian.Uint32(flags[4*flagIndex:])
+		}
+	}
+
+	// The full name hash is split between the HET table (top 8 bits) and the BET table
+	for entry, hash1 := range het.nameHashes {
+		if hash1 == 0 {
+			continue
+		}
+
+		index := het.fileIndex(uint64(entry))
+		if index < uint64(len(files)) {
+			hash2 := readBits(nameHashes, index*uint64(nameHashTotal), nameHashCount)
+			files[index].nameHash = uint64(hash1)<<nameHashCount | hash2
+		}
+	}
+
+	return files, nil
+}
+
+// Reads count bits at a bit position of a bit array, least significant bit first.
+func readBits(data []byte, pos uint64, count uint32) uint64 {
+	var value uint64
+	for i := uint32(0); i < count; i++ {
+		bit := pos + uint64(i)
+		value |= uint64(data[bit/8]>>(bit%8)&1) << i
+	}
+
+	return value
+}
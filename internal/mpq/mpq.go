@@ -0,0 +1,517 @@
+// Package mpq reads MPQ archives in pure Go. It backs the purego build of the storm package.
+//
+// Archives of the format versions 1 to 4 are supported, with the classic hash and block tables as well as the HET and
+// BET tables. Files are decrypted and decompressed with the comp package. Patch files are not supported.
+package mpq
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/slyh/go-stormlib/comp"
+)
+
+var (
+	ErrNotFound     = errors.New("mpq: file not found")
+	ErrBadFormat    = errors.New("mpq: bad format")
+	ErrCorrupt      = errors.New("mpq: file corrupt")
+	ErrNotSupported = errors.New("mpq: not supported")
+)
+
+// File flags of the block and BET tables, matching MPQ_FILE_*.
+const (
+	FileImplode      = 0x00000100 // Imploded by the PKWARE Data Compression Library
+	FileCompress     = 0x00000200 // Compressed by the methods of the mask byte of each sector
+	FileEncrypted    = 0x00010000 // Encrypted with a key computed from the file name
+	FileFixKey       = 0x00020000 // The key is adjusted by the position and size of the file
+	FilePatchFile    = 0x00100000 // Patch file, starting with TPatchInfo
+	FileSingleUnit   = 0x01000000 // Stored as a single unit rather than split into sectors
+	FileDeleteMarker = 0x02000000 // Marks a deleted file in a patch archive
+	FileSectorCRC    = 0x04000000 // Has checksums after the sectors
+	FileExists       = 0x80000000 // Set for files that were not deleted
+)
+
+const (
+	idMPQ      = 0x1A51504D // 'MPQ\x1A'
+	idUserData = 0x1B51504D // 'MPQ\x1B'
+	idHET      = 0x1A544548 // 'HET\x1A'
+	idBET      = 0x1A544542 // 'BET\x1A'
+
+	hashEntryFree    = 0xFFFFFFFF // Hash entry that was never used, ending a search
+	hashEntryDeleted = 0xFFFFFFFE // Hash entry of a deleted file
+
+	headerSearchStep = 0x200 // The header is searched for at multiples of this offset
+	maxHeaderSize    = 0xD0  // Size of the header of format version 4
+)
+
+// Options for opening an archive, mirroring the MPQ_OPEN_* flags.
+type Options struct {
+	NoListFile     bool // Don't read the names from (listfile)
+	NoAttributes   bool // Don't read the file times from (attributes)
+	NoHeaderSearch bool // Only look for the header at the start of the file
+	ForceV1        bool // Read the header as format version 1, ignoring the version field
+}
+
+// Header of an archive, with the table sizes of all versions filled in.
+type header struct {
+	version          uint16
+	sectorSize       uint32
+	archiveSize      uint64
+	hashTablePos     uint64
+	blockTablePos    uint64
+	hiBlockTablePos  uint64
+	hetTablePos      uint64
+	betTablePos      uint64
+	hashTableCount   uint32
+	blockTableCount  uint32
+	hashTableSize    uint64 // Stored size of the hash table, smaller than its entries if compressed
+	blockTableSize   uint64
+	hiBlockTableSize uint64
+	hetTableSize     uint64
+	betTableSize     uint64
+}
+
+// Entry of the hash table.
+type hashEntry struct {
+	name1      uint32 // Hash of the name with hashNameA
+	name2      uint32 // Hash of the name with hashNameB
+	locale     uint16
+	blockIndex uint32 // Index into the file table, or hashEntryFree or hashEntryDeleted
+}
+
+// Entry of the file table, built from the block table or the BET table.
+type fileEntry struct {
+	pos      uint64 // Position of the file data, relative to the header
+	cmpSize  uint32
+	fileSize uint32
+	flags    uint32
+	nameHash uint64 // Jenkins hash of the name, masked as in the HET table
+	fileTime uint64 // FILETIME from (attributes), 0 if not present
+	name     string // Name from a list file, empty if not known
+}
+
+// An MPQ archive open for reading.
+//
+// An Archive is not safe for concurrent use.
+type Archive struct {
+	r          io.ReaderAt
+	base       int64  // Position of the header in r
+	end        uint64 // Size of the data from the header on
+	sectorSize uint32
+	hashes     []hashEntry // Hash table, nil if the archive only has a HET table
+	het        *hetTable
+	files      []fileEntry
+}
+
+// Opens an archive of size bytes read from r.
+func Open(r io.ReaderAt, size int64, options Options) (*Archive, error) {
+	base, data, err := findHeader(r, size, options.NoHeaderSearch)
+	if err != nil {
+		return nil, err
+	}
+
+	h, err := parseHeader(data, options.ForceV1)
+	if err != nil {
+		return nil, err
+	}
+
+	a := Archive{r: r, base: base, end: uint64(size - base), sectorSize: h.sectorSize}
+
+	err = a.loadTables(h)
+	if err != nil {
+		return nil, err
+	}
+
+	a.AddNames([]string{"(listfile)", "(attributes)", "(signature)"})
+	if !options.NoListFile {
+		a.AddListFile(a.readInternal("(listfile)"))
+	}
+	if !options.NoAttributes {
+		a.loadAttributes(a.readInternal("(attributes)"))
+	}
+
+	return &a, nil
+}
+
+// Finds the header, returning its position and the bytes from there on, up to the size of the largest header.
+func findHeader(r io.ReaderAt, size int64, noSearch bool) (int64, []byte, error) {
+	data := make([]byte, maxHeaderSize)
+
+	for pos := int64(0); pos+32 <= size; pos += headerSearchStep {
+		n, err := r.ReadAt(data, pos)
+		if n < 32 {
+			if err == nil || err == io.EOF {
+				err = ErrBadFormat
+			}
+			return 0, nil, err
+		}
+
+		switch binary.LittleEndian.Uint32(data) {
+		case idMPQ:
+			return pos, data[:n], nil
+
+		case idUserData:
+			// The user data points to the header
+			headerPos := pos + int64(binary.LittleEndian.Uint32(data[8:]))
+			n, err = r.ReadAt(data, headerPos)
+			if n >= 32 && binary.LittleEndian.Uint32(data) == idMPQ {
+				return headerPos, data[:n], nil
+			}
+			if err != nil && err != io.EOF {
+				return 0, nil, err
+			}
+		}
+
+		if noSearch {
+			break
+		}
+	}
+
+	return 0, nil, ErrBadFormat
+}
+
+func parseHeader(data []byte, forceV1 bool) (*header, error) {
+	headerSize := binary.LittleEndian.Uint32(data[4:])
+	h := header{
+		archiveSize:     uint64(binary.LittleEndian.Uint32(data[8:])),
+		version:         binary.LittleEndian.Uint16(data[12:]),
+		sectorSize:      0x200 << binary.LittleEndian.Uint16(data[14:]),
+		hashTablePos:    uint64(binary.LittleEndian.Uint32(data[16:])),
+		blockTablePos:   uint64(binary.LittleEndian.Uint32(data[20:])),
+		hashTableCount:  binary.LittleEndian.Uint32(data[24:]),
+		blockTableCount: binary.LittleEndian.Uint32(data[28:]),
+	}
+
+	// Like StormLib, unknown versions are read as version 1, which protected archives rely on
+	if forceV1 || h.version > 3 {
+		h.version = 0
+	}
+
+	minSize := [...]uint32{0x20, 0x2C, 0x44, maxHeaderSize}[h.version]
+	if h.version > 0 && (headerSize < minSize || len(data) < int(minSize)) {
+		return nil, fmt.Errorf("%w (header of %d bytes for version %d)", ErrBadFormat, headerSize, h.version+1)
+	}
+	if h.sectorSize == 0 || h.hashTableCount == 0 && h.version < 2 {
+		return nil, fmt.Errorf("%w (invalid header)", ErrBadFormat)
+	}
+
+	if h.version >= 1 {
+		h.hiBlockTablePos = binary.LittleEndian.Uint64(data[32:])
+		h.hashTablePos |= uint64(binary.LittleEndian.Uint16(data[40:])) << 32
+		h.blockTablePos |= uint64(binary.LittleEndian.Uint16(data[42:])) << 32
+	}
+	if h.version >= 2 {
+		h.archiveSize = binary.LittleEndian.Uint64(data[44:])
+		h.betTablePos = binary.LittleEndian.Uint64(data[52:])
+		h.hetTablePos = binary.LittleEndian.Uint64(data[60:])
+	}
+
+	if h.version >= 3 {
+		h.hashTableSize = binary.LittleEndian.Uint64(data[68:])
+		h.blockTableSize = binary.LittleEndian.Uint64(data[76:])
+		h.hiBlockTableSize = binary.LittleEndian.Uint64(data[84:])
+		h.hetTableSize = binary.LittleEndian.Uint64(data[92:])
+		h.betTableSize = binary.LittleEndian.Uint64(data[100:])
+	} else {
+		h.hashTableSize = uint64(h.hashTableCount) * 16
+		h.blockTableSize = uint64(h.blockTableCount) * 16
+		if h.hiBlockTablePos != 0 {
+			h.hiBlockTableSize = uint64(h.blockTableCount) * 2
+		}
+
+		// Version 3 does not store the sizes of the HET and BET tables, they end where the next table starts
+		positions := []uint64{h.hetTablePos, h.betTablePos, h.hashTablePos, h.blockTablePos, h.hiBlockTablePos, h.archiveSize}
+		h.hetTableSize = distanceToNext(h.hetTablePos, positions)
+		h.betTableSize = distanceToNext(h.betTablePos, positions)
+	}
+
+	return &h, nil
+}
+
+// Returns the distance from pos to the nearest of the positions after it, or 0 if pos is 0 or last.
+func distanceToNext(pos uint64, positions []uint64) uint64 {
+	if pos == 0 {
+		return 0
+	}
+
+	var next uint64
+	for _, p := range positions {
+		if p > pos && (next == 0 || p < next) {
+			next = p
+		}
+	}
+	if next == 0 {
+		return 0
+	}
+
+	return next - pos
+}
+
+// Loads the hash and file tables.
+func (a *Archive) loadTables(h *header) error {
+	if h.hetTablePos != 0 && h.betTablePos != 0 {
+		hetData, err := a.loadExtTable(h.hetTablePos, h.hetTableSize, idHET, keyHashTable)
+		if err != nil {
+			return err
+		}
+		a.het, err = parseHET(hetData)
+		if err != nil {
+			return err
+		}
+
+		betData, err := a.loadExtTable(h.betTablePos, h.betTableSize, idBET, keyBlockTable)
+		if err != nil {
+			return err
+		}
+		a.files, err = parseBET(betData, a.het)
+		if err != nil {
+			return err
+		}
+	}
+
+	if h.hashTableCount != 0 {
+		data, err := a.loadTable(h.hashTablePos, h.hashTableSize, uint64(h.hashTableCount)*16, keyHashTable)
+		if err != nil {
+			return err
+		}
+
+		a.hashes = make([]hashEntry, h.hashTableCount)
+		for i := range a.hashes {
+			entry := data[16*i:]
+			a.hashes[i] = hashEntry{
+				name1:      binary.LittleEndian.Uint32(entry),
+				name2:      binary.LittleEndian.Uint32(entry[4:]),
+				locale:     binary.LittleEndian.Uint16(entry[8:]),
+				blockIndex: binary.LittleEndian.Uint32(entry[12:]),
+			}
+		}
+	}
+
+	if a.files == nil && h.blockTableCount != 0 {
+		data, err := a.loadTable(h.blockTablePos, h.blockTableSize, uint64(h.blockTableCount)*16, keyBlockTable)
+		if err != nil {
+			return err
+		}
+
+		var hiData []byte
+		if h.hiBlockTablePos != 0 {
+			hiData, err = a.loadTable(h.hiBlockTablePos, h.hiBlockTableSize, uint64(h.blockTableCount)*2, 0)
+			if err != nil {
+				return err
+			}
+		}
+
+		a.files = make([]fileEntry, h.blockTableCount)
+		for i := range a.files {
+			entry := data[16*i:]
+			a.files[i] = fileEntry{
+				pos:      uint64(binary.LittleEndian.Uint32(entry)),
+				cmpSize:  binary.LittleEndian.Uint32(entry[4:]),
+				fileSize: binary.LittleEndian.Uint32(entry[8:]),
+				flags:    binary.LittleEndian.Uint32(entry[12:]),
+			}
+			if hiData != nil {
+				a.files[i].pos |= uint64(binary.LittleEndian.Uint16(hiData[2*i:])) << 32
+			}
+		}
+	}
+
+	if a.hashes == nil && a.het == nil {
+		return fmt.Errorf("%w (no hash table)", ErrBadFormat)
+	}
+
+	return nil
+}
+
+// Reads a table of size bytes, decrypting it with the key unless 0, and decompressing it to rawSize bytes if smaller.
+func (a *Archive) loadTable(pos uint64, size uint64, rawSize uint64, key uint32) ([]byte, error) {
+	readSize := size
+	if readSize > rawSize {
+		readSize = rawSize
+	}
+
+	data, err := a.read(pos, readSize)
+	if err != nil {
+		return nil, err
+	}
+
+	if key != 0 {
+		decrypt(data, key)
+	}
+
+	if uint64(len(data)) < rawSize {
+		data, err = comp.Decompress(data, int(rawSize))
+		if err != nil {
+			return nil, fmt.Errorf("%w (table at %#x: %v)", ErrCorrupt, pos, err)
+		}
+	}
+
+	return data, nil
+}
+
+// Reads a HET or BET table, returning its data after the common header.
+func (a *Archive) loadExtTable(pos uint64, size uint64, signature uint32, key uint32) ([]byte, error) {
+	if size < 12 {
+		return nil, fmt.Errorf("%w (table at %#x too small)", ErrBadFormat, pos)
+	}
+
+	data, err := a.read(pos, size)
+	if err != nil {
+		return nil, err
+	}
+
+	if binary.LittleEndian.Uint32(data) != signature || binary.LittleEndian.Uint32(data[4:]) != 1 {
+		return nil, fmt.Errorf("%w (invalid table at %#x)", ErrBadFormat, pos)
+	}
+	dataSize := binary.LittleEndian.Uint32(data[8:])
+
+	data = data[12:]
+	decrypt(data, key)
+
+	if uint64(dataSize) > uint64(len(data)) {
+		data, err = comp.Decompress(data, int(dataSize))
+		if err != nil {
+			return nil, fmt.Errorf("%w (table at %#x: %v)", ErrCorrupt, pos, err)
+		}
+	}
+
+	return data[:dataSize], nil
+}
+
+// Reads size bytes at a position relative to the header.
+func (a *Archive) read(pos uint64, size uint64) ([]byte, error) {
+	if pos > a.end || size > a.end-pos {
+		return nil, fmt.Errorf("%w (%d bytes at %#x past the end of the archive)", ErrCorrupt, size, pos)
+	}
+
+	data := make([]byte, size)
+	_, err := a.r.ReadAt(data, a.base+int64(pos))
+	if err == io.EOF {
+		err = ErrCorrupt
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// Reads an internal file, returning nil if it does not exist or cannot be read. Like StormLib, the archive opens
+// without the names and file times in that case.
+func (a *Archive) readInternal(name string) []byte {
+	f, err := a.OpenFile(name)
+	if err != nil {
+		return nil
+	}
+
+	data := make([]byte, f.Size())
+	_, err = f.ReadAt(data, 0)
+	if err != nil && err != io.EOF {
+		return nil
+	}
+
+	return data
+}
+
+// Loads the file times of (attributes), if it has them. Other attributes are not used.
+func (a *Archive) loadAttributes(data []byte) {
+	const (
+		attributesVersion = 100
+		attributeCRC32    = 0x01
+		attributeFileTime = 0x02
+	)
+
+	if len(data) < 8 || binary.LittleEndian.Uint32(data) != attributesVersion {
+		return
+	}
+	flags := binary.LittleEndian.Uint32(data[4:])
+	if flags&attributeFileTime == 0 {
+		return
+	}
+
+	// The arrays may be one entry short, leaving out (attributes) itself
+	for _, count := range []int{len(a.files), len(a.files) - 1} {
+		pos := 8
+		if flags&attributeCRC32 != 0 {
+			pos += 4 * count
+		}
+		if count < 0 || pos+8*count > len(data) {
+			continue
+		}
+
+		for i := 0; i < count; i++ {
+			a.files[i].fileTime = binary.LittleEndian.Uint64(data[pos+8*i:])
+		}
+		return
+	}
+}
+
+// Returns the index of the hash entry and the file entry of a name, preferring the neutral locale, or false if not
+// found. The hash index is hashEntryFree for archives without a hash table.
+func (a *Archive) lookup(name string) (hashIndex uint32, fileIndex int, ok bool) {
+	if a.hashes == nil {
+		fileIndex, ok = a.het.lookup(name, a.files)
+		return hashEntryFree, fileIndex, ok
+	}
+
+	found := -1
+	a.forEachHash(name, func(index int) bool {
+		if found < 0 || a.hashes[index].locale == 0 {
+			found = index
+		}
+		return a.hashes[index].locale != 0
+	})
+	if found < 0 {
+		return 0, 0, false
+	}
+
+	return uint32(found), int(a.hashes[found].blockIndex), true
+}
+
+// Calls fn with the index of each hash entry of a name that refers to an existing file, until fn returns false.
+func (a *Archive) forEachHash(name string, fn func(index int) bool) {
+	count := uint32(len(a.hashes))
+	start := hashString(name, hashTableIndex) % count
+	name1 := hashString(name, hashNameA)
+	name2 := hashString(name, hashNameB)
+
+	for i := uint32(0); i < count; i++ {
+		index := (start + i) % count
+		entry := &a.hashes[index]
+		if entry.blockIndex == hashEntryFree {
+			return
+		}
+
+		if entry.name1 == name1 && entry.name2 == name2 && a.exists(entry.blockIndex) && !fn(int(index)) {
+			return
+		}
+	}
+}
+
+// Reports whether an index of a hash entry refers to an existing file.
+func (a *Archive) exists(blockIndex uint32) bool {
+	return blockIndex < uint32(len(a.files)) && a.files[blockIndex].flags&FileExists != 0
+}
+
+// Reports whether the archive has a file, by its name or pseudo name.
+func (a *Archive) HasFile(name string) bool {
+	_, ok := a.fileIndex(name)
+	return ok
+}
+
+// Returns the index of the file entry of a name, or of a pseudo name if the name is not found.
+func (a *Archive) fileIndex(name string) (int, bool) {
+	_, index, ok := a.lookup(name)
+	if ok {
+		return index, true
+	}
+
+	index, ok = parsePseudoName(name)
+	if ok && index < len(a.files) && a.files[index].flags&FileExists != 0 {
+		return index, true
+	}
+
+	return 0, false
+}
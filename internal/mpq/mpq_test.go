@@ -0,0 +1,349 @@
+package mpq
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/slyh/go-stormlib/comp"
+	"github.com/slyh/go-stormlib/comp/pkware"
+)
+
+var update = flag.Bool("update", false, "write the test archives of the storm package to testdata")
+
+const testFileTime = 0x01D5F3A2B4C00000
+
+// Returns the data of the test files: text followed by zeros, over several sectors.
+func testData() []byte {
+	return append([]byte(strings.Repeat("The quick brown fox jumps over the lazy dog. ", 300)), make([]byte, 3000)...)
+}
+
+// Returns files of every kind the reader handles.
+func testFiles() []testFile {
+	data := testData()
+	files := []testFile{
+		{name: "stored.txt"},
+		{name: "zlib.txt", flags: FileCompress, mask: comp.Zlib},
+		{name: "bzip2.txt", flags: FileCompress, mask: comp.BZip2},
+		{name: "pkware.txt", flags: FileCompress, mask: comp.PKWare},
+		{name: "sparse.txt", flags: FileCompress, mask: comp.Sparse | comp.Zlib},
+		{name: "huffman.txt", flags: FileCompress, mask: comp.Huffman},
+		{name: "implode.txt", flags: FileImplode},
+		{name: "encrypted.txt", flags: FileCompress | FileEncrypted, mask: comp.Zlib},
+		{name: "dir\\fixkey.txt", flags: FileCompress | FileEncrypted | FileFixKey, mask: comp.Zlib},
+		{name: "stored_encrypted.txt", flags: FileEncrypted},
+		{name: "single.txt", flags: FileCompress | FileSingleUnit, mask: comp.Zlib},
+		{name: "single_encrypted.txt", flags: FileCompress | FileSingleUnit | FileEncrypted, mask: comp.Zlib},
+	}
+
+	for i := range files {
+		files[i].data = data
+		files[i].fileTime = testFileTime + uint64(i)
+	}
+	return files
+}
+
+// Opens an archive built for a test.
+func openTest(b testArchive, options Options) (*Archive, error) {
+	img, err := b.build()
+	if err != nil {
+		return nil, err
+	}
+
+	return Open(img, img.size, options)
+}
+
+// Reads a whole file of an archive.
+func readFile(a *Archive, name string) ([]byte, error) {
+	f, err := a.OpenFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, f.Size())
+	_, err = f.ReadAt(data, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// Checks that all test files are found by name and read back with their file times.
+func checkFiles(a *Archive, files []testFile) error {
+	for _, f := range files {
+		data, err := readFile(a, f.name)
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.name, err)
+		}
+		if !bytes.Equal(data, f.data) {
+			return fmt.Errorf("%s: content differs", f.name)
+		}
+
+		file, _ := a.OpenFile(f.name)
+		if file.FileTime() != f.fileTime {
+			return fmt.Errorf("%s: file time %#x, expected %#x", f.name, file.FileTime(), f.fileTime)
+		}
+	}
+
+	return nil
+}
+
+func TestVersions(t *testing.T) {
+	for version := 1; version <= 4; version++ {
+		files := testFiles()
+		a, err := openTest(testArchive{version: version, files: files, listFile: true, attributes: true}, Options{})
+		if err != nil {
+			t.Errorf("Open (version %d): %v", version, err)
+			return
+		}
+
+		err = checkFiles(a, files)
+		if err != nil {
+			t.Errorf("version %d: %v", version, err)
+			return
+		}
+
+		var names []string
+		for finder := a.Find("*.txt"); ; {
+			entry, ok := finder.Next()
+			if !ok {
+				break
+			}
+			names = append(names, entry.Name)
+		}
+		if len(names) != len(files) {
+			t.Errorf("Find (version %d) returned %d files, expected %d: %v", version, len(names), len(files), names)
+			return
+		}
+	}
+}
+
+func TestHETTable(t *testing.T) {
+	for _, version := range []int{3, 4} {
+		for _, noHashTable := range []bool{false, true} {
+			files := testFiles()
+			a, err := openTest(testArchive{version: version, files: files, listFile: true, attributes: true, het: true, noHashTable: noHashTable}, Options{})
+			if err != nil {
+				t.Errorf("Open (version %d, no hash table: %v): %v", version, noHashTable, err)
+				return
+			}
+			if a.het == nil || (a.hashes == nil) != noHashTable {
+				t.Errorf("Open (version %d, no hash table: %v) did not load the expected tables", version, noHashTable)
+				return
+			}
+
+			err = checkFiles(a, files)
+			if err != nil {
+				t.Errorf("version %d, no hash table: %v: %v", version, noHashTable, err)
+				return
+			}
+
+			if a.HasFile("missing.txt") {
+				t.Errorf("HasFile (version %d, no hash table: %v) found a missing file", version, noHashTable)
+				return
+			}
+
+			if noHashTable {
+				entry, ok := a.Find("dir\\fixkey.txt").Next()
+				if !ok || entry.HashIndex != hashEntryFree || entry.BlockIndex != 8 || entry.Locale != 0 {
+					t.Errorf("Find (version %d) without a hash table returned %+v", version, entry)
+					return
+				}
+			}
+		}
+	}
+}
+
+func TestHiBlockTable(t *testing.T) {
+	for _, version := range []int{2, 4} {
+		files := testFiles()
+		a, err := openTest(testArchive{version: version, files: files, listFile: true, attributes: true, het: version == 4, gap: 0x100000000}, Options{})
+		if err != nil {
+			t.Errorf("Open (version %d): %v", version, err)
+			return
+		}
+
+		if a.files[0].pos <= 0xFFFFFFFF {
+			t.Errorf("version %d: file at %#x, expected above 4 GiB", version, a.files[0].pos)
+			return
+		}
+
+		err = checkFiles(a, files)
+		if err != nil {
+			t.Errorf("version %d: %v", version, err)
+			return
+		}
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	files := testFiles()
+	a, err := openTest(testArchive{version: 2, files: files}, Options{NoListFile: true})
+	if err != nil {
+		t.Errorf("Open: %v", err)
+		return
+	}
+
+	for i, f := range files {
+		if f.flags&FileEncrypted == 0 {
+			continue
+		}
+
+		// By name, the key is computed from it
+		data, err := readFile(a, f.name)
+		if err != nil || !bytes.Equal(data, f.data) {
+			t.Errorf("%s: content differs or %v", f.name, err)
+			return
+		}
+
+		// By pseudo name, the key is only known for files with sector offsets to detect it from
+		data, err = readFile(a, pseudoName(i))
+		switch {
+		case f.flags&FileCompress != 0 && f.flags&FileSingleUnit == 0:
+			if err != nil || !bytes.Equal(data, f.data) {
+				t.Errorf("%s (%s): content differs or %v", pseudoName(i), f.name, err)
+				return
+			}
+		case !errors.Is(err, ErrNotSupported):
+			t.Errorf("%s (%s): %v, expected %v", pseudoName(i), f.name, err, ErrNotSupported)
+			return
+		}
+	}
+
+	// The fix key depends on the position of the file
+	f := files[8]
+	if fileKey(f.name, 0x100, 10, f.flags) == fileKey(f.name, 0x200, 10, f.flags) ||
+		fileKey(f.name, 0x100, 10, 0) != fileKey("fixkey.txt", 0, 0, 0) {
+		t.Errorf("fileKey does not follow the position and the plain name")
+		return
+	}
+}
+
+func TestLocales(t *testing.T) {
+	data := testData()
+	files := []testFile{
+		{name: "text.txt", data: data[:100], locale: 0x407},
+		{name: "text.txt", data: data[:200]},
+		{name: "text.txt", data: data[:300], locale: 0x409},
+	}
+
+	a, err := openTest(testArchive{version: 1, files: files, listFile: true}, Options{})
+	if err != nil {
+		t.Errorf("Open: %v", err)
+		return
+	}
+
+	got, err := readFile(a, "text.txt")
+	if err != nil || len(got) != 200 {
+		t.Errorf("text.txt has %d bytes or %v, expected the 200 bytes of the neutral locale", len(got), err)
+		return
+	}
+
+	var locales []uint16
+	for finder := a.Find("text.txt"); ; {
+		entry, ok := finder.Next()
+		if !ok {
+			break
+		}
+		locales = append(locales, entry.Locale)
+	}
+	if len(locales) != 3 {
+		t.Errorf("Find returned the locales %v, expected 3", locales)
+		return
+	}
+}
+
+func TestDecompressImplode(t *testing.T) {
+	data := testData()
+	imploded, err := pkware.Implode(data, pkware.Binary, pkware.Dict4K)
+	if err != nil {
+		t.Errorf("Implode: %v", err)
+		return
+	}
+
+	got, err := decompress(imploded, len(data), FileImplode)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("decompress: content differs or %v", err)
+		return
+	}
+
+	// More data than the sector holds is cut off one byte past its size
+	got, err = decompress(imploded, 100, FileImplode)
+	if err != nil || len(got) != 101 {
+		t.Errorf("decompress returned %d bytes or %v, expected 101", len(got), err)
+		return
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	files := testFiles()
+	img, err := (&testArchive{version: 1, files: files}).build()
+	if err != nil {
+		t.Errorf("build: %v", err)
+		return
+	}
+
+	a, err := Open(img, img.size, Options{})
+	if err != nil {
+		t.Errorf("Open: %v", err)
+		return
+	}
+
+	// A sector that decompresses to more than the file size is rejected
+	index, _ := a.fileIndex("implode.txt")
+	a.files[index].fileSize = 1000
+	_, err = readFile(a, "implode.txt")
+	if !errors.Is(err, ErrCorrupt) {
+		t.Errorf("reading an oversized sector: %v, expected %v", err, ErrCorrupt)
+		return
+	}
+
+	// Truncating the archive cuts off the tables
+	data := img.bytes()
+	_, err = Open(bytes.NewReader(data[:len(data)-8]), int64(len(data)-8), Options{})
+	if !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Open of a truncated archive: %v, expected %v", err, ErrCorrupt)
+		return
+	}
+}
+
+// Writes the archives read by the tests of the storm package in purego builds.
+func TestWriteTestdata(t *testing.T) {
+	if !*update {
+		t.Skip("run with -update to write the test archives")
+	}
+
+	files := testFiles()
+	locales := []testFile{
+		{name: "locale.txt", data: []byte("neutral"), fileTime: testFileTime},
+		{name: "locale.txt", data: []byte("deutsch"), locale: 0x407, fileTime: testFileTime},
+	}
+	archives := map[string]testArchive{
+		"v1.mpq":       {version: 1, files: append(files[:len(files):len(files)], locales...), listFile: true, attributes: true},
+		"v2.mpq":       {version: 2, files: files, listFile: true, attributes: true},
+		"v3.mpq":       {version: 3, files: files, listFile: true, attributes: true, het: true},
+		"v4.mpq":       {version: 4, files: files, listFile: true, attributes: true, het: true, noHashTable: true},
+		"unlisted.mpq": {version: 1, files: files},
+	}
+
+	for name, b := range archives {
+		img, err := b.build()
+		if err != nil {
+			t.Errorf("build (%s): %v", name, err)
+			return
+		}
+
+		err = os.WriteFile(filepath.Join("..", "..", "testdata", name), img.bytes(), 0o644)
+		if err != nil {
+			t.Errorf("WriteFile: %v", err)
+			return
+		}
+	}
+}
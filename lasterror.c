@@ -0,0 +1,55 @@
+//go:build !purego
+
+// Global lock for the wrappers in lasterror.h.
+
+#include "lasterror.h"
+
+#ifdef STORMLIB_WINDOWS
+
+static CRITICAL_SECTION stormMutex;
+
+// Critical sections are recursive. Initialized on load, before any goroutine can call in.
+__attribute__((constructor)) static void stormInitMutex(void)
+{
+    InitializeCriticalSection(&stormMutex);
+}
+
+void stormLock(void)
+{
+    EnterCriticalSection(&stormMutex);
+}
+
+void stormUnlock(void)
+{
+    LeaveCriticalSection(&stormMutex);
+}
+
+#else
+
+#include <pthread.h>
+
+static pthread_mutex_t stormMutex;
+static pthread_once_t stormMutexOnce = PTHREAD_ONCE_INIT;
+
+static void stormInitMutex(void)
+{
+    pthread_mutexattr_t attr;
+
+    pthread_mutexattr_init(&attr);
+    pthread_mutexattr_settype(&attr, PTHREAD_MUTEX_RECURSIVE);
+    pthread_mutex_init(&stormMutex, &attr);
+    pthread_mutexattr_destroy(&attr);
+}
+
+void stormLock(void)
+{
+    pthread_once(&stormMutexOnce, stormInitMutex);
+    pthread_mutex_lock(&stormMutex);
+}
+
+void stormUnlock(void)
+{
+    pthread_mutex_unlock(&stormMutex);
+}
+
+#endif
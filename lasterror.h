@@ -0,0 +1,143 @@
+// Wrappers which capture the error code of a StormLib call together with its result.
+//
+// Outside of Windows, StormLib keeps the last error in a process-wide variable. Goroutines
+// share and hop between OS threads, so reading GetLastError() in a separate cgo call may
+// return the code of an unrelated call. Each wrapper runs the call and reads the error code
+// in the same C call while holding a global lock, and returns the code through pdwError.
+//
+// Calls into StormLib therefore never overlap, not even on different archives. The
+// callback setters in callback.cc take the same lock. It also guards the global locale,
+// which the *Locale wrappers change for the duration of a single call.
+
+#ifndef STORM_LASTERROR_H
+#define STORM_LASTERROR_H
+
+#include <StormLib.h>
+
+#ifdef __cplusplus
+extern "C" {
+#endif
+
+// Serializes StormLib calls. The lock is recursive, so callbacks invoked during a call
+// may take it again.
+void stormLock(void);
+void stormUnlock(void);
+
+#ifdef __cplusplus
+}
+#endif
+
+// Defines shim<name>, which calls <name> and stores the resulting error code in pdwError.
+#define STORM_SHIM(ret, name, params, args) \
+    static inline ret shim##name params     \
+    {                                       \
+        ret result;                         \
+        stormLock();                        \
+        SetLastError(ERROR_SUCCESS);        \
+        result = name args;                 \
+        *pdwError = GetLastError();         \
+        stormUnlock();                      \
+        return result;                      \
+    }
+
+// Defines shim<name> for a function which does not report errors through GetLastError().
+#define STORM_SHIM_NOERROR(ret, name, params, args) \
+    static inline ret shim##name params             \
+    {                                               \
+        ret result;                                 \
+        stormLock();                                \
+        result = name args;                         \
+        stormUnlock();                              \
+        return result;                              \
+    }
+
+// Defines shim<name>Locale, which works like shim<name> with the locale set to lcLocale.
+#define STORM_SHIM_LOCALE(ret, name, params, args)  \
+    static inline ret shim##name##Locale params     \
+    {                                               \
+        ret result;                                 \
+        LCID lcPrevious;                            \
+        stormLock();                                \
+        lcPrevious = SFileSetLocale(lcLocale);      \
+        SetLastError(ERROR_SUCCESS);                \
+        result = name args;                         \
+        *pdwError = GetLastError();                 \
+        SFileSetLocale(lcPrevious);                 \
+        stormUnlock();                              \
+        return result;                              \
+    }
+
+// Global flags
+STORM_SHIM_NOERROR(LCID, SFileGetLocale, (void), ())
+STORM_SHIM_NOERROR(LCID, SFileSetLocale, (LCID lcNewLocale), (lcNewLocale))
+
+// Archive manipulation
+STORM_SHIM(bool, SFileOpenArchive, (const TCHAR * szMpqName, DWORD dwPriority, DWORD dwFlags, HANDLE * phMpq, DWORD * pdwError), (szMpqName, dwPriority, dwFlags, phMpq))
+STORM_SHIM(bool, SFileCreateArchive, (const TCHAR * szMpqName, DWORD dwCreateFlags, DWORD dwMaxFileCount, HANDLE * phMpq, DWORD * pdwError), (szMpqName, dwCreateFlags, dwMaxFileCount, phMpq))
+STORM_SHIM(bool, SFileCreateArchive2, (const TCHAR * szMpqName, PSFILE_CREATE_MPQ pCreateInfo, HANDLE * phMpq, DWORD * pdwError), (szMpqName, pCreateInfo, phMpq))
+STORM_SHIM(bool, SFileFlushArchive, (HANDLE hMpq, DWORD * pdwError), (hMpq))
+STORM_SHIM(bool, SFileCloseArchive, (HANDLE hMpq, DWORD * pdwError), (hMpq))
+STORM_SHIM_NOERROR(int, SFileAddListFile, (HANDLE hMpq, const TCHAR * szListFile), (hMpq, szListFile))
+STORM_SHIM(bool, SFileCompactArchive, (HANDLE hMpq, const TCHAR * szListFile, bool bReserved, DWORD * pdwError), (hMpq, szListFile, bReserved))
+STORM_SHIM(DWORD, SFileGetAttributes, (HANDLE hMpq, DWORD * pdwError), (hMpq))
+STORM_SHIM(bool, SFileSetAttributes, (HANDLE hMpq, DWORD dwFlags, DWORD * pdwError), (hMpq, dwFlags))
+STORM_SHIM(bool, SFileUpdateFileAttributes, (HANDLE hMpq, const char * szFileName, DWORD * pdwError), (hMpq, szFileName))
+STORM_SHIM(bool, SFileSetMaxFileCount, (HANDLE hMpq, DWORD dwMaxFileCount, DWORD * pdwError), (hMpq, dwMaxFileCount))
+
+// Patch archives
+STORM_SHIM(bool, SFileOpenPatchArchive, (HANDLE hMpq, const TCHAR * szPatchMpqName, const char * szPatchPathPrefix, DWORD dwFlags, DWORD * pdwError), (hMpq, szPatchMpqName, szPatchPathPrefix, dwFlags))
+STORM_SHIM_NOERROR(bool, SFileIsPatchedArchive, (HANDLE hMpq), (hMpq))
+
+// Reading files
+STORM_SHIM(bool, SFileHasFile, (HANDLE hMpq, const char * szFileName, DWORD * pdwError), (hMpq, szFileName))
+STORM_SHIM(bool, SFileOpenFileEx, (HANDLE hMpq, const char * szFileName, DWORD dwSearchScope, HANDLE * phFile, DWORD * pdwError), (hMpq, szFileName, dwSearchScope, phFile))
+STORM_SHIM(DWORD, SFileGetFileSize, (HANDLE hFile, LPDWORD pdwFileSizeHigh, DWORD * pdwError), (hFile, pdwFileSizeHigh))
+STORM_SHIM(DWORD, SFileSetFilePointer, (HANDLE hFile, LONG lFilePos, LONG * plFilePosHigh, DWORD dwMoveMethod, DWORD * pdwError), (hFile, lFilePos, plFilePosHigh, dwMoveMethod))
+STORM_SHIM(bool, SFileReadFile, (HANDLE hFile, void * lpBuffer, DWORD dwToRead, LPDWORD pdwRead, DWORD * pdwError), (hFile, lpBuffer, dwToRead, pdwRead, NULL))
+STORM_SHIM(bool, SFileCloseFile, (HANDLE hFile, DWORD * pdwError), (hFile))
+STORM_SHIM_LOCALE(bool, SFileOpenFileEx, (HANDLE hMpq, const char * szFileName, DWORD dwSearchScope, HANDLE * phFile, LCID lcLocale, DWORD * pdwError), (hMpq, szFileName, dwSearchScope, phFile))
+STORM_SHIM_NOERROR(int, SFileEnumLocales, (HANDLE hMpq, const char * szFileName, LCID * plcLocales, LPDWORD pdwMaxLocales, DWORD dwSearchScope), (hMpq, szFileName, plcLocales, pdwMaxLocales, dwSearchScope))
+
+// File information
+STORM_SHIM(bool, SFileGetFileInfo, (HANDLE hMpqOrFile, SFileInfoClass InfoClass, void * pvFileInfo, DWORD cbFileInfo, LPDWORD pcbLengthNeeded, DWORD * pdwError), (hMpqOrFile, InfoClass, pvFileInfo, cbFileInfo, pcbLengthNeeded))
+STORM_SHIM(bool, SFileGetFileName, (HANDLE hFile, char * szFileName, DWORD * pdwError), (hFile, szFileName))
+STORM_SHIM(bool, SFileExtractFile, (HANDLE hMpq, const char * szToExtract, const TCHAR * szExtracted, DWORD dwSearchScope, DWORD * pdwError), (hMpq, szToExtract, szExtracted, dwSearchScope))
+
+// Verification
+STORM_SHIM(DWORD, SFileVerifyFile, (HANDLE hMpq, const char * szFileName, DWORD dwFlags, DWORD * pdwError), (hMpq, szFileName, dwFlags))
+STORM_SHIM(bool, SFileGetFileChecksums, (HANDLE hMpq, const char * szFileName, LPDWORD pdwCrc32, char * pMD5, DWORD * pdwError), (hMpq, szFileName, pdwCrc32, pMD5))
+STORM_SHIM_NOERROR(int, SFileVerifyRawData, (HANDLE hMpq, DWORD dwWhatToVerify, const char * szFileName), (hMpq, dwWhatToVerify, szFileName))
+STORM_SHIM(bool, SFileSignArchive, (HANDLE hMpq, DWORD dwSignatureType, DWORD * pdwError), (hMpq, dwSignatureType))
+STORM_SHIM_NOERROR(DWORD, SFileVerifyArchive, (HANDLE hMpq), (hMpq))
+
+// File searching
+STORM_SHIM(HANDLE, SFileFindFirstFile, (HANDLE hMpq, const char * szMask, SFILE_FIND_DATA * lpFindFileData, const TCHAR * szListFile, DWORD * pdwError), (hMpq, szMask, lpFindFileData, szListFile))
+STORM_SHIM(bool, SFileFindNextFile, (HANDLE hFind, SFILE_FIND_DATA * lpFindFileData, DWORD * pdwError), (hFind, lpFindFileData))
+STORM_SHIM(bool, SFileFindClose, (HANDLE hFind, DWORD * pdwError), (hFind))
+STORM_SHIM(HANDLE, SListFileFindFirstFile, (HANDLE hMpq, const TCHAR * szListFile, const char * szMask, SFILE_FIND_DATA * lpFindFileData, DWORD * pdwError), (hMpq, szListFile, szMask, lpFindFileData))
+STORM_SHIM(bool, SListFileFindNextFile, (HANDLE hFind, SFILE_FIND_DATA * lpFindFileData, DWORD * pdwError), (hFind, lpFindFileData))
+STORM_SHIM(bool, SListFileFindClose, (HANDLE hFind, DWORD * pdwError), (hFind))
+
+// Writing files
+STORM_SHIM(bool, SFileCreateFile, (HANDLE hMpq, const char * szArchivedName, ULONGLONG FileTime, DWORD dwFileSize, LCID lcLocale, DWORD dwFlags, HANDLE * phFile, DWORD * pdwError), (hMpq, szArchivedName, FileTime, dwFileSize, lcLocale, dwFlags, phFile))
+STORM_SHIM(bool, SFileWriteFile, (HANDLE hFile, const void * pvData, DWORD dwSize, DWORD dwCompression, DWORD * pdwError), (hFile, pvData, dwSize, dwCompression))
+STORM_SHIM(bool, SFileFinishFile, (HANDLE hFile, DWORD * pdwError), (hFile))
+
+// Adding files
+STORM_SHIM(bool, SFileAddFileEx, (HANDLE hMpq, const TCHAR * szFileName, const char * szArchivedName, DWORD dwFlags, DWORD dwCompression, DWORD dwCompressionNext, DWORD * pdwError), (hMpq, szFileName, szArchivedName, dwFlags, dwCompression, dwCompressionNext))
+STORM_SHIM(bool, SFileAddFile, (HANDLE hMpq, const TCHAR * szFileName, const char * szArchivedName, DWORD dwFlags, DWORD * pdwError), (hMpq, szFileName, szArchivedName, dwFlags))
+STORM_SHIM(bool, SFileAddWave, (HANDLE hMpq, const TCHAR * szFileName, const char * szArchivedName, DWORD dwFlags, DWORD dwQuality, DWORD * pdwError), (hMpq, szFileName, szArchivedName, dwFlags, dwQuality))
+STORM_SHIM(bool, SFileRemoveFile, (HANDLE hMpq, const char * szFileName, DWORD dwSearchScope, DWORD * pdwError), (hMpq, szFileName, dwSearchScope))
+STORM_SHIM(bool, SFileRenameFile, (HANDLE hMpq, const char * szOldFileName, const char * szNewFileName, DWORD * pdwError), (hMpq, szOldFileName, szNewFileName))
+STORM_SHIM(bool, SFileSetFileLocale, (HANDLE hFile, LCID lcNewLocale, DWORD * pdwError), (hFile, lcNewLocale))
+STORM_SHIM_LOCALE(bool, SFileAddFileEx, (HANDLE hMpq, const TCHAR * szFileName, const char * szArchivedName, DWORD dwFlags, DWORD dwCompression, DWORD dwCompressionNext, LCID lcLocale, DWORD * pdwError), (hMpq, szFileName, szArchivedName, dwFlags, dwCompression, dwCompressionNext))
+STORM_SHIM_LOCALE(bool, SFileAddWave, (HANDLE hMpq, const TCHAR * szFileName, const char * szArchivedName, DWORD dwFlags, DWORD dwQuality, LCID lcLocale, DWORD * pdwError), (hMpq, szFileName, szArchivedName, dwFlags, dwQuality))
+
+// Compression, used by the comp package
+STORM_SHIM(int, SCompImplode, (void * pvOutBuffer, int * pcbOutBuffer, void * pvInBuffer, int cbInBuffer, DWORD * pdwError), (pvOutBuffer, pcbOutBuffer, pvInBuffer, cbInBuffer))
+STORM_SHIM(int, SCompExplode, (void * pvOutBuffer, int * pcbOutBuffer, void * pvInBuffer, int cbInBuffer, DWORD * pdwError), (pvOutBuffer, pcbOutBuffer, pvInBuffer, cbInBuffer))
+STORM_SHIM(int, SCompCompress, (void * pvOutBuffer, int * pcbOutBuffer, void * pvInBuffer, int cbInBuffer, unsigned uCompressionMask, int nCmpType, int nCmpLevel, DWORD * pdwError), (pvOutBuffer, pcbOutBuffer, pvInBuffer, cbInBuffer, uCompressionMask, nCmpType, nCmpLevel))
+STORM_SHIM(int, SCompDecompress, (void * pvOutBuffer, int * pcbOutBuffer, void * pvInBuffer, int cbInBuffer, DWORD * pdwError), (pvOutBuffer, pcbOutBuffer, pvInBuffer, cbInBuffer))
+STORM_SHIM(int, SCompDecompress2, (void * pvOutBuffer, int * pcbOutBuffer, void * pvInBuffer, int cbInBuffer, DWORD * pdwError), (pvOutBuffer, pcbOutBuffer, pvInBuffer, cbInBuffer))
+
+#endif // STORM_LASTERROR_H
@@ -0,0 +1,144 @@
+//go:build !purego
+
+package storm
+
+// #include "lasterror.h"
+import "C"
+
+import (
+	"errors"
+	"runtime"
+	"unsafe"
+)
+
+type ListFileFinder struct {
+	handle  C.HANDLE
+	archive *Archive // Archive being searched, kept alive while the search is open
+	name    string   // Name of the list file, used in errors
+}
+
+// Finds a first name in a list file matching the mask. An empty list file name searches the list file of the archive.
+//
+// The names are not checked against the archive; use SFileHasFile for that.
+func (a *Archive) SListFileFindFirstFile(listFile string, mask string) (*ListFileFinder, *FileFindData, error) {
+	err := a.lock("find")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer a.unlock()
+
+	f := ListFileFinder{archive: a, name: listFile}
+	var findFileData FileFindData
+	var cListFile *C.char
+
+	cFindFileData := new(C.SFILE_FIND_DATA)
+
+	if listFile != "" {
+		cListFile = C.CString(listFile)
+		defer C.free(unsafe.Pointer(cListFile))
+	}
+
+	cMask := C.CString(mask)
+	defer C.free(unsafe.Pointer(cMask))
+
+	var errorCode C.DWORD
+	f.handle = C.shimSListFileFindFirstFile(a.handle, cListFile, cMask, cFindFileData, &errorCode)
+	if f.handle != nil {
+		goFindFileData(cFindFileData, &findFileData)
+		a.addChild(f.handle, releaseListFileFinder)
+		runtime.SetFinalizer(&f, (*ListFileFinder).finalize)
+		return &f, &findFileData, nil
+	}
+
+	return nil, nil, newPathError(uint32(errorCode), "find", listFile)
+}
+
+// Finds a next name in the list file matching the mask.
+func (f *ListFileFinder) SListFileFindNextFile() (*FileFindData, error) {
+	err := f.archive.lockHandle(&f.handle, "find", f.name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.archive.unlock()
+
+	var findFileData FileFindData
+
+	cFindFileData := new(C.SFILE_FIND_DATA)
+
+	var errorCode C.DWORD
+	if C.shimSListFileFindNextFile(f.handle, cFindFileData, &errorCode) != 0 {
+		goFindFileData(cFindFileData, &findFileData)
+		return &findFileData, nil
+	}
+
+	return nil, newPathError(uint32(errorCode), "find", f.name)
+}
+
+// Stops searching in the list file. The handle is released even if the close fails.
+func (f *ListFileFinder) SListFileFindClose() error {
+	err := f.archive.lockHandle(&f.handle, "close", f.name)
+	if err != nil {
+		return err
+	}
+	defer f.archive.unlock()
+
+	var errorCode C.DWORD
+	result := C.shimSListFileFindClose(f.handle, &errorCode)
+
+	f.archive.removeChild(f.handle)
+	f.handle = nil
+	runtime.SetFinalizer(f, nil)
+
+	if result != 0 {
+		return nil
+	}
+
+	return newPathError(uint32(errorCode), "close", f.name)
+}
+
+// Implementation of the io.Closer interface.
+func (f *ListFileFinder) Close() error {
+	return f.SListFileFindClose()
+}
+
+// Closes the search if it was not closed before it became unreachable.
+func (f *ListFileFinder) finalize() {
+	if f.handle != nil {
+		reportLeak("list file finder", f.name)
+		f.SListFileFindClose()
+	}
+}
+
+// Closes a list file search handle left open when its archive is closed.
+func releaseListFileFinder(handle C.HANDLE) {
+	var errorCode C.DWORD
+	C.shimSListFileFindClose(handle, &errorCode)
+}
+
+// Calls fn for each name in a list file on the local drive which matches the mask and exists in the archive.
+func (a *Archive) resolveListFile(listFile string, mask string, fn func(fileName string) error) error {
+	finder, findFileData, err := a.SListFileFindFirstFile(listFile, mask)
+	if err != nil {
+		if errors.Is(err, ErrNoMoreFiles) {
+			return nil
+		}
+		return err
+	}
+	defer finder.SListFileFindClose()
+
+	seen := make(map[string]bool)
+	for {
+		err = a.resolveName(findFileData.FileName, seen, fn)
+		if err != nil {
+			return err
+		}
+
+		findFileData, err = finder.SListFileFindNextFile()
+		if err != nil {
+			if errors.Is(err, ErrNoMoreFiles) {
+				return nil
+			}
+			return err
+		}
+	}
+}
@@ -0,0 +1,43 @@
+//go:build go1.23
+
+package storm
+
+import (
+	"errors"
+	"io"
+	"iter"
+)
+
+// Returned by the callback of a resolver when the iteration over its names stops.
+var errStopResolving = errors.New("storm: stop resolving")
+
+// Returns an iterator over the names found by ResolveListFileFunc.
+func (a *Archive) ResolveListFile(listFile string) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		yieldNames(yield, func(fn func(fileName string) error) error {
+			return a.ResolveListFileFunc(listFile, fn)
+		})
+	}
+}
+
+// Returns an iterator over the names found by ResolveListFileReaderFunc.
+func (a *Archive) ResolveListFileReader(r io.Reader) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		yieldNames(yield, func(fn func(fileName string) error) error {
+			return a.ResolveListFileReaderFunc(r, fn)
+		})
+	}
+}
+
+// Passes the names found by resolve to yield, followed by the error of resolve if any.
+func yieldNames(yield func(string, error) bool, resolve func(fn func(fileName string) error) error) {
+	err := resolve(func(fileName string) error {
+		if !yield(fileName, nil) {
+			return errStopResolving
+		}
+		return nil
+	})
+	if err != nil && err != errStopResolving {
+		yield("", err)
+	}
+}
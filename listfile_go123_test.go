@@ -0,0 +1,68 @@
+//go:build go1.23 && !purego
+
+package storm_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	storm "github.com/slyh/go-stormlib"
+)
+
+func TestResolveListFile(t *testing.T) {
+	dir := t.TempDir()
+
+	archive, err := storm.SFileCreateArchive(filepath.Join(dir, "test.mpq"), 0, 16)
+	if err != nil {
+		t.Errorf("SFileCreateArchive: %v", err)
+		return
+	}
+	defer archive.SFileCloseArchive()
+
+	for _, name := range []string{"test1.txt", "test2.txt", "dir\\test3.txt"} {
+		err = archive.CreateFromReader(name, strings.NewReader(name), uint32(len(name)), storm.WriteOptions{})
+		if err != nil {
+			t.Errorf("Archive.CreateFromReader: %v", err)
+			return
+		}
+	}
+
+	listFilePath := filepath.Join(dir, "listfile.txt")
+	err = os.WriteFile(listFilePath, []byte("test1.txt\r\nmissing.txt\r\ndir/test3.txt\r\nTEST1.TXT\r\ndir\\TEST3.txt\r\n"), 0644)
+	if err != nil {
+		t.Errorf("os.WriteFile: %v", err)
+		return
+	}
+
+	var resolved []string
+	for fileName, err := range archive.ResolveListFile(listFilePath) {
+		if err != nil {
+			t.Errorf("ResolveListFile: %v", err)
+			return
+		}
+		resolved = append(resolved, fileName)
+	}
+	if strings.Join(resolved, ",") != "test1.txt,dir/test3.txt" {
+		t.Errorf("ResolveListFile: unexpected names %v", resolved)
+		return
+	}
+
+	resolved = nil
+	for fileName, err := range archive.ResolveListFileReader(strings.NewReader("missing.txt;test2.txt\ntest1.txt;Test2.txt")) {
+		if err != nil {
+			t.Errorf("ResolveListFileReader: %v", err)
+			return
+		}
+		resolved = append(resolved, fileName)
+	}
+	if strings.Join(resolved, ",") != "test2.txt,test1.txt" {
+		t.Errorf("ResolveListFileReader: unexpected names %v", resolved)
+		return
+	}
+
+	for range archive.ResolveListFile(listFilePath) {
+		break
+	}
+}
@@ -0,0 +1,47 @@
+//go:build purego
+
+package storm
+
+import (
+	"bytes"
+	"io"
+	"os"
+
+	"github.com/slyh/go-stormlib/internal/mpq"
+)
+
+// Calls fn for each name in a list file on the local drive which matches the mask and exists in the archive. An empty
+// list file name reads the list file of the archive.
+func (a *Archive) resolveListFile(listFile string, mask string, fn func(fileName string) error) error {
+	var data []byte
+	var err error
+	if listFile == "" {
+		data, err = a.readListFile()
+	} else {
+		data, err = os.ReadFile(listFile)
+		if err != nil {
+			err = mpqError(err, "find", listFile)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	return a.resolveListFileReader(bytes.NewReader(data), func(fileName string) error {
+		if !mpq.MatchMask(fileName, mask) {
+			return nil
+		}
+		return fn(fileName)
+	})
+}
+
+// Reads the list file of the archive.
+func (a *Archive) readListFile() ([]byte, error) {
+	f, err := a.SFileOpenFileEx("(listfile)", SFILE_OPEN_FROM_MPQ)
+	if err != nil {
+		return nil, err
+	}
+	defer f.SFileCloseFile()
+
+	return io.ReadAll(f)
+}
@@ -0,0 +1,156 @@
+//go:build !purego
+
+package storm
+
+// #include "lasterror.h"
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"unsafe"
+)
+
+// Locale ID (LCID) of a file within the archive.
+type Locale uint32
+
+// Locales used by Blizzard games
+const (
+	LocaleNeutral Locale = 0x000 // Neutral locale, used when no file for the requested locale exists
+	LocaleEnUS    Locale = 0x409 // English (United States)
+	LocaleKoKR    Locale = 0x412 // Korean
+	LocaleFrFR    Locale = 0x40c // French
+	LocaleDeDE    Locale = 0x407 // German
+	LocaleZhCN    Locale = 0x804 // Chinese (Simplified)
+	LocaleEsES    Locale = 0x40a // Spanish (Spain)
+	LocaleZhTW    Locale = 0x404 // Chinese (Traditional)
+	LocaleEnGB    Locale = 0x809 // English (United Kingdom)
+	LocaleRuRU    Locale = 0x419 // Russian
+	LocaleEsMX    Locale = 0x80a // Spanish (Mexico)
+	LocalePtBR    Locale = 0x416 // Portuguese (Brazil)
+	LocaleItIT    Locale = 0x410 // Italian
+	LocalePlPL    Locale = 0x415 // Polish
+	LocaleCsCZ    Locale = 0x405 // Czech
+	LocalePtPT    Locale = 0x816 // Portuguese (Portugal)
+	LocaleJaJP    Locale = 0x411 // Japanese
+)
+
+var localeNames = map[Locale]string{
+	LocaleNeutral: "neutral",
+	LocaleEnUS:    "enUS",
+	LocaleKoKR:    "koKR",
+	LocaleFrFR:    "frFR",
+	LocaleDeDE:    "deDE",
+	LocaleZhCN:    "zhCN",
+	LocaleEsES:    "esES",
+	LocaleZhTW:    "zhTW",
+	LocaleEnGB:    "enGB",
+	LocaleRuRU:    "ruRU",
+	LocaleEsMX:    "esMX",
+	LocalePtBR:    "ptBR",
+	LocaleItIT:    "itIT",
+	LocalePlPL:    "plPL",
+	LocaleCsCZ:    "csCZ",
+	LocalePtPT:    "ptPT",
+	LocaleJaJP:    "jaJP",
+}
+
+// Returns the Blizzard name of the locale, such as "enUS", or its LCID if the locale is not known.
+func (l Locale) String() string {
+	if name, ok := localeNames[l]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("Locale(%#x)", uint32(l))
+}
+
+// Lists the locales under which a file is stored in the archive.
+func (a *Archive) Locales(fileName string) ([]Locale, error) {
+	err := a.lock("enumerate locales")
+	if err != nil {
+		return nil, err
+	}
+	defer a.unlock()
+
+	cFileName := C.CString(fileName)
+	defer C.free(unsafe.Pointer(cFileName))
+
+	locales := make([]C.LCID, 8)
+	for {
+		maxLocales := C.DWORD(len(locales))
+
+		result := uint32(C.shimSFileEnumLocales(a.handle, cFileName, &locales[0], &maxLocales, C.SFILE_OPEN_FROM_MPQ))
+		if result == ERROR_SUCCESS && maxLocales == 0 {
+			return nil, newPathError(ERROR_FILE_NOT_FOUND, "enumerate locales", fileName)
+		}
+
+		if result == ERROR_SUCCESS {
+			list := make([]Locale, maxLocales)
+			for i := range list {
+				list[i] = Locale(locales[i])
+			}
+			return list, nil
+		}
+
+		if result != ERROR_INSUFFICIENT_BUFFER || int(maxLocales) <= len(locales) {
+			return nil, newPathError(result, "enumerate locales", fileName)
+		}
+
+		locales = make([]C.LCID, maxLocales)
+	}
+}
+
+// Checks if the file exists in the archive under the given locale.
+func (a *Archive) HasFileLocale(fileName string, locale Locale) (bool, error) {
+	locales, err := a.Locales(fileName)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	for _, l := range locales {
+		if l == locale {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Opens the version of a file stored under the given locale.
+//
+// Unlike SFileOpenFileEx, the global locale is left unchanged for other goroutines and there is no fallback to the neutral locale.
+func (a *Archive) OpenLocale(fileName string, locale Locale) (*FileReader, error) {
+	err := a.lock("open")
+	if err != nil {
+		return nil, err
+	}
+	defer a.unlock()
+
+	f := FileReader{archive: a, name: fileName}
+
+	cFileName := C.CString(fileName)
+	defer C.free(unsafe.Pointer(cFileName))
+
+	var errorCode C.DWORD
+	if C.shimSFileOpenFileExLocale(a.handle, cFileName, C.SFILE_OPEN_FROM_MPQ, &f.handle, C.LCID(locale), &errorCode) == 0 {
+		return nil, newPathError(uint32(errorCode), "open", fileName)
+	}
+
+	q := infoQuery{handle: f.handle, name: f.name}
+	fileLocale := q.uint32(SFileInfoLocale, false)
+	if q.err != nil || Locale(fileLocale) != locale {
+		C.shimSFileCloseFile(f.handle, &errorCode)
+		if q.err != nil {
+			return nil, q.err
+		}
+		return nil, newPathError(ERROR_FILE_NOT_FOUND, "open", fileName)
+	}
+
+	a.addChild(f.handle, releaseFileReader)
+	runtime.SetFinalizer(&f, (*FileReader).finalize)
+	return &f, nil
+}
@@ -0,0 +1,121 @@
+package storm
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// A fixed set of read-only handles to the same MPQ archive, for serving reads from multiple goroutines.
+//
+// Each goroutine borrows its own Archive, so reads through different handles do not wait for each other on the archive
+// lock and never share a file pointer. The calls into StormLib themselves still run one at a time across the process, as
+// StormLib keeps the last error in a process-wide variable; only the work between the calls overlaps. The pure Go reader
+// of purego builds has no such lock.
+type Pool struct {
+	name     string
+	archives chan *Archive // Archives available for borrowing
+	all      []*Archive    // All archives of the pool, closed by Close
+	mutex    sync.Mutex    // Guards closed
+	closed   bool
+}
+
+// Opens a pool of size read-only handles to an archive. The flags are passed to SFileOpenArchive with STREAM_FLAG_READ_ONLY added.
+func OpenPool(mpqName string, size int, flags uint32) (*Pool, error) {
+	if size <= 0 {
+		return nil, newStormError(ERROR_INVALID_PARAMETER, "open pool", mpqName, "pool size must be positive")
+	}
+
+	p := Pool{
+		name:     mpqName,
+		archives: make(chan *Archive, size),
+	}
+
+	for i := 0; i < size; i++ {
+		a, err := SFileOpenArchive(mpqName, flags|STREAM_FLAG_READ_ONLY)
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		p.all = append(p.all, a)
+		p.archives <- a
+	}
+
+	return &p, nil
+}
+
+// Borrows an archive from the pool, waiting until one is available or the context is done. The archive must be returned with Put.
+func (p *Pool) Get(ctx context.Context) (*Archive, error) {
+	p.mutex.Lock()
+	closed := p.closed
+	p.mutex.Unlock()
+	if closed {
+		return nil, closedError("get", p.name)
+	}
+
+	select {
+	case a := <-p.archives:
+		return a, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Returns an archive borrowed with Get to the pool.
+func (p *Pool) Put(a *Archive) {
+	p.archives <- a
+}
+
+// Calls fn with an archive borrowed from the pool. Files opened by fn must be closed before it returns.
+func (p *Pool) Do(ctx context.Context, fn func(a *Archive) error) error {
+	a, err := p.Get(ctx)
+	if err != nil {
+		return err
+	}
+	defer p.Put(a)
+
+	return fn(a)
+}
+
+// Reads a whole file using an archive borrowed from the pool.
+func (p *Pool) ReadFile(ctx context.Context, fileName string) ([]byte, error) {
+	var data []byte
+
+	err := p.Do(ctx, func(a *Archive) error {
+		reader, err := a.SFileOpenFileEx(fileName, SFILE_OPEN_FROM_MPQ)
+		if err != nil {
+			return err
+		}
+		defer reader.SFileCloseFile()
+
+		data, err = io.ReadAll(reader)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// Closes all archives of the pool, including borrowed ones, returning the first error. Later calls on borrowed archives
+// fail with fs.ErrClosed.
+func (p *Pool) Close() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.closed {
+		return closedError("close", p.name)
+	}
+	p.closed = true
+
+	var err error
+	for _, a := range p.all {
+		closeErr := a.SFileCloseArchive()
+		if err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
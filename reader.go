@@ -0,0 +1,100 @@
+package storm
+
+import (
+	"errors"
+	"io"
+)
+
+// Implementation of the io.Reader interface.
+func (f *FileReader) Read(buffer []byte) (int, error) {
+	n, err := f.SFileReadFile(buffer)
+	if errors.Is(err, io.EOF) {
+		err = io.EOF
+	}
+	return int(n), err
+}
+
+// Implementation of the io.Seeker interface.
+//
+// Seeking past the end of the file moves the file pointer to the end.
+func (f *FileReader) Seek(offset int64, whence int) (int64, error) {
+	var moveMethod uint32
+
+	switch whence {
+	case io.SeekStart:
+		moveMethod = FILE_BEGIN
+	case io.SeekCurrent:
+		moveMethod = FILE_CURRENT
+	case io.SeekEnd:
+		moveMethod = FILE_END
+	default:
+		return 0, errors.New("storm: invalid whence")
+	}
+
+	pos, err := f.SFileSetFilePointer(uint64(offset), moveMethod)
+	return int64(pos), err
+}
+
+// Implementation of the io.ReaderAt interface.
+//
+// It is safe to call ReadAt concurrently with other reads; the calls are serialized and the file pointer is left unchanged.
+func (f *FileReader) ReadAt(buffer []byte, offset int64) (n int, err error) {
+	if offset < 0 {
+		return 0, errors.New("storm: negative offset")
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	pos, err := f.setFilePointer(0, FILE_CURRENT)
+	if err != nil {
+		return 0, err
+	}
+	defer f.setFilePointer(pos, FILE_BEGIN)
+
+	_, err = f.setFilePointer(uint64(offset), FILE_BEGIN)
+	if err != nil {
+		return 0, err
+	}
+
+	for n < len(buffer) {
+		read, err := f.readFile(buffer[n:])
+		n += int(read)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.EOF
+			}
+			return n, err
+		}
+		if read == 0 {
+			return n, io.EOF
+		}
+	}
+
+	return n, nil
+}
+
+// Implementation of the io.WriterTo interface.
+func (f *FileReader) WriteTo(w io.Writer) (written int64, err error) {
+	buffer := make([]byte, 64*1024)
+
+	for {
+		n, readErr := f.Read(buffer)
+		if n > 0 {
+			m, writeErr := w.Write(buffer[:n])
+			written += int64(m)
+			if writeErr != nil {
+				return written, writeErr
+			}
+			if m < n {
+				return written, io.ErrShortWrite
+			}
+		}
+		if readErr == io.EOF {
+			return written, nil
+		}
+		if readErr != nil {
+			return written, readErr
+		}
+	}
+}
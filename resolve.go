@@ -0,0 +1,73 @@
+package storm
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+)
+
+// Calls fn for each name in a list file on the local drive which exists in the archive. An empty list file name reads
+// the list file of the archive. Names that differ only in case or path separator are passed once. Returning an error
+// from fn stops the search and returns the error.
+//
+// This is useful to rebuild the list file of an archive shipped without one.
+func (a *Archive) ResolveListFileFunc(listFile string, fn func(fileName string) error) error {
+	return a.resolveListFile(listFile, "*", fn)
+}
+
+// Works like ResolveListFileFunc, reading the list file from r. Names are separated by line breaks or semicolons.
+func (a *Archive) ResolveListFileReaderFunc(r io.Reader, fn func(fileName string) error) error {
+	return a.resolveListFileReader(r, fn)
+}
+
+// Calls fn for each name in a list file read from r which exists in the archive. Names are separated by line breaks or
+// semicolons.
+func (a *Archive) resolveListFileReader(r io.Reader, fn func(fileName string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Split(splitListFile)
+
+	seen := make(map[string]bool)
+	for scanner.Scan() {
+		err := a.resolveName(scanner.Text(), seen, fn)
+		if err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Calls fn for a name from a list file if it exists in the archive and was not seen before under any spelling.
+func (a *Archive) resolveName(fileName string, seen map[string]bool, fn func(fileName string) error) error {
+	key := listFileKey(fileName)
+	if fileName == "" || seen[key] {
+		return nil
+	}
+	seen[key] = true
+
+	exists, err := a.SFileHasFile(fileName)
+	if err != nil || !exists {
+		return err
+	}
+
+	return fn(fileName)
+}
+
+// Returns the key under which a name is looked up in the archive, which ignores case and the kind of path separator.
+func listFileKey(fileName string) string {
+	return strings.ToUpper(strings.ReplaceAll(fileName, "/", "\\"))
+}
+
+// Splits a list file into names, which are separated by CR, LF or semicolons.
+func splitListFile(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	i := bytes.IndexAny(data, "\r\n;")
+	if i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+
+	return 0, nil, nil
+}
@@ -0,0 +1,70 @@
+//go:build purego
+
+package storm_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	storm "github.com/slyh/go-stormlib"
+)
+
+// Compares the purego backend with the expected output of the archives in testdata, which is written by the cgo backend.
+func TestPureGo(t *testing.T) {
+	testGoldens(t, false)
+}
+
+func TestPureGoResolveListFile(t *testing.T) {
+	archive, err := storm.SFileOpenArchive(filepath.Join("testdata", "unlisted.mpq"), storm.STREAM_FLAG_READ_ONLY)
+	if err != nil {
+		t.Errorf("SFileOpenArchive: %v", err)
+		return
+	}
+	defer archive.SFileCloseArchive()
+
+	var resolved []string
+	err = archive.ResolveListFileReaderFunc(strings.NewReader("zlib.txt;missing.txt\nZLIB.TXT\r\ndir/fixkey.txt"), func(fileName string) error {
+		resolved = append(resolved, fileName)
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Archive.ResolveListFileReaderFunc: %v", err)
+		return
+	}
+	if strings.Join(resolved, ",") != "zlib.txt,dir/fixkey.txt" {
+		t.Errorf("Archive.ResolveListFileReaderFunc: unexpected names %v", resolved)
+		return
+	}
+
+	listFilePath := filepath.Join(t.TempDir(), "listfile.txt")
+	err = os.WriteFile(listFilePath, []byte("stored.txt\r\nmissing.txt\r\nsingle.txt\r\n"), 0o644)
+	if err != nil {
+		t.Errorf("WriteFile: %v", err)
+		return
+	}
+
+	resolved = nil
+	err = archive.ResolveListFileFunc(listFilePath, func(fileName string) error {
+		resolved = append(resolved, fileName)
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Archive.ResolveListFileFunc: %v", err)
+		return
+	}
+	if strings.Join(resolved, ",") != "stored.txt,single.txt" {
+		t.Errorf("Archive.ResolveListFileFunc: unexpected names %v", resolved)
+		return
+	}
+
+	err = archive.ResolveListFileFunc("", func(fileName string) error {
+		return nil
+	})
+	if !errors.Is(err, storm.ErrNotFound) {
+		t.Errorf("Archive.ResolveListFileFunc: expected ErrNotFound without a list file, got %v", err)
+		return
+	}
+}
@@ -1,12 +1,36 @@
+//go:build !purego
+
 package storm_test
 
 import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
 	"io/ioutil"
+	"math"
 	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	storm "github.com/slyh/go-stormlib"
+	"github.com/slyh/go-stormlib/comp"
+	"github.com/slyh/go-stormlib/comp/adpcm"
+	"github.com/slyh/go-stormlib/comp/huffman"
+	"github.com/slyh/go-stormlib/comp/pkware"
+	"github.com/slyh/go-stormlib/internal/mpq"
 )
 
 var mpqFilePath = "./test.mpq"
@@ -81,6 +105,234 @@ func TestStorm(t *testing.T) {
 			return
 		}
 
+		writer, err = archive.SFileCreateFile("dir\\test3.txt", 0, 10, 0, storm.MPQ_FILE_COMPRESS)
+		if err != nil {
+			t.Errorf("SFileCreateFile: %v", err)
+			return
+		}
+
+		err = writer.SFileWriteFile([]uint8(fmt.Sprintf("%-10s", "Test3")), 0)
+		if err != nil {
+			t.Errorf("SFileWriteFile: %v", err)
+			return
+		}
+
+		err = writer.SFileFinishFile()
+		if err != nil {
+			t.Errorf("SFileFinishFile: %v", err)
+			return
+		}
+
+		err = archive.SFileCloseArchive()
+		if err != nil {
+			t.Errorf("SFileCloseArchive: %v", err)
+			return
+		}
+	})
+
+	t.Run("CreateArchive2", func(t *testing.T) {
+		dir := t.TempDir()
+
+		_, err := storm.SFileCreateArchive2(filepath.Join(dir, "invalid.mpq"), storm.CreateOptions{SectorSize: 1000, MaxFileCount: 16})
+		if err == nil || err.(*storm.StormError).Code != storm.ERROR_INVALID_PARAMETER {
+			t.Errorf("SFileCreateArchive2: expected ERROR_INVALID_PARAMETER for sector size 1000, got %v", err)
+		}
+
+		_, err = storm.SFileCreateArchive2(filepath.Join(dir, "invalid.mpq"), storm.CreateOptions{MpqVersion: storm.MPQ_FORMAT_VERSION_2, RawChunkSize: 0x4000, MaxFileCount: 16})
+		if err == nil || err.(*storm.StormError).Code != storm.ERROR_INVALID_PARAMETER {
+			t.Errorf("SFileCreateArchive2: expected ERROR_INVALID_PARAMETER for raw chunk size on version 2, got %v", err)
+		}
+
+		archive, err := storm.SFileCreateArchive2(filepath.Join(dir, "test.mpq"), storm.CreateOptions{
+			MpqVersion:   storm.MPQ_FORMAT_VERSION_4,
+			FileFlags1:   storm.MPQ_FILE_DEFAULT_INTERNAL,
+			FileFlags2:   storm.MPQ_FILE_DEFAULT_INTERNAL,
+			AttrFlags:    storm.MPQ_ATTRIBUTE_CRC32,
+			SectorSize:   0x8000,
+			MaxFileCount: 16,
+		})
+		if err != nil {
+			t.Errorf("SFileCreateArchive2: %v", err)
+			return
+		}
+
+		archiveInfo, err := archive.Info()
+		if err != nil {
+			t.Errorf("Archive.Info: %v", err)
+			return
+		}
+		if archiveInfo.SectorSize != 0x8000 {
+			t.Errorf("Archive.Info: wrong sector size (expected: %d, actual: %d)", 0x8000, archiveInfo.SectorSize)
+		}
+		if archiveInfo.RawChunkSize != 0x4000 {
+			t.Errorf("Archive.Info: wrong raw chunk size (expected: %d, actual: %d)", 0x4000, archiveInfo.RawChunkSize)
+		}
+
+		err = archive.SFileCloseArchive()
+		if err != nil {
+			t.Errorf("SFileCloseArchive: %v", err)
+			return
+		}
+	})
+
+	t.Run("CreateFromReader", func(t *testing.T) {
+		streamFilePath := "./test_stream.mpq"
+		defer os.Remove(streamFilePath)
+
+		archive, err := storm.SFileCreateArchive(streamFilePath, 0, 16)
+		if err != nil {
+			t.Errorf("SFileCreateArchive: %v", err)
+			return
+		}
+
+		data := strings.Repeat("0123456789", 10000)
+		err = archive.CreateFromReader("stream.txt", strings.NewReader(data), uint32(len(data)), storm.WriteOptions{Flags: storm.MPQ_FILE_COMPRESS})
+		if err != nil {
+			t.Errorf("Archive.CreateFromReader: %v", err)
+			return
+		}
+
+		err = archive.CreateFromReader("long.txt", strings.NewReader(data), 10, storm.WriteOptions{})
+		if !errors.Is(err, storm.ErrFileSizeExceeded) {
+			t.Errorf("Archive.CreateFromReader: expected ErrFileSizeExceeded, got %v", err)
+		}
+
+		err = archive.CreateFromReader("short.txt", strings.NewReader("short"), 10, storm.WriteOptions{})
+		if !errors.Is(err, storm.ErrFileIncomplete) {
+			t.Errorf("Archive.CreateFromReader: expected ErrFileIncomplete, got %v", err)
+		}
+
+		// The error of finishing the file is kept along with the missing data
+		writer, err := archive.SFileCreateFile("short2.txt", 0, 10, 0, 0)
+		if err != nil {
+			t.Errorf("SFileCreateFile: %v", err)
+			return
+		}
+		_, err = writer.Write([]byte("short"))
+		if err != nil {
+			t.Errorf("FileWriter.Write: %v", err)
+			return
+		}
+		err = writer.Close()
+		if !errors.Is(err, storm.ErrFileIncomplete) || !strings.Contains(err.Error(), "storm: finish short2.txt") {
+			t.Errorf("FileWriter.Close: expected ErrFileIncomplete with the error of SFileFinishFile, got %v", err)
+		}
+
+		reader, err := archive.SFileOpenFileEx("stream.txt", storm.SFILE_OPEN_FROM_MPQ)
+		if err != nil {
+			t.Errorf("SFileOpenFileEx: %v", err)
+			return
+		}
+
+		raw, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Errorf("ioutil.ReadAll: %v", err)
+			return
+		}
+		if string(raw) != data {
+			t.Errorf("ioutil.ReadAll: wrong readout (length: %d)", len(raw))
+		}
+
+		err = reader.SFileCloseFile()
+		if err != nil {
+			t.Errorf("SFileCloseFile: %v", err)
+			return
+		}
+
+		err = archive.SFileCloseArchive()
+		if err != nil {
+			t.Errorf("SFileCloseArchive: %v", err)
+			return
+		}
+	})
+
+	t.Run("AddLocalFile", func(t *testing.T) {
+		dir := t.TempDir()
+		localFilePath := filepath.Join(dir, "local.txt")
+
+		err := os.WriteFile(localFilePath, []byte(strings.Repeat("Test", 1000)), 0644)
+		if err != nil {
+			t.Errorf("os.WriteFile: %v", err)
+			return
+		}
+
+		archive, err := storm.SFileCreateArchive(filepath.Join(dir, "test.mpq"), 0, 16)
+		if err != nil {
+			t.Errorf("SFileCreateArchive: %v", err)
+			return
+		}
+
+		err = archive.AddFile(localFilePath, "local.txt", storm.AddOptions{Flags: storm.MPQ_FILE_COMPRESS, Compression: storm.MPQ_COMPRESSION_ZLIB, Locale: storm.LocaleDeDE})
+		if err != nil {
+			t.Errorf("Archive.AddFile: %v", err)
+			return
+		}
+
+		finder, findFileData, err := archive.SFileFindFirstFile("local.txt", "")
+		if err != nil {
+			t.Errorf("SFileFindFirstFile: %v", err)
+			return
+		}
+		if findFileData.Locale != 0x407 {
+			t.Errorf("Archive.AddFile: wrong locale (expected: %#x, actual: %#x)", 0x407, findFileData.Locale)
+		}
+		if findFileData.FileSize != 4000 || findFileData.CompSize >= findFileData.FileSize {
+			t.Errorf("Archive.AddFile: file not compressed (size: %d, compressed size: %d)", findFileData.FileSize, findFileData.CompSize)
+		}
+
+		err = finder.SFileFindClose()
+		if err != nil {
+			t.Errorf("SFileFindClose: %v", err)
+			return
+		}
+
+		if storm.SFileGetLocale() != 0 {
+			t.Errorf("Archive.AddFile: global locale changed to %#x", storm.SFileGetLocale())
+		}
+
+		err = os.WriteFile(localFilePath, []byte(strings.Repeat("Test", 3000)), 0644)
+		if err != nil {
+			t.Errorf("os.WriteFile: %v", err)
+			return
+		}
+
+		compressedSizes := make(map[string]uint32)
+		noCompression := uint32(0)
+		for name, compressionNext := range map[string]*uint32{"same.txt": nil, "first.txt": &noCompression} {
+			err = archive.AddFile(localFilePath, name, storm.AddOptions{Flags: storm.MPQ_FILE_COMPRESS, Compression: storm.MPQ_COMPRESSION_ZLIB, CompressionNext: compressionNext})
+			if err != nil {
+				t.Errorf("Archive.AddFile: %v", err)
+				return
+			}
+
+			reader, err := archive.SFileOpenFileEx(name, storm.SFILE_OPEN_FROM_MPQ)
+			if err != nil {
+				t.Errorf("SFileOpenFileEx: %v", err)
+				return
+			}
+
+			data, err := io.ReadAll(reader)
+			if err != nil || string(data) != strings.Repeat("Test", 3000) {
+				t.Errorf("Archive.AddFile: wrong readout of %s (err: %v)", name, err)
+			}
+
+			fileInfo, err := reader.Info()
+			if err != nil {
+				t.Errorf("FileReader.Info: %v", err)
+				return
+			}
+			compressedSizes[name] = fileInfo.CompressedSize
+
+			err = reader.SFileCloseFile()
+			if err != nil {
+				t.Errorf("SFileCloseFile: %v", err)
+				return
+			}
+		}
+		if compressedSizes["first.txt"] <= compressedSizes["same.txt"] {
+			t.Errorf("Archive.AddFile: following sectors compressed without CompressionNext (sizes: %v)", compressedSizes)
+		}
+
 		err = archive.SFileCloseArchive()
 		if err != nil {
 			t.Errorf("SFileCloseArchive: %v", err)
@@ -139,6 +391,41 @@ func TestStorm(t *testing.T) {
 		}
 	})
 
+	t.Run("Files", func(t *testing.T) {
+		archive, err := storm.SFileOpenArchive(mpqFilePath, storm.STREAM_FLAG_READ_ONLY)
+		if err != nil {
+			t.Errorf("SFileOpenArchive: %v", err)
+			return
+		}
+		defer archive.SFileCloseArchive()
+
+		files, err := archive.ListFiles("test*.txt")
+		if err != nil {
+			t.Errorf("ListFiles: %v", err)
+			return
+		}
+		if len(files) != 2 {
+			t.Errorf("ListFiles: expected 2 files, got %d", len(files))
+			return
+		}
+
+		files, err = archive.ListFiles("*.txt", strings.NewReader("test1.txt\r\ntest2.txt\r\n"))
+		if err != nil {
+			t.Errorf("ListFiles: %v", err)
+			return
+		}
+		if len(files) != 3 {
+			t.Errorf("ListFiles: expected 3 files, got %d", len(files))
+			return
+		}
+
+		files, err = archive.ListFiles("nothing*")
+		if err != nil || len(files) != 0 {
+			t.Errorf("ListFiles: expected no files, got %v, %v", files, err)
+			return
+		}
+	})
+
 	t.Run("ReadFile", func(t *testing.T) {
 		archive, err := storm.SFileOpenArchive(mpqFilePath, storm.STREAM_FLAG_READ_ONLY)
 		if err != nil {
@@ -240,28 +527,76 @@ func TestStorm(t *testing.T) {
 		}
 	})
 
-	t.Run("CompactArchive", func(t *testing.T) {
-		archive, err := storm.SFileOpenArchive(mpqFilePath, 0)
+	t.Run("SeekReadAt", func(t *testing.T) {
+		archive, err := storm.SFileOpenArchive(mpqFilePath, storm.STREAM_FLAG_READ_ONLY)
 		if err != nil {
 			t.Errorf("SFileOpenArchive: %v", err)
 			return
 		}
 
-		writer, err := archive.SFileCreateFile("test2.txt", 0, 10, 0, storm.MPQ_FILE_COMPRESS|storm.MPQ_FILE_REPLACEEXISTING)
+		reader, err := archive.SFileOpenFileEx("test1.txt", storm.SFILE_OPEN_FROM_MPQ)
 		if err != nil {
-			t.Errorf("SFileCreateFile: %v", err)
+			t.Errorf("SFileOpenFileEx: %v", err)
 			return
 		}
 
-		err = writer.SFileWriteFile([]uint8(fmt.Sprintf("%-10s", "Test")), 0)
+		pos, err := reader.Seek(-3, io.SeekEnd)
 		if err != nil {
-			t.Errorf("SFileWriteFile: %v", err)
+			t.Errorf("FileReader.Seek: %v", err)
 			return
 		}
+		if pos != 7 {
+			t.Errorf("FileReader.Seek: wrong pointer position (expected: %d, actual: %d)", 7, pos)
+		}
 
-		err = writer.SFileFinishFile()
+		buffer := make([]byte, 4)
+		n, err := reader.ReadAt(buffer, 1)
 		if err != nil {
-			t.Errorf("SFileFinishFile: %v", err)
+			t.Errorf("FileReader.ReadAt: %v", err)
+			return
+		}
+		if string(buffer[:n]) != "est " {
+			t.Errorf("FileReader.ReadAt: wrong readout (data: %v)", buffer[:n])
+		}
+
+		n, err = reader.ReadAt(buffer, 8)
+		if err != io.EOF || n != 2 {
+			t.Errorf("FileReader.ReadAt: expected 2 bytes and io.EOF at the end of file (n: %d, err: %v)", n, err)
+		}
+
+		var sb strings.Builder
+		written, err := reader.WriteTo(&sb)
+		if err != nil {
+			t.Errorf("FileReader.WriteTo: %v", err)
+			return
+		}
+		if written != 3 || sb.String() != "   " {
+			t.Errorf("FileReader.WriteTo: wrong readout after ReadAt (data: %q)", sb.String())
+		}
+
+		_, err = reader.Seek(0, io.SeekStart)
+		if err != nil {
+			t.Errorf("FileReader.Seek: %v", err)
+			return
+		}
+
+		written, err = reader.WriteTo(shortWriter{})
+		if err != io.ErrShortWrite || written != 1 {
+			t.Errorf("FileReader.WriteTo: expected io.ErrShortWrite after 1 byte (written: %d, err: %v)", written, err)
+		}
+
+		stat, err := reader.Stat()
+		if err != nil {
+			t.Errorf("FileReader.Stat: %v", err)
+			return
+		}
+		if stat.Name() != "test1.txt" || stat.Size() != 10 {
+			t.Errorf("FileReader.Stat: wrong file info (name: %s, size: %d)", stat.Name(), stat.Size())
+		}
+
+		err = reader.SFileCloseFile()
+		if err != nil {
+			t.Errorf("SFileCloseFile: %v", err)
 			return
 		}
 
@@ -270,22 +605,48 @@ func TestStorm(t *testing.T) {
 			t.Errorf("SFileCloseArchive: %v", err)
 			return
 		}
+	})
 
-		statBefore, err := os.Stat(mpqFilePath)
+	t.Run("GetFileInfo", func(t *testing.T) {
+		archive, err := storm.SFileOpenArchive(mpqFilePath, storm.STREAM_FLAG_READ_ONLY)
 		if err != nil {
-			t.Errorf("Can't check stat of %s.", mpqFilePath)
+			t.Errorf("SFileOpenArchive: %v", err)
 			return
 		}
 
-		archive, err = storm.SFileOpenArchive(mpqFilePath, 0)
+		archiveInfo, err := archive.Info()
 		if err != nil {
-			t.Errorf("SFileOpenArchive: %v", err)
+			t.Errorf("Archive.Info: %v", err)
 			return
 		}
+		if archiveInfo.MaxFileCount != 128 {
+			t.Errorf("Archive.Info: wrong max file count (expected: %d, actual: %d)", 128, archiveInfo.MaxFileCount)
+		}
+		if archiveInfo.SectorSize == 0 {
+			t.Errorf("Archive.Info: sector size not set")
+		}
 
-		err = archive.SFileCompactArchive(nil)
+		reader, err := archive.SFileOpenFileEx("test2.txt", storm.SFILE_OPEN_FROM_MPQ)
 		if err != nil {
-			t.Errorf("SFileCompactArchive: %v", err)
+			t.Errorf("SFileOpenFileEx: %v", err)
+			return
+		}
+
+		fileInfo, err := reader.Info()
+		if err != nil {
+			t.Errorf("FileReader.Info: %v", err)
+			return
+		}
+		if fileInfo.FileSize != 16384 {
+			t.Errorf("FileReader.Info: wrong file size (expected: %d, actual: %d)", 16384, fileInfo.FileSize)
+		}
+		if fileInfo.Flags&storm.MPQ_FILE_COMPRESS == 0 {
+			t.Errorf("FileReader.Info: file not marked as compressed (flags: %#x)", fileInfo.Flags)
+		}
+
+		err = reader.SFileCloseFile()
+		if err != nil {
+			t.Errorf("SFileCloseFile: %v", err)
 			return
 		}
 
@@ -294,21 +655,1802 @@ func TestStorm(t *testing.T) {
 			t.Errorf("SFileCloseArchive: %v", err)
 			return
 		}
+	})
 
-		statAfter, err := os.Stat(mpqFilePath)
+	t.Run("FS", func(t *testing.T) {
+		archive, err := storm.SFileOpenArchive(mpqFilePath, storm.STREAM_FLAG_READ_ONLY)
 		if err != nil {
-			t.Errorf("Can't check stat of %s.", mpqFilePath)
+			t.Errorf("SFileOpenArchive: %v", err)
 			return
 		}
 
-		if statAfter.Size() >= statBefore.Size() {
-			t.Errorf("File size not reduced after compacting. (before: %d, after: %d)", statBefore.Size(), statAfter.Size())
+		fsys, err := archive.FS()
+		if err != nil {
+			t.Errorf("Archive.FS: %v", err)
+			return
+		}
+
+		err = fstest.TestFS(fsys, "test1.txt", "test2.txt", "dir/test3.txt")
+		if err != nil {
+			t.Errorf("fstest.TestFS: %v", err)
+		}
+
+		raw, err := fs.ReadFile(fsys, "dir/test3.txt")
+		if err != nil {
+			t.Errorf("fs.ReadFile: %v", err)
+			return
+		}
+		if string(raw) != fmt.Sprintf("%-10s", "Test3") {
+			t.Errorf("fs.ReadFile: wrong readout (data: %v)", raw)
+		}
+
+		err = archive.SFileCloseArchive()
+		if err != nil {
+			t.Errorf("SFileCloseArchive: %v", err)
+			return
 		}
 	})
 
-	err = os.Remove(mpqFilePath)
-	if err != nil {
-		t.Errorf("Failed to remove %s. Error: %v", mpqFilePath, err)
-		return
+	t.Run("CompactArchive", func(t *testing.T) {
+		archive, err := storm.SFileOpenArchive(mpqFilePath, 0)
+		if err != nil {
+			t.Errorf("SFileOpenArchive: %v", err)
+			return
+		}
+
+		writer, err := archive.SFileCreateFile("test2.txt", 0, 10, 0, storm.MPQ_FILE_COMPRESS|storm.MPQ_FILE_REPLACEEXISTING)
+		if err != nil {
+			t.Errorf("SFileCreateFile: %v", err)
+			return
+		}
+
+		err = writer.SFileWriteFile([]uint8(fmt.Sprintf("%-10s", "Test")), 0)
+		if err != nil {
+			t.Errorf("SFileWriteFile: %v", err)
+			return
+		}
+
+		err = writer.SFileFinishFile()
+		if err != nil {
+			t.Errorf("SFileFinishFile: %v", err)
+			return
+		}
+
+		err = archive.SFileCloseArchive()
+		if err != nil {
+			t.Errorf("SFileCloseArchive: %v", err)
+			return
+		}
+
+		statBefore, err := os.Stat(mpqFilePath)
+		if err != nil {
+			t.Errorf("Can't check stat of %s.", mpqFilePath)
+			return
+		}
+
+		archive, err = storm.SFileOpenArchive(mpqFilePath, 0)
+		if err != nil {
+			t.Errorf("SFileOpenArchive: %v", err)
+			return
+		}
+
+		compactCalls := 0
+		err = archive.SetCompactProgress(func(workType uint32, bytesProcessed uint64, totalBytes uint64) {
+			compactCalls++
+		})
+		if err != nil {
+			t.Errorf("SetCompactProgress: %v", err)
+			return
+		}
+
+		err = archive.SFileCompactArchive(nil)
+		if err != nil {
+			t.Errorf("SFileCompactArchive: %v", err)
+			return
+		}
+		if compactCalls == 0 {
+			t.Errorf("SetCompactProgress: callback was never called")
+		}
+
+		err = archive.SFileCloseArchive()
+		if err != nil {
+			t.Errorf("SFileCloseArchive: %v", err)
+			return
+		}
+
+		statAfter, err := os.Stat(mpqFilePath)
+		if err != nil {
+			t.Errorf("Can't check stat of %s.", mpqFilePath)
+			return
+		}
+
+		if statAfter.Size() >= statBefore.Size() {
+			t.Errorf("File size not reduced after compacting. (before: %d, after: %d)", statBefore.Size(), statAfter.Size())
+		}
+	})
+
+	t.Run("AddFileProgress", func(t *testing.T) {
+		dir := t.TempDir()
+		data := bytes.Repeat([]byte("progress"), 0x4000)
+		localPath := filepath.Join(dir, "data.bin")
+		err := os.WriteFile(localPath, data, 0644)
+		if err != nil {
+			t.Errorf("WriteFile: %v", err)
+			return
+		}
+
+		archive, err := storm.SFileCreateArchive(filepath.Join(dir, "test.mpq"), 0, 16)
+		if err != nil {
+			t.Errorf("SFileCreateArchive: %v", err)
+			return
+		}
+		defer archive.SFileCloseArchive()
+
+		type progress struct {
+			bytesWritten, totalBytes uint32
+			finalCall                bool
+		}
+		var calls []progress
+		err = archive.SetAddFileProgress(func(bytesWritten uint32, totalBytes uint32, finalCall bool) {
+			calls = append(calls, progress{bytesWritten, totalBytes, finalCall})
+		})
+		if err != nil {
+			t.Errorf("SetAddFileProgress: %v", err)
+			return
+		}
+
+		err = archive.SFileAddFileEx(localPath, "data.bin", storm.MPQ_FILE_COMPRESS, storm.MPQ_COMPRESSION_ZLIB, storm.MPQ_COMPRESSION_NEXT_SAME)
+		if err != nil {
+			t.Errorf("SFileAddFileEx: %v", err)
+			return
+		}
+
+		if len(calls) < 2 || !calls[len(calls)-1].finalCall || calls[len(calls)-1].bytesWritten != uint32(len(data)) {
+			t.Errorf("SetAddFileProgress: expected calls ending with a final call for %d bytes, got %+v", len(data), calls)
+			return
+		}
+		for i, call := range calls {
+			if call.totalBytes != uint32(len(data)) || i > 0 && call.bytesWritten < calls[i-1].bytesWritten || call.finalCall != (i == len(calls)-1) {
+				t.Errorf("SetAddFileProgress: unexpected progress %+v", calls)
+				return
+			}
+		}
+
+		// Clearing the callback and closing the archive delete the handles
+		handle, _, _ := storm.CallbackHandles(archive)
+		if !storm.HandleLive(handle) {
+			t.Errorf("SetAddFileProgress: handle not registered")
+			return
+		}
+		err = archive.SetAddFileProgress(nil)
+		if err != nil {
+			t.Errorf("SetAddFileProgress: %v", err)
+			return
+		}
+		if cleared, _, _ := storm.CallbackHandles(archive); cleared != 0 || storm.HandleLive(handle) {
+			t.Errorf("SetAddFileProgress: handle not deleted when clearing the callback")
+			return
+		}
+
+		err = archive.SetAddFileProgress(func(uint32, uint32, bool) {})
+		if err != nil {
+			t.Errorf("SetAddFileProgress: %v", err)
+			return
+		}
+		handle, _, _ = storm.CallbackHandles(archive)
+		err = archive.SFileCloseArchive()
+		if err != nil {
+			t.Errorf("SFileCloseArchive: %v", err)
+			return
+		}
+		if storm.HandleLive(handle) {
+			t.Errorf("SetAddFileProgress: handle not deleted when closing the archive")
+			return
+		}
+	})
+
+	t.Run("DownloadProgress", func(t *testing.T) {
+		dir := t.TempDir()
+		masterPath := filepath.Join(dir, "master.mpq")
+		data := bytes.Repeat([]byte("download"), 0x8000)
+
+		master, err := storm.SFileCreateArchive(masterPath, 0, 16)
+		if err != nil {
+			t.Errorf("SFileCreateArchive: %v", err)
+			return
+		}
+		err = master.CreateFromReader("data.bin", bytes.NewReader(data), uint32(len(data)), storm.WriteOptions{})
+		if err == nil {
+			err = master.SFileCloseArchive()
+		}
+		if err != nil {
+			t.Errorf("Creating the master archive: %v", err)
+			return
+		}
+
+		// A mirror without a local copy downloads the blocks of the master as they are read
+		archive, err := storm.SFileOpenArchive("flat-file://"+filepath.Join(dir, "mirror.mpq")+"*"+masterPath, storm.STREAM_FLAG_USE_BITMAP)
+		if err != nil {
+			t.Skipf("SFileOpenArchive: mirror archives not supported: %v", err)
+		}
+		defer archive.SFileCloseArchive()
+
+		var offsets []uint64
+		err = archive.SetDownloadProgress(func(byteOffset uint64, totalBytes uint32) {
+			offsets = append(offsets, byteOffset)
+		})
+		if err != nil {
+			t.Errorf("SetDownloadProgress: %v", err)
+			return
+		}
+
+		reader, err := archive.SFileOpenFileEx("data.bin", storm.SFILE_OPEN_FROM_MPQ)
+		if err != nil {
+			t.Errorf("SFileOpenFileEx: %v", err)
+			return
+		}
+		read, err := io.ReadAll(reader)
+		reader.SFileCloseFile()
+		if err != nil || !bytes.Equal(read, data) {
+			t.Errorf("Reading through the mirror: content differs or %v", err)
+			return
+		}
+
+		if len(offsets) == 0 {
+			t.Errorf("SetDownloadProgress: callback was never called")
+			return
+		}
+		for i := 1; i < len(offsets); i++ {
+			if offsets[i] <= offsets[i-1] {
+				t.Errorf("SetDownloadProgress: offsets not increasing: %v", offsets)
+				return
+			}
+		}
+
+		// Clearing the callback and closing the archive delete the handles
+		_, _, handle := storm.CallbackHandles(archive)
+		err = archive.SetDownloadProgress(nil)
+		if err != nil {
+			t.Errorf("SetDownloadProgress: %v", err)
+			return
+		}
+		if _, _, cleared := storm.CallbackHandles(archive); cleared != 0 || storm.HandleLive(handle) {
+			t.Errorf("SetDownloadProgress: handle not deleted when clearing the callback")
+			return
+		}
+
+		err = archive.SetDownloadProgress(func(uint64, uint32) {})
+		if err != nil {
+			t.Errorf("SetDownloadProgress: %v", err)
+			return
+		}
+		_, _, handle = storm.CallbackHandles(archive)
+		err = archive.SFileCloseArchive()
+		if err != nil {
+			t.Errorf("SFileCloseArchive: %v", err)
+			return
+		}
+		if storm.HandleLive(handle) {
+			t.Errorf("SetDownloadProgress: handle not deleted when closing the archive")
+			return
+		}
+	})
+
+	t.Run("Locales", func(t *testing.T) {
+		archive, err := storm.SFileCreateArchive(filepath.Join(t.TempDir(), "test.mpq"), 0, 16)
+		if err != nil {
+			t.Errorf("SFileCreateArchive: %v", err)
+			return
+		}
+
+		for _, locale := range []storm.Locale{storm.LocaleNeutral, storm.LocaleDeDE, storm.LocaleKoKR} {
+			data := locale.String()
+			err = archive.CreateFromReader("locale.txt", strings.NewReader(data), uint32(len(data)), storm.WriteOptions{Locale: locale})
+			if err != nil {
+				t.Errorf("Archive.CreateFromReader: %v", err)
+				return
+			}
+		}
+
+		locales, err := archive.Locales("locale.txt")
+		if err != nil {
+			t.Errorf("Archive.Locales: %v", err)
+			return
+		}
+		if len(locales) != 3 {
+			t.Errorf("Archive.Locales: wrong locales (expected: 3 locales, actual: %v)", locales)
+		}
+
+		exists, err := archive.HasFileLocale("locale.txt", storm.LocaleFrFR)
+		if err != nil || exists {
+			t.Errorf("Archive.HasFileLocale: frFR reported as present (err: %v)", err)
+		}
+
+		_, err = archive.Locales("missing.txt")
+		if !errors.Is(err, storm.ErrNotFound) {
+			t.Errorf("Archive.Locales: expected ErrNotFound for a missing file, got %v", err)
+		}
+
+		exists, err = archive.HasFileLocale("missing.txt", storm.LocaleNeutral)
+		if err != nil || exists {
+			t.Errorf("Archive.HasFileLocale: missing file reported as present (err: %v)", err)
+		}
+
+		reader, err := archive.OpenLocale("locale.txt", storm.LocaleDeDE)
+		if err != nil {
+			t.Errorf("Archive.OpenLocale: %v", err)
+			return
+		}
+
+		raw, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Errorf("ioutil.ReadAll: %v", err)
+			return
+		}
+		if string(raw) != "deDE" {
+			t.Errorf("Archive.OpenLocale: wrong file opened (data: %q)", raw)
+		}
+
+		err = reader.SFileCloseFile()
+		if err != nil {
+			t.Errorf("SFileCloseFile: %v", err)
+			return
+		}
+
+		_, err = archive.OpenLocale("locale.txt", storm.LocaleFrFR)
+		if !errors.Is(err, storm.ErrNotFound) {
+			t.Errorf("Archive.OpenLocale: expected ErrNotFound, got %v", err)
+		}
+
+		err = archive.SFileCloseArchive()
+		if err != nil {
+			t.Errorf("SFileCloseArchive: %v", err)
+			return
+		}
+	})
+
+	t.Run("Attributes", func(t *testing.T) {
+		attributesFilePath := filepath.Join(t.TempDir(), "test.mpq")
+
+		archive, err := storm.SFileCreateArchive(attributesFilePath, storm.MPQ_CREATE_LISTFILE|storm.MPQ_CREATE_ATTRIBUTES, 16)
+		if err != nil {
+			t.Errorf("SFileCreateArchive: %v", err)
+			return
+		}
+
+		data := strings.Repeat("Test", 1000)
+		err = archive.CreateFromReader("test.txt", strings.NewReader(data), uint32(len(data)), storm.WriteOptions{Flags: storm.MPQ_FILE_COMPRESS, Compression: storm.MPQ_COMPRESSION_ZLIB})
+		if err != nil {
+			t.Errorf("Archive.CreateFromReader: %v", err)
+			return
+		}
+
+		err = archive.UpdateFileAttributes("test.txt")
+		if err != nil {
+			t.Errorf("Archive.UpdateFileAttributes: %v", err)
+			return
+		}
+
+		err = archive.SFileCloseArchive()
+		if err != nil {
+			t.Errorf("SFileCloseArchive: %v", err)
+			return
+		}
+
+		archive, err = storm.SFileOpenArchive(attributesFilePath, 0)
+		if err != nil {
+			t.Errorf("SFileOpenArchive: %v", err)
+			return
+		}
+
+		flags, err := archive.Attributes()
+		if err != nil {
+			t.Errorf("Archive.Attributes: %v", err)
+			return
+		}
+		if flags&storm.MPQ_ATTRIBUTE_CRC32 == 0 || flags&storm.MPQ_ATTRIBUTE_MD5 == 0 {
+			t.Errorf("Archive.Attributes: missing CRC32 or MD5 (flags: %#x)", flags)
+		}
+
+		attributes, err := archive.ReadAttributes()
+		if err != nil {
+			t.Errorf("Archive.ReadAttributes: %v", err)
+			return
+		}
+
+		found := false
+		for _, entry := range attributes.Entries {
+			if entry.FileName != "test.txt" {
+				continue
+			}
+			found = true
+			if entry.CRC32 != crc32.ChecksumIEEE([]byte(data)) {
+				t.Errorf("Archive.ReadAttributes: wrong CRC32 (expected: %#x, actual: %#x)", crc32.ChecksumIEEE([]byte(data)), entry.CRC32)
+			}
+			if entry.MD5 != md5.Sum([]byte(data)) {
+				t.Errorf("Archive.ReadAttributes: wrong MD5 (expected: %x, actual: %x)", md5.Sum([]byte(data)), entry.MD5)
+			}
+		}
+		if !found {
+			t.Errorf("Archive.ReadAttributes: no entry for test.txt (entries: %v)", attributes.Entries)
+		}
+
+		err = archive.SetAttributes(storm.MPQ_ATTRIBUTE_CRC32)
+		if err != nil {
+			t.Errorf("Archive.SetAttributes: %v", err)
+			return
+		}
+
+		flags, err = archive.Attributes()
+		if err != nil || flags != storm.MPQ_ATTRIBUTE_CRC32 {
+			t.Errorf("Archive.Attributes: wrong flags after SetAttributes (flags: %#x, err: %v)", flags, err)
+		}
+
+		err = archive.SFileCloseArchive()
+		if err != nil {
+			t.Errorf("SFileCloseArchive: %v", err)
+			return
+		}
+	})
+
+	t.Run("Verify", func(t *testing.T) {
+		verifyFilePath := filepath.Join(t.TempDir(), "test.mpq")
+
+		archive, err := storm.SFileCreateArchive2(verifyFilePath, storm.CreateOptions{
+			MpqVersion:   storm.MPQ_FORMAT_VERSION_4,
+			FileFlags1:   storm.MPQ_FILE_DEFAULT_INTERNAL,
+			FileFlags2:   storm.MPQ_FILE_DEFAULT_INTERNAL,
+			AttrFlags:    storm.MPQ_ATTRIBUTE_CRC32 | storm.MPQ_ATTRIBUTE_MD5,
+			MaxFileCount: 16,
+		})
+		if err != nil {
+			t.Errorf("SFileCreateArchive2: %v", err)
+			return
+		}
+
+		data := strings.Repeat("Test", 10000)
+		err = archive.CreateFromReader("test.txt", strings.NewReader(data), uint32(len(data)), storm.WriteOptions{Flags: storm.MPQ_FILE_COMPRESS | storm.MPQ_FILE_SECTOR_CRC, Compression: storm.MPQ_COMPRESSION_ZLIB})
+		if err != nil {
+			t.Errorf("Archive.CreateFromReader: %v", err)
+			return
+		}
+
+		err = archive.SFileCloseArchive()
+		if err != nil {
+			t.Errorf("SFileCloseArchive: %v", err)
+			return
+		}
+
+		archive, err = storm.SFileOpenArchive(verifyFilePath, storm.STREAM_FLAG_READ_ONLY)
+		if err != nil {
+			t.Errorf("SFileOpenArchive: %v", err)
+			return
+		}
+
+		result, err := archive.VerifyFile("test.txt", storm.SFILE_VERIFY_ALL)
+		if err != nil {
+			t.Errorf("Archive.VerifyFile: %v", err)
+			return
+		}
+		if err := result.Err(); err != nil {
+			t.Errorf("Archive.VerifyFile: %v", err)
+		}
+		if !result.HasMD5() || !result.HasCRC32() {
+			t.Errorf("Archive.VerifyFile: checksums not verified (result: %#x)", uint32(result))
+		}
+
+		crc, sum, err := archive.Checksums("test.txt")
+		if err != nil {
+			t.Errorf("Archive.Checksums: %v", err)
+			return
+		}
+		if crc != crc32.ChecksumIEEE([]byte(data)) || sum != md5.Sum([]byte(data)) {
+			t.Errorf("Archive.Checksums: wrong checksums (CRC32: %#x, MD5: %x)", crc, sum)
+		}
+
+		err = archive.VerifyRawData(storm.SFILE_VERIFY_MPQ_HEADER, "")
+		if err != nil {
+			t.Errorf("Archive.VerifyRawData: %v", err)
+		}
+
+		err = archive.VerifyRawData(storm.SFILE_VERIFY_FILE, "test.txt")
+		if err != nil {
+			t.Errorf("Archive.VerifyRawData: %v", err)
+		}
+
+		signature, err := archive.VerifySignature()
+		if err != nil {
+			t.Errorf("Archive.VerifySignature: %v", err)
+			return
+		}
+		if signature.Signed() || signature.Err() != nil {
+			t.Errorf("Archive.VerifySignature: unexpected result (%v)", signature)
+		}
+
+		report, err := archive.Verify(context.Background(), storm.VerifyOptions{})
+		if err != nil {
+			t.Errorf("Archive.Verify: %v", err)
+			return
+		}
+		if !report.OK() || report.Totals.Files == 0 || report.Totals.Passed != report.Totals.Files {
+			t.Errorf("Archive.Verify: unexpected report (totals: %+v, errors: %v)", report.Totals, report.FirstErrors)
+		}
+		if len(report.RawData) == 0 {
+			t.Errorf("Archive.Verify: raw data of a version 4 archive not verified")
+		}
+
+		_, err = json.Marshal(report)
+		if err != nil {
+			t.Errorf("json.Marshal: %v", err)
+		}
+
+		err = archive.SFileCloseArchive()
+		if err != nil {
+			t.Errorf("SFileCloseArchive: %v", err)
+			return
+		}
+
+		unlistedFilePath := filepath.Join(t.TempDir(), "unlisted.mpq")
+
+		archive, err = storm.SFileCreateArchive2(unlistedFilePath, storm.CreateOptions{
+			MpqVersion:   storm.MPQ_FORMAT_VERSION_4,
+			FileFlags2:   storm.MPQ_FILE_DEFAULT_INTERNAL,
+			AttrFlags:    storm.MPQ_ATTRIBUTE_CRC32 | storm.MPQ_ATTRIBUTE_MD5,
+			MaxFileCount: 16,
+		})
+		if err != nil {
+			t.Errorf("SFileCreateArchive2: %v", err)
+			return
+		}
+
+		err = archive.CreateFromReader("unlisted.txt", strings.NewReader(data), uint32(len(data)), storm.WriteOptions{Flags: storm.MPQ_FILE_COMPRESS, Compression: storm.MPQ_COMPRESSION_ZLIB})
+		if err != nil {
+			t.Errorf("Archive.CreateFromReader: %v", err)
+			return
+		}
+
+		err = archive.SFileCloseArchive()
+		if err != nil {
+			t.Errorf("SFileCloseArchive: %v", err)
+			return
+		}
+
+		listFilePath := filepath.Join(t.TempDir(), "listfile.txt")
+		err = os.WriteFile(listFilePath, []byte("missing.txt\r\nUNLISTED.TXT\r\n"), 0644)
+		if err != nil {
+			t.Errorf("os.WriteFile: %v", err)
+			return
+		}
+
+		archive, err = storm.SFileOpenArchive(unlistedFilePath, storm.STREAM_FLAG_READ_ONLY)
+		if err != nil {
+			t.Errorf("SFileOpenArchive: %v", err)
+			return
+		}
+
+		report, err = archive.Verify(context.Background(), storm.VerifyOptions{ListFile: listFilePath})
+		if err != nil {
+			t.Errorf("Archive.Verify: %v", err)
+			return
+		}
+		if !report.OK() || report.Totals.UnknownNames != 0 {
+			t.Errorf("Archive.Verify: unexpected report (files: %+v, errors: %v)", report.Files, report.FirstErrors)
+		}
+		resolved := false
+		for _, file := range report.Files {
+			resolved = resolved || file.Name == "UNLISTED.TXT" && file.NameKnown
+		}
+		if !resolved {
+			t.Errorf("Archive.Verify: name not resolved from the list file (files: %+v)", report.Files)
+		}
+
+		_, _, err = archive.SFileFindFirstFile("*.TXT", "")
+		if !errors.Is(err, storm.ErrNoMoreFiles) {
+			t.Errorf("Archive.Verify: list file added to the archive (err: %v)", err)
+		}
+
+		err = archive.SFileCloseArchive()
+		if err != nil {
+			t.Errorf("SFileCloseArchive: %v", err)
+			return
+		}
+
+		err = storm.VerifyResult(storm.VERIFY_FILE_HAS_MD5 | storm.VERIFY_FILE_MD5_ERROR).Err()
+		if err == nil || !strings.Contains(err.Error(), "MD5 mismatch") {
+			t.Errorf("VerifyResult.Err: MD5 failure not explained (err: %v)", err)
+		}
+	})
+
+	t.Run("ListFile", func(t *testing.T) {
+		archive, err := storm.SFileOpenArchive("./test.mpq", 0)
+		if err != nil {
+			t.Errorf("SFileOpenArchive: %v", err)
+			return
+		}
+		defer archive.SFileCloseArchive()
+
+		listFilePath := filepath.Join(t.TempDir(), "listfile.txt")
+		err = ioutil.WriteFile(listFilePath, []byte("test1.txt\r\nmissing.txt\r\ndir\\test3.txt\r\ntest1.txt\r\n"), 0644)
+		if err != nil {
+			t.Errorf("WriteFile: %v", err)
+			return
+		}
+
+		finder, findFileData, err := archive.SListFileFindFirstFile(listFilePath, "*")
+		if err != nil {
+			t.Errorf("SListFileFindFirstFile: %v", err)
+			return
+		}
+		listed := []string{findFileData.FileName}
+		for {
+			findFileData, err = finder.SListFileFindNextFile()
+			if err != nil {
+				break
+			}
+			listed = append(listed, findFileData.FileName)
+		}
+		finder.SListFileFindClose()
+		if len(listed) < 3 {
+			t.Errorf("SListFileFindNextFile: expected at least 3 names, got %v", listed)
+			return
+		}
+
+		var resolved []string
+		err = archive.ResolveListFileFunc(listFilePath, func(fileName string) error {
+			resolved = append(resolved, fileName)
+			return nil
+		})
+		if err != nil {
+			t.Errorf("Archive.ResolveListFileFunc: %v", err)
+			return
+		}
+		if strings.Join(resolved, ",") != "test1.txt,dir\\test3.txt" {
+			t.Errorf("Archive.ResolveListFileFunc: unexpected names %v", resolved)
+			return
+		}
+
+		resolved = nil
+		err = archive.ResolveListFileReaderFunc(strings.NewReader("missing.txt;test2.txt\ntest1.txt;TEST2.txt"), func(fileName string) error {
+			resolved = append(resolved, fileName)
+			return nil
+		})
+		if err != nil {
+			t.Errorf("Archive.ResolveListFileReaderFunc: %v", err)
+			return
+		}
+		if strings.Join(resolved, ",") != "test2.txt,test1.txt" {
+			t.Errorf("Archive.ResolveListFileReaderFunc: unexpected names %v", resolved)
+			return
+		}
+
+		errStop := errors.New("stop")
+		err = archive.ResolveListFileFunc(listFilePath, func(fileName string) error {
+			return errStop
+		})
+		if err != errStop {
+			t.Errorf("Archive.ResolveListFileFunc: expected the error of the callback, got %v", err)
+			return
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		missingFilePath := filepath.Join(t.TempDir(), "missing.mpq")
+
+		_, err := storm.SFileOpenArchive(missingFilePath, storm.STREAM_FLAG_READ_ONLY)
+		if !errors.Is(err, storm.ErrNotFound) || !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("SFileOpenArchive: expected not found error, got %v", err)
+			return
+		}
+		var stormErr *storm.StormError
+		if !errors.As(err, &stormErr) || stormErr.Op != "open" || stormErr.Path != missingFilePath {
+			t.Errorf("SFileOpenArchive: unexpected error %#v", err)
+			return
+		}
+		if !strings.Contains(err.Error(), missingFilePath) {
+			t.Errorf("SFileOpenArchive: error does not contain the path: %v", err)
+			return
+		}
+
+		archive, err := storm.SFileOpenArchive(mpqFilePath, storm.STREAM_FLAG_READ_ONLY)
+		if err != nil {
+			t.Errorf("SFileOpenArchive: %v", err)
+			return
+		}
+		defer archive.SFileCloseArchive()
+
+		_, err = archive.SFileOpenFileEx("missing.txt", storm.SFILE_OPEN_FROM_MPQ)
+		if !errors.Is(err, fs.ErrNotExist) || !strings.Contains(err.Error(), "missing.txt") {
+			t.Errorf("SFileOpenFileEx: expected not found error, got %v", err)
+			return
+		}
+
+		err = archive.Remove("test1.txt", storm.SFILE_OPEN_FROM_MPQ)
+		if !errors.Is(err, storm.ErrAccessDenied) || !errors.Is(err, fs.ErrPermission) {
+			t.Errorf("Remove: expected access denied error, got %v", err)
+			return
+		}
+
+		invalidFilePath := filepath.Join(t.TempDir(), "invalid.mpq")
+		_, err = storm.SFileCreateArchive2(invalidFilePath, storm.CreateOptions{MpqVersion: storm.MPQ_FORMAT_VERSION_4 + 1})
+		if !errors.As(err, &stormErr) || stormErr.Op != "create" || stormErr.Path != invalidFilePath || !errors.Is(err, storm.ErrInvalidParameter) {
+			t.Errorf("SFileCreateArchive2: unexpected error %#v", err)
+			return
+		}
+
+		_, err = storm.OpenPool(mpqFilePath, 0, 0)
+		if err == nil || !strings.HasPrefix(err.Error(), "storm: open pool "+mpqFilePath+": ") {
+			t.Errorf("OpenPool: error not formatted as a path error: %v", err)
+			return
+		}
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		archive, err := storm.SFileOpenArchive(mpqFilePath, storm.STREAM_FLAG_READ_ONLY)
+		if err != nil {
+			t.Errorf("SFileOpenArchive: %v", err)
+			return
+		}
+
+		reader, err := archive.SFileOpenFileEx("test1.txt", storm.SFILE_OPEN_FROM_MPQ)
+		if err != nil {
+			t.Errorf("SFileOpenFileEx: %v", err)
+			return
+		}
+
+		var closers = []io.Closer{reader, archive}
+		for _, closer := range closers {
+			err = closer.Close()
+			if err != nil {
+				t.Errorf("Close: %v", err)
+				return
+			}
+			err = closer.Close()
+			if !errors.Is(err, fs.ErrClosed) {
+				t.Errorf("Close: expected fs.ErrClosed on second close, got %v", err)
+				return
+			}
+		}
+
+		_, err = reader.Read(make([]byte, 1))
+		if !errors.Is(err, fs.ErrClosed) {
+			t.Errorf("Read: expected fs.ErrClosed, got %v", err)
+			return
+		}
+		_, err = archive.SFileOpenFileEx("test1.txt", storm.SFILE_OPEN_FROM_MPQ)
+		if !errors.Is(err, fs.ErrClosed) {
+			t.Errorf("SFileOpenFileEx: expected fs.ErrClosed, got %v", err)
+			return
+		}
+		_, err = archive.IsPatched()
+		if !errors.Is(err, fs.ErrClosed) {
+			t.Errorf("Archive.IsPatched: expected fs.ErrClosed, got %v", err)
+			return
+		}
+		if archive.SFileIsPatchedArchive() {
+			t.Errorf("SFileIsPatchedArchive: expected false for a closed archive")
+			return
+		}
+		_, err = archive.VerifySignature()
+		if !errors.Is(err, fs.ErrClosed) {
+			t.Errorf("Archive.VerifySignature: expected fs.ErrClosed, got %v", err)
+			return
+		}
+		if result := archive.SFileVerifyArchive(); result != storm.ERROR_VERIFY_FAILED {
+			t.Errorf("SFileVerifyArchive: expected ERROR_VERIFY_FAILED for a closed archive, got %d", result)
+			return
+		}
+
+		// Closing the archive first releases the files and searches opened from it
+		archive, err = storm.SFileOpenArchive(mpqFilePath, storm.STREAM_FLAG_READ_ONLY)
+		if err != nil {
+			t.Errorf("SFileOpenArchive: %v", err)
+			return
+		}
+		reader, err = archive.SFileOpenFileEx("test1.txt", storm.SFILE_OPEN_FROM_MPQ)
+		if err != nil {
+			t.Errorf("SFileOpenFileEx: %v", err)
+			return
+		}
+		finder, _, err := archive.SFileFindFirstFile("*", "")
+		if err != nil {
+			t.Errorf("SFileFindFirstFile: %v", err)
+			return
+		}
+
+		err = archive.SFileCloseArchive()
+		if err != nil {
+			t.Errorf("SFileCloseArchive: %v", err)
+			return
+		}
+		for _, closer := range []io.Closer{reader, finder} {
+			err = closer.Close()
+			if !errors.Is(err, fs.ErrClosed) {
+				t.Errorf("Close: expected fs.ErrClosed after closing the archive, got %v", err)
+				return
+			}
+		}
+	})
+
+	t.Run("LeakHook", func(t *testing.T) {
+		leaked := make(chan string, 8)
+		storm.SetLeakHook(func(resource string, name string) {
+			leaked <- resource
+		})
+		defer storm.SetLeakHook(nil)
+
+		func() {
+			archive, err := storm.SFileOpenArchive(mpqFilePath, storm.STREAM_FLAG_READ_ONLY)
+			if err != nil {
+				t.Errorf("SFileOpenArchive: %v", err)
+				return
+			}
+			_, err = archive.SFileOpenFileEx("test1.txt", storm.SFILE_OPEN_FROM_MPQ)
+			if err != nil {
+				t.Errorf("SFileOpenFileEx: %v", err)
+				return
+			}
+		}()
+
+		var resources []string
+		deadline := time.Now().Add(5 * time.Second)
+		for len(resources) < 2 && time.Now().Before(deadline) {
+			runtime.GC()
+			select {
+			case resource := <-leaked:
+				resources = append(resources, resource)
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+		if strings.Join(resources, ",") != "file reader,archive" {
+			t.Errorf("SetLeakHook: unexpected leaks %v", resources)
+			return
+		}
+	})
+
+	t.Run("Concurrency", func(t *testing.T) {
+		archive, err := storm.SFileOpenArchive(mpqFilePath, storm.STREAM_FLAG_READ_ONLY)
+		if err != nil {
+			t.Errorf("SFileOpenArchive: %v", err)
+			return
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 16; j++ {
+					reader, err := archive.SFileOpenFileEx("test2.txt", storm.SFILE_OPEN_FROM_MPQ)
+					if errors.Is(err, fs.ErrClosed) {
+						return
+					}
+					if err != nil {
+						t.Errorf("SFileOpenFileEx: %v", err)
+						return
+					}
+					data, err := io.ReadAll(reader)
+					reader.SFileCloseFile()
+					if errors.Is(err, fs.ErrClosed) {
+						return
+					}
+					if err != nil || len(data) != 16384 {
+						t.Errorf("ReadAll: read %d bytes, %v", len(data), err)
+						return
+					}
+				}
+			}()
+		}
+		time.Sleep(time.Millisecond)
+		archive.SFileCloseArchive()
+		wg.Wait()
+	})
+
+	t.Run("Pool", func(t *testing.T) {
+		_, err := storm.OpenPool(mpqFilePath, 0, 0)
+		if !errors.Is(err, storm.ErrInvalidParameter) {
+			t.Errorf("OpenPool: expected invalid parameter error, got %v", err)
+			return
+		}
+
+		pool, err := storm.OpenPool(mpqFilePath, 4, 0)
+		if err != nil {
+			t.Errorf("OpenPool: %v", err)
+			return
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 16; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for _, name := range []string{"test1.txt", "test2.txt"} {
+					data, err := pool.ReadFile(context.Background(), name)
+					if err != nil {
+						t.Errorf("ReadFile: %v", err)
+						return
+					}
+					if name == "test1.txt" && len(data) != 10 {
+						t.Errorf("ReadFile: unexpected content %q", data)
+						return
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		archives := make([]*storm.Archive, 4)
+		for i := range archives {
+			archives[i], err = pool.Get(context.Background())
+			if err != nil {
+				t.Errorf("Get: %v", err)
+				return
+			}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		_, err = pool.Get(ctx)
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Get: expected deadline exceeded, got %v", err)
+			return
+		}
+		for _, archive := range archives {
+			pool.Put(archive)
+		}
+
+		err = pool.Close()
+		if err != nil {
+			t.Errorf("Close: %v", err)
+			return
+		}
+		_, err = pool.Get(context.Background())
+		if !errors.Is(err, fs.ErrClosed) {
+			t.Errorf("Get: expected fs.ErrClosed, got %v", err)
+			return
+		}
+	})
+
+	t.Run("ExtractAll", func(t *testing.T) {
+		archive, err := storm.SFileOpenArchive(mpqFilePath, storm.STREAM_FLAG_READ_ONLY)
+		if err != nil {
+			t.Errorf("SFileOpenArchive: %v", err)
+			return
+		}
+		defer archive.SFileCloseArchive()
+
+		for _, name := range []string{"..\\evil.txt", "dir\\..\\..\\evil.txt", "\\evil.txt", "C:\\evil.txt", "", "NUL", "dir\\con.txt", "Com1 .log", "lpt9\\file.txt", "dir.\\file.txt", "file.txt "} {
+			_, err = storm.SafePath(name)
+			if !errors.Is(err, storm.ErrInvalidParameter) {
+				t.Errorf("SafePath: expected %q to be rejected, got %v", name, err)
+				return
+			}
+		}
+
+		for _, name := range []string{"console.txt", "dir\\nul1.txt", "com10", ".hidden"} {
+			_, err = storm.SafePath(name)
+			if err != nil {
+				t.Errorf("SafePath: expected %q to be accepted, got %v", name, err)
+				return
+			}
+		}
+
+		destDir := t.TempDir()
+		var progressCalls int
+		report, err := archive.ExtractAll(context.Background(), destDir, storm.ExtractOptions{
+			Mask:          "*.txt",
+			Workers:       4,
+			PreserveTimes: true,
+			Progress: func(progress storm.ExtractProgress) {
+				progressCalls++
+			},
+		})
+		if err != nil {
+			t.Errorf("ExtractAll: %v", err)
+			return
+		}
+		if report.Extracted != 3 || len(report.Errors) != 0 || progressCalls != 3 {
+			t.Errorf("ExtractAll: unexpected report %+v (progress calls: %d)", report, progressCalls)
+			return
+		}
+		data, err := os.ReadFile(filepath.Join(destDir, "dir", "test3.txt"))
+		if err != nil {
+			t.Errorf("ReadFile: %v", err)
+			return
+		}
+		if len(data) == 0 {
+			t.Errorf("ExtractAll: dir/test3.txt is empty")
+			return
+		}
+
+		report, err = archive.ExtractAll(context.Background(), destDir, storm.ExtractOptions{Mask: "*.txt"})
+		if err != nil || report.Skipped != 3 {
+			t.Errorf("ExtractAll: expected all files to be skipped, got %+v, %v", report, err)
+			return
+		}
+
+		report, err = archive.ExtractAll(context.Background(), destDir, storm.ExtractOptions{
+			Mask: "test1.txt",
+			PathMapper: func(fileName string) (string, error) {
+				return "../escaped.txt", nil
+			},
+		})
+		if err != nil || len(report.Errors) != 1 || !errors.Is(report.Errors[0], storm.ErrInvalidParameter) {
+			t.Errorf("ExtractAll: expected the mapped path to be rejected, got %+v, %v", report, err)
+			return
+		}
+
+		timedFilePath := filepath.Join(t.TempDir(), "timed.mpq")
+		timed, err := storm.SFileCreateArchive(timedFilePath, storm.MPQ_CREATE_LISTFILE|storm.MPQ_CREATE_ATTRIBUTES, 16)
+		if err != nil {
+			t.Errorf("SFileCreateArchive: %v", err)
+			return
+		}
+		defer timed.SFileCloseArchive()
+		modTime := time.Date(2004, 11, 23, 12, 30, 0, 0, time.UTC)
+		fileTime := uint64(modTime.Unix()+11644473600) * 10000000
+		timedNames := []string{"a.txt", "b.txt", "dir\\c.txt"}
+		for _, name := range timedNames {
+			err = timed.CreateFromReader(name, strings.NewReader(name), uint32(len(name)), storm.WriteOptions{FileTime: fileTime})
+			if err != nil {
+				t.Errorf("Archive.CreateFromReader: %v", err)
+				return
+			}
+		}
+		timedDir := t.TempDir()
+		report, err = timed.ExtractAll(context.Background(), timedDir, storm.ExtractOptions{Mask: "*.txt", Workers: 2, PreserveTimes: true})
+		if err != nil || report.Extracted != len(timedNames) || len(report.Errors) != 0 {
+			t.Errorf("ExtractAll: unexpected report %+v, %v", report, err)
+			return
+		}
+		for _, name := range timedNames {
+			stat, err := os.Stat(filepath.Join(timedDir, filepath.FromSlash(strings.ReplaceAll(name, "\\", "/"))))
+			if err != nil {
+				t.Errorf("Stat: %v", err)
+				return
+			}
+			if !stat.ModTime().Equal(modTime) {
+				t.Errorf("ExtractAll: expected modification time %v for %s, got %v", modTime, name, stat.ModTime())
+				return
+			}
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err = archive.ExtractAll(ctx, t.TempDir(), storm.ExtractOptions{})
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("ExtractAll: expected context.Canceled, got %v", err)
+			return
+		}
+	})
+
+	t.Run("AddTree", func(t *testing.T) {
+		srcDir := t.TempDir()
+		for i := 0; i < 10; i++ {
+			name := filepath.Join(srcDir, fmt.Sprintf("dir%d", i%3), fmt.Sprintf("file%d.txt", i))
+			err := os.MkdirAll(filepath.Dir(name), 0755)
+			if err == nil {
+				err = os.WriteFile(name, []byte(strings.Repeat(fmt.Sprint(i), 100)), 0644)
+			}
+			if err != nil {
+				t.Errorf("WriteFile: %v", err)
+				return
+			}
+		}
+		err := os.WriteFile(filepath.Join(srcDir, "skip.tmp"), []byte("temporary"), 0644)
+		if err != nil {
+			t.Errorf("WriteFile: %v", err)
+			return
+		}
+
+		archive, err := storm.SFileCreateArchive(filepath.Join(t.TempDir(), "tree.mpq"), storm.MPQ_CREATE_LISTFILE|storm.MPQ_CREATE_ATTRIBUTES, 4)
+		if err != nil {
+			t.Errorf("SFileCreateArchive: %v", err)
+			return
+		}
+		defer archive.SFileCloseArchive()
+
+		options := storm.AddTreeOptions{
+			Prefix:  "data",
+			Exclude: []string{"*.tmp"},
+			Rules:   []storm.CompressionRule{{Extension: ".TXT", Flags: storm.MPQ_FILE_COMPRESS, Compression: storm.MPQ_COMPRESSION_BZIP2}},
+		}
+		report, err := archive.AddTree(context.Background(), srcDir, options)
+		if err != nil {
+			t.Errorf("AddTree: %v", err)
+			return
+		}
+		if report.Added != 10 || report.Bytes != 1000 {
+			t.Errorf("AddTree: unexpected report %+v", report)
+			return
+		}
+
+		exists, err := archive.SFileHasFile("data\\dir1\\file4.txt")
+		if err != nil || !exists {
+			t.Errorf("SFileHasFile: expected data\\dir1\\file4.txt to exist, got %v, %v", exists, err)
+			return
+		}
+		exists, err = archive.SFileHasFile("data\\skip.tmp")
+		if err != nil || exists {
+			t.Errorf("SFileHasFile: expected data\\skip.tmp to be excluded, got %v, %v", exists, err)
+			return
+		}
+		_, err = archive.ReadAttributes()
+		if err != nil {
+			t.Errorf("ReadAttributes: %v", err)
+			return
+		}
+
+		report, err = archive.AddTree(context.Background(), srcDir, options)
+		if err != nil || report.Skipped != 10 {
+			t.Errorf("AddTree: expected all files to be skipped, got %+v, %v", report, err)
+			return
+		}
+
+		options.ReplaceExisting = true
+		options.Include = []string{"dir0/*"}
+		report, err = archive.AddTree(context.Background(), srcDir, options)
+		if err != nil || report.Added != 4 {
+			t.Errorf("AddTree: expected 4 files to be replaced, got %+v, %v", report, err)
+			return
+		}
+
+		report, err = archive.AddTreeFS(context.Background(), fstest.MapFS{
+			"a.txt":     {Data: []byte("a")},
+			"sub/b.txt": {Data: []byte("bb")},
+		}, storm.AddTreeOptions{})
+		if err != nil || report.Added != 2 {
+			t.Errorf("AddTreeFS: unexpected report %+v, %v", report, err)
+			return
+		}
+		exists, err = archive.SFileHasFile("sub\\b.txt")
+		if err != nil || !exists {
+			t.Errorf("SFileHasFile: expected sub\\b.txt to exist, got %v, %v", exists, err)
+			return
+		}
+
+		report, err = archive.AddTreeFS(context.Background(), fstest.MapFS{
+			"stored.txt": {Data: []byte(strings.Repeat("stored", 100))},
+		}, storm.AddTreeOptions{Store: true})
+		if err != nil || report.Added != 1 {
+			t.Errorf("AddTreeFS: unexpected report %+v, %v", report, err)
+			return
+		}
+		reader, err := archive.SFileOpenFileEx("stored.txt", storm.SFILE_OPEN_FROM_MPQ)
+		if err != nil {
+			t.Errorf("SFileOpenFileEx: %v", err)
+			return
+		}
+		info, err := reader.Info()
+		reader.SFileCloseFile()
+		if err != nil || info.Flags&storm.MPQ_FILE_COMPRESS != 0 || info.CompressedSize != info.FileSize {
+			t.Errorf("AddTreeFS: expected stored.txt to be stored uncompressed, got %+v, %v", info, err)
+			return
+		}
+
+		for _, options := range []storm.AddTreeOptions{{Include: []string{"*.txt", "["}}, {Exclude: []string{"dir/[a-"}}} {
+			report, err = archive.AddTreeFS(context.Background(), fstest.MapFS{}, options)
+			if !errors.Is(err, path.ErrBadPattern) || report != nil {
+				t.Errorf("AddTreeFS: expected path.ErrBadPattern, got %+v, %v", report, err)
+				return
+			}
+		}
+	})
+
+	t.Run("Compression", func(t *testing.T) {
+		data := append([]byte(strings.Repeat("The quick brown fox jumps over the lazy dog. ", 100)), make([]byte, 2000)...)
+
+		for _, mask := range []comp.Mask{comp.Zlib, comp.BZip2, comp.PKWare, comp.Huffman, comp.Sparse, comp.LZMA, comp.Sparse | comp.Zlib} {
+			compressed, err := comp.SCompCompress(data, mask, comp.TypeBinary, 0)
+			if err != nil {
+				t.Errorf("SCompCompress (%v): %v", mask, err)
+				return
+			}
+			if len(compressed) >= len(data) || comp.Mask(compressed[0]) != mask {
+				t.Errorf("SCompCompress (%v): unexpected result of %d bytes with mask %#x", mask, len(compressed), compressed[0])
+				return
+			}
+
+			decompressed, err := comp.SCompDecompress(compressed, len(data))
+			if err != nil {
+				t.Errorf("SCompDecompress (%v): %v", mask, err)
+				return
+			}
+			if !bytes.Equal(decompressed, data) {
+				t.Errorf("SCompDecompress (%v): data mismatch", mask)
+				return
+			}
+		}
+
+		imploded, err := comp.SCompImplode(data)
+		if err != nil {
+			t.Errorf("SCompImplode: %v", err)
+			return
+		}
+		exploded, err := comp.SCompExplode(imploded, len(data))
+		if err != nil || !bytes.Equal(exploded, data) {
+			t.Errorf("SCompExplode: data mismatch, %v", err)
+			return
+		}
+
+		_, err = comp.SCompDecompress([]byte{byte(comp.Zlib), 1, 2, 3, 4}, len(data))
+		var stormErr *storm.StormError
+		if !errors.As(err, &stormErr) || stormErr.Op != "SCompDecompress" {
+			t.Errorf("SCompDecompress: expected a StormError of SCompDecompress for corrupt data, got %v", err)
+			return
+		}
+
+		_, err = comp.SCompExplode(nil, len(data))
+		if !errors.As(err, &stormErr) || stormErr.Op != "SCompExplode" || stormErr.Code != storm.ERROR_INVALID_PARAMETER {
+			t.Errorf("SCompExplode: expected ERROR_INVALID_PARAMETER of SCompExplode for no data, got %v", err)
+			return
+		}
+
+		var buffer bytes.Buffer
+		writer := comp.NewWriter(&buffer, comp.Options{Mask: comp.Zlib | comp.PKWare})
+		_, err = writer.Write(data)
+		if err == nil {
+			err = writer.Close()
+		}
+		if err != nil {
+			t.Errorf("Writer: %v", err)
+			return
+		}
+		decompressed, err := io.ReadAll(comp.NewReader(&buffer, len(data)))
+		if err != nil || !bytes.Equal(decompressed, data) {
+			t.Errorf("Reader: data mismatch, %v", err)
+			return
+		}
+
+		if mask := comp.Huffman | comp.ADPCMStereo; mask.String() != "adpcm-stereo|huffman" {
+			t.Errorf("Mask.String: unexpected %q", mask.String())
+			return
+		}
+	})
+
+	t.Run("PKWare", func(t *testing.T) {
+		data := append([]byte(strings.Repeat("The quick brown fox jumps over the lazy dog. ", 200)), make([]byte, 5000)...)
+		for i := 0; i < 3000; i++ {
+			data = append(data, byte(i*i>>3))
+		}
+
+		imploded, err := comp.SCompImplode(data)
+		if err != nil {
+			t.Errorf("SCompImplode: %v", err)
+			return
+		}
+		exploded, err := io.ReadAll(pkware.NewReader(bytes.NewReader(imploded)))
+		if err != nil || !bytes.Equal(exploded, data) {
+			t.Errorf("pkware.Reader: data mismatch, %v", err)
+			return
+		}
+
+		for _, mode := range []pkware.Mode{pkware.Binary, pkware.ASCII} {
+			for _, dictSize := range []int{pkware.Dict1K, pkware.Dict2K, pkware.Dict4K} {
+				var buffer bytes.Buffer
+				writer, err := pkware.NewWriter(&buffer, mode, dictSize)
+				if err != nil {
+					t.Errorf("pkware.NewWriter: %v", err)
+					return
+				}
+				for i := 0; i < len(data) && err == nil; i += 1000 {
+					_, err = writer.Write(data[i:minInt(i+1000, len(data))])
+				}
+				if err == nil {
+					err = writer.Close()
+				}
+				if err != nil {
+					t.Errorf("pkware.Writer (mode: %d, dictionary size: %d): %v", mode, dictSize, err)
+					return
+				}
+				if buffer.Len() >= len(data) {
+					t.Errorf("pkware.Writer (mode: %d, dictionary size: %d): data not compressed (size: %d)", mode, dictSize, buffer.Len())
+				}
+
+				exploded, err := comp.SCompExplode(buffer.Bytes(), len(data))
+				if err != nil || !bytes.Equal(exploded, data) {
+					t.Errorf("SCompExplode (mode: %d, dictionary size: %d): data mismatch, %v", mode, dictSize, err)
+					return
+				}
+
+				exploded, err = pkware.Explode(buffer.Bytes())
+				if err != nil || !bytes.Equal(exploded, data) {
+					t.Errorf("pkware.Explode (mode: %d, dictionary size: %d): data mismatch, %v", mode, dictSize, err)
+					return
+				}
+			}
+		}
+
+		_, err = pkware.Explode(imploded[:len(imploded)/2])
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("pkware.Explode: expected io.ErrUnexpectedEOF for truncated data, got %v", err)
+		}
+
+		_, err = pkware.Explode([]byte{2, 4, 0})
+		if !errors.Is(err, pkware.ErrHeader) {
+			t.Errorf("pkware.Explode: expected ErrHeader, got %v", err)
+		}
+
+		_, err = pkware.NewWriter(io.Discard, pkware.Binary, 3000)
+		if !errors.Is(err, pkware.ErrInvalid) {
+			t.Errorf("pkware.NewWriter: expected ErrInvalid, got %v", err)
+		}
+	})
+
+	t.Run("ADPCM", func(t *testing.T) {
+		// A decaying chirp with some noise, as 16-bit little-endian PCM
+		pcm := make([]byte, 16000)
+		for i := 0; i < 8000; i++ {
+			sample := int16(20000*math.Exp(-float64(i)/4000)*math.Sin(float64(i*i)/20000) + float64(i*7919%61) - 30)
+			binary.LittleEndian.PutUint16(pcm[2*i:], uint16(sample))
+		}
+
+		for _, test := range []struct {
+			mask     comp.Mask
+			channels int
+		}{{comp.ADPCMMono, 1}, {comp.ADPCMStereo, 2}} {
+			for _, cmpLevel := range []int{1, 2, 3, 4} {
+				compressed, err := comp.SCompCompress(pcm, test.mask, 0, cmpLevel)
+				if err != nil {
+					t.Errorf("SCompCompress (%v, level: %d): %v", test.mask, cmpLevel, err)
+					return
+				}
+
+				goCompressed, err := adpcm.Compress(pcm, test.channels, adpcm.Level(cmpLevel))
+				if err != nil {
+					t.Errorf("adpcm.Compress (%v, level: %d): %v", test.mask, cmpLevel, err)
+					return
+				}
+				if comp.Mask(compressed[0]) != test.mask || !bytes.Equal(compressed[1:], goCompressed) {
+					t.Errorf("adpcm.Compress (%v, level: %d): output differs from SCompCompress", test.mask, cmpLevel)
+					return
+				}
+
+				decompressed, err := comp.SCompDecompress(compressed, len(pcm))
+				if err != nil {
+					t.Errorf("SCompDecompress (%v, level: %d): %v", test.mask, cmpLevel, err)
+					return
+				}
+				goDecompressed, err := adpcm.Decompress(goCompressed, test.channels)
+				if err != nil || !bytes.Equal(goDecompressed, decompressed) {
+					t.Errorf("adpcm.Decompress (%v, level: %d): output differs from SCompDecompress, %v", test.mask, cmpLevel, err)
+					return
+				}
+			}
+		}
+
+		_, err := adpcm.Compress(pcm, 3, adpcm.LevelMedium)
+		if !errors.Is(err, adpcm.ErrInvalid) {
+			t.Errorf("adpcm.Compress: expected ErrInvalid, got %v", err)
+		}
+	})
+
+	t.Run("Huffman", func(t *testing.T) {
+		data := append([]byte(strings.Repeat("The quick brown fox jumps over the lazy dog. ", 100)), make([]byte, 2000)...)
+		for i := 0; i < 3000; i++ {
+			data = append(data, byte(i*i>>3))
+		}
+
+		for _, cmpType := range []int{0, 1, 2, 3} {
+			compressed, err := comp.SCompCompress(data, comp.Huffman, cmpType, 0)
+			if err != nil {
+				t.Errorf("SCompCompress (type: %d): %v", cmpType, err)
+				return
+			}
+
+			goCompressed, err := huffman.Compress(data, cmpType)
+			if err != nil {
+				t.Errorf("huffman.Compress (type: %d): %v", cmpType, err)
+				return
+			}
+			if comp.Mask(compressed[0]) != comp.Huffman || !bytes.Equal(compressed[1:], goCompressed) {
+				t.Errorf("huffman.Compress (type: %d): output differs from SCompCompress", cmpType)
+				return
+			}
+
+			decompressed, err := huffman.Decompress(compressed[1:], len(data))
+			if err != nil || !bytes.Equal(decompressed, data) {
+				t.Errorf("huffman.Decompress (type: %d): data mismatch, %v", cmpType, err)
+				return
+			}
+		}
+
+		// A decaying chirp, as 16-bit little-endian PCM
+		pcm := make([]byte, 16000)
+		for i := 0; i < 8000; i++ {
+			sample := int16(20000 * math.Exp(-float64(i)/4000) * math.Sin(float64(i*i)/20000))
+			binary.LittleEndian.PutUint16(pcm[2*i:], uint16(sample))
+		}
+
+		for _, cmpLevel := range []int{1, 2, 3, 4} {
+			mask := comp.ADPCMMono | comp.Huffman
+			compressed, err := comp.SCompCompress(pcm, mask, 0, cmpLevel)
+			if err != nil {
+				t.Errorf("SCompCompress (%v, level: %d): %v", mask, cmpLevel, err)
+				return
+			}
+
+			level := adpcm.Level(cmpLevel)
+			goCompressed, err := adpcm.Compress(pcm, 1, level)
+			if err == nil {
+				goCompressed, err = huffman.Compress(goCompressed, huffman.ADPCMType(level))
+			}
+			if err != nil {
+				t.Errorf("huffman.Compress (%v, level: %d): %v", mask, cmpLevel, err)
+				return
+			}
+			if comp.Mask(compressed[0]) != mask || !bytes.Equal(compressed[1:], goCompressed) {
+				t.Errorf("huffman.Compress (%v, level: %d): output differs from SCompCompress", mask, cmpLevel)
+				return
+			}
+
+			decompressed, err := comp.SCompDecompress(compressed, len(pcm))
+			if err != nil {
+				t.Errorf("SCompDecompress (%v, level: %d): %v", mask, cmpLevel, err)
+				return
+			}
+			goDecompressed, err := comp.Decompress(compressed, len(pcm))
+			if err != nil || !bytes.Equal(goDecompressed, decompressed) {
+				t.Errorf("comp.Decompress (%v, level: %d): output differs from SCompDecompress, %v", mask, cmpLevel, err)
+				return
+			}
+		}
+
+		_, err := huffman.Compress(data, 9)
+		if !errors.Is(err, huffman.ErrInvalid) {
+			t.Errorf("huffman.Compress: expected ErrInvalid, got %v", err)
+		}
+
+		compressed, err := huffman.Compress(data, 0)
+		if err == nil {
+			_, err = huffman.Decompress(compressed[:len(compressed)/2], len(data))
+		}
+		if !errors.Is(err, huffman.ErrCorrupt) {
+			t.Errorf("huffman.Decompress: expected ErrCorrupt for truncated data, got %v", err)
+		}
+	})
+
+	t.Run("Pipeline", func(t *testing.T) {
+		data := append([]byte(strings.Repeat("The quick brown fox jumps over the lazy dog. ", 100)), make([]byte, 2000)...)
+
+		for _, mask := range []comp.Mask{comp.Zlib, comp.BZip2, comp.PKWare, comp.Huffman, comp.Sparse, comp.LZMA, comp.Sparse | comp.Zlib, comp.Sparse | comp.BZip2} {
+			compressed, err := comp.Compress(data, mask)
+			if err != nil {
+				t.Errorf("comp.Compress (%v): %v", mask, err)
+				return
+			}
+			if len(compressed) >= len(data) || comp.Mask(compressed[0]) != mask {
+				t.Errorf("comp.Compress (%v): unexpected result of %d bytes with mask %#x", mask, len(compressed), compressed[0])
+				return
+			}
+
+			decompressed, err := comp.SCompDecompress2(compressed, len(data))
+			if err != nil || !bytes.Equal(decompressed, data) {
+				t.Errorf("SCompDecompress2 (%v): data mismatch, %v", mask, err)
+				return
+			}
+
+			compressed, err = comp.SCompCompress(data, mask, 0, 0)
+			if err != nil {
+				t.Errorf("SCompCompress (%v): %v", mask, err)
+				return
+			}
+			decompressed, err = comp.Decompress(compressed, len(data))
+			if err != nil || !bytes.Equal(decompressed, data) {
+				t.Errorf("comp.Decompress (%v): data mismatch, %v", mask, err)
+				return
+			}
+		}
+
+		stored, err := comp.Compress([]byte{1, 2, 3}, comp.Zlib)
+		if err != nil || !bytes.Equal(stored, []byte{1, 2, 3}) {
+			t.Errorf("comp.Compress: expected the data as it is, got %v, %v", stored, err)
+		}
+
+		_, err = comp.Compress(data, comp.Mask(0x04))
+		if !errors.Is(err, comp.ErrNotSupported) {
+			t.Errorf("comp.Compress: expected ErrNotSupported, got %v", err)
+		}
+
+		_, err = comp.Decompress([]byte{byte(comp.Zlib), 1, 2, 3, 4}, len(data))
+		if !errors.Is(err, comp.ErrCorrupt) {
+			t.Errorf("comp.Decompress: expected ErrCorrupt, got %v", err)
+		}
+	})
+
+	t.Run("PureGo", func(t *testing.T) {
+		data := append([]byte(strings.Repeat("The quick brown fox jumps over the lazy dog. ", 1000)), make([]byte, 5000)...)
+		files := map[string]storm.WriteOptions{
+			"stored.txt":           {},
+			"zlib.txt":             {Flags: storm.MPQ_FILE_COMPRESS, Compression: storm.MPQ_COMPRESSION_ZLIB},
+			"bzip2.txt":            {Flags: storm.MPQ_FILE_COMPRESS, Compression: storm.MPQ_COMPRESSION_BZIP2},
+			"implode.txt":          {Flags: storm.MPQ_FILE_IMPLODE},
+			"encrypted.txt":        {Flags: storm.MPQ_FILE_COMPRESS | storm.MPQ_FILE_ENCRYPTED, Compression: storm.MPQ_COMPRESSION_ZLIB},
+			"dir\\fixkey.txt":      {Flags: storm.MPQ_FILE_COMPRESS | storm.MPQ_FILE_ENCRYPTED | storm.MPQ_FILE_FIX_KEY, Compression: storm.MPQ_COMPRESSION_ZLIB},
+			"single.txt":           {Flags: storm.MPQ_FILE_COMPRESS | storm.MPQ_FILE_SINGLE_UNIT, Compression: storm.MPQ_COMPRESSION_ZLIB},
+			"single_encrypted.txt": {Flags: storm.MPQ_FILE_COMPRESS | storm.MPQ_FILE_SINGLE_UNIT | storm.MPQ_FILE_ENCRYPTED, Compression: storm.MPQ_COMPRESSION_ZLIB},
+			"stored_encrypted.txt": {Flags: storm.MPQ_FILE_ENCRYPTED},
+			"huffman.txt":          {Flags: storm.MPQ_FILE_COMPRESS, Compression: storm.MPQ_COMPRESSION_HUFFMANN},
+			"wave.wav":             {Flags: storm.MPQ_FILE_COMPRESS, Compression: storm.MPQ_COMPRESSION_ADPCM_MONO | storm.MPQ_COMPRESSION_HUFFMANN},
+		}
+
+		for _, version := range []uint32{storm.MPQ_FORMAT_VERSION_1, storm.MPQ_FORMAT_VERSION_2, storm.MPQ_FORMAT_VERSION_3, storm.MPQ_FORMAT_VERSION_4} {
+			archivePath := filepath.Join(t.TempDir(), "test.mpq")
+
+			archive, err := storm.SFileCreateArchive2(archivePath, storm.CreateOptions{
+				MpqVersion:   version,
+				FileFlags1:   storm.MPQ_FILE_DEFAULT_INTERNAL,
+				FileFlags2:   storm.MPQ_FILE_DEFAULT_INTERNAL,
+				AttrFlags:    storm.MPQ_ATTRIBUTE_CRC32 | storm.MPQ_ATTRIBUTE_FILETIME,
+				MaxFileCount: 32,
+			})
+			if err != nil {
+				t.Errorf("SFileCreateArchive2 (version %d): %v", version, err)
+				return
+			}
+
+			for name, options := range files {
+				err = archive.CreateFromReader(name, bytes.NewReader(data), uint32(len(data)), options)
+				if err != nil {
+					t.Errorf("Archive.CreateFromReader (version %d, file name: %s): %v", version, name, err)
+					archive.SFileCloseArchive()
+					return
+				}
+			}
+
+			err = archive.SFileCloseArchive()
+			if err != nil {
+				t.Errorf("SFileCloseArchive: %v", err)
+				return
+			}
+
+			archive, err = storm.SFileOpenArchive(archivePath, storm.STREAM_FLAG_READ_ONLY)
+			if err != nil {
+				t.Errorf("SFileOpenArchive (version %d): %v", version, err)
+				return
+			}
+			defer archive.SFileCloseArchive()
+
+			expected, err := archive.ListFiles("*")
+			if err != nil {
+				t.Errorf("Archive.ListFiles (version %d): %v", version, err)
+				return
+			}
+
+			file, err := os.Open(archivePath)
+			if err != nil {
+				t.Errorf("Can't open %s.", archivePath)
+				return
+			}
+			defer file.Close()
+
+			stat, err := file.Stat()
+			if err != nil {
+				t.Errorf("Can't check stat of %s.", archivePath)
+				return
+			}
+
+			pure, err := mpq.Open(file, stat.Size(), mpq.Options{})
+			if err != nil {
+				t.Errorf("mpq.Open (version %d): %v", version, err)
+				return
+			}
+
+			actual := map[string]mpq.Entry{}
+			for finder := pure.Find("*"); ; {
+				entry, ok := finder.Next()
+				if !ok {
+					break
+				}
+				actual[entry.Name] = entry
+			}
+
+			if len(actual) != len(expected) {
+				t.Errorf("mpq.Find (version %d): expected %d files, got %d", version, len(expected), len(actual))
+			}
+
+			for _, findFileData := range expected {
+				entry, ok := actual[findFileData.FileName]
+				if !ok {
+					t.Errorf("mpq.Find (version %d): %s not found", version, findFileData.FileName)
+					continue
+				}
+				if entry.FileSize != findFileData.FileSize || entry.CompSize != findFileData.CompSize || entry.Flags != findFileData.FileFlags ||
+					entry.FileTime != uint64(findFileData.FileTimeHi)<<32|uint64(findFileData.FileTimeLo) {
+					t.Errorf("mpq.Find (version %d): entry mismatch (file name: %s, expected: %+v, actual: %+v)", version, findFileData.FileName, findFileData, entry)
+				}
+
+				if !pure.HasFile(findFileData.FileName) {
+					t.Errorf("Archive.HasFile (version %d): %s not found", version, findFileData.FileName)
+				}
+
+				reader, err := archive.SFileOpenFileEx(findFileData.FileName, storm.SFILE_OPEN_FROM_MPQ)
+				if err != nil {
+					t.Errorf("SFileOpenFileEx (version %d, file name: %s): %v", version, findFileData.FileName, err)
+					continue
+				}
+				expectedData, err := io.ReadAll(reader)
+				reader.SFileCloseFile()
+				if err != nil {
+					t.Errorf("FileReader.Read (version %d, file name: %s): %v", version, findFileData.FileName, err)
+					continue
+				}
+
+				pureFile, err := pure.OpenFile(findFileData.FileName)
+				if err != nil {
+					t.Errorf("mpq.OpenFile (version %d, file name: %s): %v", version, findFileData.FileName, err)
+					continue
+				}
+				actualData := make([]byte, pureFile.Size())
+				_, err = pureFile.ReadAt(actualData, 0)
+				if err != nil || !bytes.Equal(actualData, expectedData) {
+					t.Errorf("mpq.File.ReadAt (version %d, file name: %s): data mismatch, %v", version, findFileData.FileName, err)
+				}
+			}
+
+			if pure.HasFile("missing.txt") {
+				t.Errorf("Archive.HasFile (version %d): missing.txt found", version)
+			}
+			_, err = pure.OpenFile("missing.txt")
+			if !errors.Is(err, mpq.ErrNotFound) {
+				t.Errorf("mpq.OpenFile (version %d): expected ErrNotFound, got %v", version, err)
+			}
+		}
+	})
+
+	// The expected output of the archives in testdata, which the purego backend is tested against
+	t.Run("Testdata", func(t *testing.T) {
+		testGoldens(t, *update)
+	})
+
+	t.Run("RenameRemove", func(t *testing.T) {
+		renameFilePath := filepath.Join(t.TempDir(), "test.mpq")
+
+		archive, err := storm.SFileCreateArchive(renameFilePath, storm.MPQ_CREATE_LISTFILE, 16)
+		if err != nil {
+			t.Errorf("SFileCreateArchive: %v", err)
+			return
+		}
+
+		data := strings.Repeat("Test", 4096)
+		for _, name := range []string{"a.txt", "b.txt"} {
+			err = archive.CreateFromReader(name, strings.NewReader(data), uint32(len(data)), storm.WriteOptions{})
+			if err != nil {
+				t.Errorf("Archive.CreateFromReader: %v", err)
+				return
+			}
+		}
+
+		err = archive.Rename("a.txt", "b.txt")
+		if !errors.Is(err, storm.ErrAlreadyExists) {
+			t.Errorf("Archive.Rename: expected ErrAlreadyExists, got %v", err)
+		}
+
+		err = archive.Rename("missing.txt", "c.txt")
+		if !errors.Is(err, storm.ErrNotFound) {
+			t.Errorf("Archive.Rename: expected ErrNotFound, got %v", err)
+		}
+
+		err = archive.Rename("a.txt", "c.txt")
+		if err != nil {
+			t.Errorf("Archive.Rename: %v", err)
+			return
+		}
+
+		for name, expected := range map[string]bool{"a.txt": false, "b.txt": true, "c.txt": true} {
+			exists, err := archive.SFileHasFile(name)
+			if err != nil {
+				t.Errorf("SFileHasFile: %v", err)
+				return
+			}
+			if exists != expected {
+				t.Errorf("SFileHasFile: wrong result after rename (file name: %s, expected: %v, actual: %v)", name, expected, exists)
+			}
+		}
+
+		err = archive.SFileCloseArchive()
+		if err != nil {
+			t.Errorf("SFileCloseArchive: %v", err)
+			return
+		}
+
+		statBefore, err := os.Stat(renameFilePath)
+		if err != nil {
+			t.Errorf("Can't check stat of %s.", renameFilePath)
+			return
+		}
+
+		archive, err = storm.SFileOpenArchive(renameFilePath, 0)
+		if err != nil {
+			t.Errorf("SFileOpenArchive: %v", err)
+			return
+		}
+
+		err = archive.Remove("c.txt", storm.SFILE_OPEN_FROM_MPQ)
+		if err != nil {
+			t.Errorf("Archive.Remove: %v", err)
+			return
+		}
+
+		err = archive.Remove("c.txt", storm.SFILE_OPEN_FROM_MPQ)
+		if !errors.Is(err, storm.ErrNotFound) {
+			t.Errorf("Archive.Remove: expected ErrNotFound, got %v", err)
+		}
+
+		err = archive.SFileCompactArchive(nil)
+		if err != nil {
+			t.Errorf("SFileCompactArchive: %v", err)
+			return
+		}
+
+		err = archive.SFileCloseArchive()
+		if err != nil {
+			t.Errorf("SFileCloseArchive: %v", err)
+			return
+		}
+
+		statAfter, err := os.Stat(renameFilePath)
+		if err != nil {
+			t.Errorf("Can't check stat of %s.", renameFilePath)
+			return
+		}
+		if statAfter.Size() >= statBefore.Size() {
+			t.Errorf("File size not reduced after removing and compacting. (before: %d, after: %d)", statBefore.Size(), statAfter.Size())
+		}
+
+		archive, err = storm.SFileOpenArchive(renameFilePath, storm.STREAM_FLAG_READ_ONLY)
+		if err != nil {
+			t.Errorf("SFileOpenArchive: %v", err)
+			return
+		}
+
+		err = archive.Remove("b.txt", storm.SFILE_OPEN_FROM_MPQ)
+		if !errors.Is(err, storm.ErrReadOnly) {
+			t.Errorf("Archive.Remove: expected ErrReadOnly, got %v", err)
+		}
+
+		reader, err := archive.SFileOpenFileEx("b.txt", storm.SFILE_OPEN_FROM_MPQ)
+		if err != nil {
+			t.Errorf("SFileOpenFileEx: %v", err)
+			return
+		}
+
+		err = reader.SetLocale(storm.LocaleDeDE)
+		if !errors.Is(err, storm.ErrReadOnly) {
+			t.Errorf("FileReader.SetLocale: expected ErrReadOnly, got %v", err)
+		}
+
+		err = reader.SFileCloseFile()
+		if err != nil {
+			t.Errorf("SFileCloseFile: %v", err)
+			return
+		}
+
+		err = archive.SFileCloseArchive()
+		if err != nil {
+			t.Errorf("SFileCloseArchive: %v", err)
+			return
+		}
+	})
+
+	err = os.Remove(mpqFilePath)
+	if err != nil {
+		t.Errorf("Failed to remove %s. Error: %v", mpqFilePath, err)
+		return
+	}
+}
+
+// Writer which accepts only the first byte of every write without reporting an error.
+type shortWriter struct{}
+
+func (shortWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return 1, nil
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
 	}
+	return b
 }
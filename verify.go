@@ -0,0 +1,458 @@
+//go:build !purego
+
+package storm
+
+// #include "lasterror.h"
+import "C"
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unsafe"
+)
+
+// Result of verifying a file, a combination of VERIFY_* flags.
+type VerifyResult uint32
+
+func (r VerifyResult) OpenFailed() bool      { return uint32(r)&VERIFY_OPEN_ERROR != 0 }
+func (r VerifyResult) ReadFailed() bool      { return uint32(r)&VERIFY_READ_ERROR != 0 }
+func (r VerifyResult) HasSectorCRC() bool    { return uint32(r)&VERIFY_FILE_HAS_SECTOR_CRC != 0 }
+func (r VerifyResult) SectorCRCFailed() bool { return uint32(r)&VERIFY_FILE_SECTOR_CRC_ERROR != 0 }
+func (r VerifyResult) HasCRC32() bool        { return uint32(r)&VERIFY_FILE_HAS_CHECKSUM != 0 }
+func (r VerifyResult) CRC32Failed() bool     { return uint32(r)&VERIFY_FILE_CHECKSUM_ERROR != 0 }
+func (r VerifyResult) HasMD5() bool          { return uint32(r)&VERIFY_FILE_HAS_MD5 != 0 }
+func (r VerifyResult) MD5Failed() bool       { return uint32(r)&VERIFY_FILE_MD5_ERROR != 0 }
+func (r VerifyResult) HasRawMD5() bool       { return uint32(r)&VERIFY_FILE_HAS_RAW_MD5 != 0 }
+func (r VerifyResult) RawMD5Failed() bool    { return uint32(r)&VERIFY_FILE_RAW_MD5_ERROR != 0 }
+
+// Reports whether any of the checks failed.
+func (r VerifyResult) Failed() bool {
+	return uint32(r)&VERIFY_FILE_ERROR_MASK != 0
+}
+
+// Returns a *VerifyError listing the failed checks, or nil if all checks passed.
+func (r VerifyResult) Err() error {
+	if !r.Failed() {
+		return nil
+	}
+
+	return &VerifyError{Result: r}
+}
+
+// Error describing the checks which failed when verifying a file.
+type VerifyError struct {
+	FileName string       // Name of the verified file (empty if not known)
+	Result   VerifyResult // Result of the verification
+}
+
+// Implementation of the error interface.
+func (err *VerifyError) Error() string {
+	var failed []string
+
+	r := err.Result
+	if r.OpenFailed() {
+		failed = append(failed, "file could not be opened")
+	}
+	if r.ReadFailed() {
+		failed = append(failed, "file could not be read")
+	}
+	if r.SectorCRCFailed() {
+		failed = append(failed, "sector CRC mismatch")
+	}
+	if r.CRC32Failed() {
+		failed = append(failed, "CRC32 mismatch")
+	}
+	if r.MD5Failed() {
+		failed = append(failed, "MD5 mismatch")
+	}
+	if r.RawMD5Failed() {
+		failed = append(failed, "raw data MD5 mismatch")
+	}
+
+	if err.FileName != "" {
+		return fmt.Sprintf("storm: verification of %s failed: %s", err.FileName, strings.Join(failed, ", "))
+	}
+	return fmt.Sprintf("storm: verification failed: %s", strings.Join(failed, ", "))
+}
+
+// Result of verifying the digital signature of an archive, one of ERROR_*_SIGNATURE_* or ERROR_NO_SIGNATURE.
+type SignatureResult uint32
+
+// Reports whether the archive has a signature.
+func (r SignatureResult) Signed() bool {
+	return uint32(r) != ERROR_NO_SIGNATURE
+}
+
+// Returns an error if the signature could not be verified or did not match, or nil otherwise.
+func (r SignatureResult) Err() error {
+	switch uint32(r) {
+	case ERROR_NO_SIGNATURE, ERROR_WEAK_SIGNATURE_OK, ERROR_STRONG_SIGNATURE_OK:
+		return nil
+	}
+
+	return fmt.Errorf("storm: %s", r)
+}
+
+func (r SignatureResult) String() string {
+	switch uint32(r) {
+	case ERROR_NO_SIGNATURE:
+		return "no signature"
+	case ERROR_VERIFY_FAILED:
+		return "signature verification failed"
+	case ERROR_WEAK_SIGNATURE_OK:
+		return "weak signature is valid"
+	case ERROR_WEAK_SIGNATURE_ERROR:
+		return "weak signature mismatch"
+	case ERROR_STRONG_SIGNATURE_OK:
+		return "strong signature is valid"
+	case ERROR_STRONG_SIGNATURE_ERROR:
+		return "strong signature mismatch"
+	}
+
+	return fmt.Sprintf("SignatureResult(%d)", uint32(r))
+}
+
+// Verifies a file against its extended attributes, see SFILE_VERIFY_*.
+//
+// An error is returned only if the file could not be opened; use the Err method of the result for failed checks.
+func (a *Archive) VerifyFile(fileName string, flags uint32) (VerifyResult, error) {
+	result, err := a.SFileVerifyFile(fileName, flags)
+	return VerifyResult(result), err
+}
+
+// Verifies the digital signature of the archive. An error is returned only if the archive is closed.
+func (a *Archive) VerifySignature() (SignatureResult, error) {
+	err := a.lock("verify signature")
+	if err != nil {
+		return 0, err
+	}
+	defer a.unlock()
+
+	return SignatureResult(C.shimSFileVerifyArchive(a.handle)), nil
+}
+
+// Calculates the CRC32 and MD5 of a file's data.
+func (a *Archive) Checksums(fileName string) (crc32 uint32, md5 [16]byte, err error) {
+	err = a.lock("checksum")
+	if err != nil {
+		return 0, md5, err
+	}
+	defer a.unlock()
+
+	var cCrc32 C.DWORD
+
+	cFileName := C.CString(fileName)
+	defer C.free(unsafe.Pointer(cFileName))
+
+	var errorCode C.DWORD
+	if C.shimSFileGetFileChecksums(a.handle, cFileName, &cCrc32, (*C.char)(unsafe.Pointer(&md5[0])), &errorCode) != 0 {
+		return uint32(cCrc32), md5, nil
+	}
+
+	return 0, md5, newPathError(uint32(errorCode), "checksum", fileName)
+}
+
+// Verifies the raw data of the archive against its MD5 checksums, see SFILE_VERIFY_*. Only MPQs version 4 or newer have them.
+//
+// The file name is used for SFILE_VERIFY_FILE only. ERROR_FILE_CORRUPT is returned if the data does not match.
+func (a *Archive) VerifyRawData(whatToVerify uint32, fileName string) error {
+	err := a.lock("verify raw data")
+	if err != nil {
+		return err
+	}
+	defer a.unlock()
+
+	var cFileName *C.char
+
+	if fileName != "" {
+		cFileName = C.CString(fileName)
+		defer C.free(unsafe.Pointer(cFileName))
+	}
+
+	result := uint32(C.shimSFileVerifyRawData(a.handle, C.DWORD(whatToVerify), cFileName))
+	if result == ERROR_SUCCESS {
+		return nil
+	}
+
+	return newPathError(result, "verify raw data", a.name)
+}
+
+// Options for verifying a whole archive.
+type VerifyOptions struct {
+	Mask          string // Mask of the files to verify ("*" if empty)
+	ListFile      string // List file used to resolve file names, without adding it to the archive (none if empty)
+	FileFlags     uint32 // Checks to run on each file, see SFILE_VERIFY_* (SFILE_VERIFY_ALL if 0)
+	SkipRawData   bool   // Skip verifying the raw data of the MPQ header, the tables and the files
+	SkipSignature bool   // Skip verifying the digital signature
+}
+
+// Report of verifying a whole archive.
+type VerifyReport struct {
+	Files       []FileVerifyReport `json:"files"`                 // Results for each file found
+	RawData     []RawDataReport    `json:"rawData,omitempty"`     // Results for the raw data of the MPQ header and tables
+	Signature   SignatureResult    `json:"signature"`             // Result of verifying the digital signature
+	Totals      VerifyTotals       `json:"totals"`                // Counts of the results
+	FirstErrors map[string]string  `json:"firstErrors,omitempty"` // The first error of each category, such as "md5" or "signature"
+}
+
+// Result of verifying a single file.
+type FileVerifyReport struct {
+	Name      string       `json:"name"`            // Name of the file, or a FileXXXXXXXX.xxx name if not known
+	NameKnown bool         `json:"nameKnown"`       // The name was resolved from a list file
+	Result    VerifyResult `json:"result"`          // Combination of VERIFY_* flags
+	Error     string       `json:"error,omitempty"` // Description of the failed checks (empty if passed)
+}
+
+// Result of verifying the raw data of the MPQ header or a table.
+type RawDataReport struct {
+	What  string `json:"what"`            // Verified part, such as "hash table"
+	Error string `json:"error,omitempty"` // Error (empty if passed)
+}
+
+// Counts of the results of verifying an archive.
+type VerifyTotals struct {
+	Files           int    `json:"files"`           // Number of files found
+	ExpectedFiles   uint32 `json:"expectedFiles"`   // Number of files according to the archive
+	UnknownNames    int    `json:"unknownNames"`    // Files whose names could not be resolved
+	Passed          int    `json:"passed"`          // Files passing all checks
+	Failed          int    `json:"failed"`          // Files failing at least one check
+	OpenErrors      int    `json:"openErrors"`      // Files which could not be opened
+	ReadErrors      int    `json:"readErrors"`      // Files which could not be read completely
+	SectorCRCErrors int    `json:"sectorCrcErrors"` // Files with sector CRC mismatches
+	CRC32Errors     int    `json:"crc32Errors"`     // Files with CRC32 mismatches
+	MD5Errors       int    `json:"md5Errors"`       // Files with MD5 mismatches
+	RawMD5Errors    int    `json:"rawMd5Errors"`    // Files with raw data MD5 mismatches
+	RawDataErrors   int    `json:"rawDataErrors"`   // MPQ header and tables with raw data mismatches
+}
+
+// Reports whether every check passed and all files of the archive were found.
+func (r *VerifyReport) OK() bool {
+	return len(r.FirstErrors) == 0
+}
+
+// Records the first error of a category.
+func (r *VerifyReport) addError(category string, message string) {
+	if r.FirstErrors == nil {
+		r.FirstErrors = make(map[string]string)
+	}
+	if _, ok := r.FirstErrors[category]; !ok {
+		r.FirstErrors[category] = message
+	}
+}
+
+// Verifies every file found in the archive, the raw data of the MPQ header, the tables and the files, and the digital
+// signature.
+//
+// Files with unknown names are verified through their FileXXXXXXXX.xxx names. If the context is canceled, the partial report is returned with the error of the context.
+func (a *Archive) Verify(ctx context.Context, options VerifyOptions) (*VerifyReport, error) {
+	var report VerifyReport
+	var names []FileFindData
+
+	if options.Mask == "" {
+		options.Mask = "*"
+	}
+	if options.FileFlags == 0 {
+		options.FileFlags = SFILE_VERIFY_ALL
+	}
+
+	info, err := a.Info()
+	if err != nil {
+		return nil, err
+	}
+	report.Totals.ExpectedFiles = info.NumberOfFiles
+
+	err = a.forEachFile(options.Mask, func(findFileData *FileFindData) {
+		names = append(names, *findFileData)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if options.ListFile != "" {
+		names, err = a.resolveFileNames(names, options.ListFile, options.Mask)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Only MPQs version 4 or newer have MD5 checksums of the raw data
+	rawData := !options.SkipRawData && info.RawChunkSize != 0
+
+	for _, findFileData := range names {
+		if err := ctx.Err(); err != nil {
+			return &report, err
+		}
+		report.verifyFile(a, findFileData.FileName, options.FileFlags, rawData)
+	}
+
+	if options.Mask == "*" && uint32(report.Totals.Files) < info.NumberOfFiles {
+		report.addError("enumeration", fmt.Sprintf("found %d of %d files", report.Totals.Files, info.NumberOfFiles))
+	}
+
+	if rawData {
+		report.verifyRawData(a, SFILE_VERIFY_MPQ_HEADER, "MPQ header")
+		if info.HetTableOffset != 0 {
+			report.verifyRawData(a, SFILE_VERIFY_HET_TABLE, "HET table")
+		}
+		if info.BetTableOffset != 0 {
+			report.verifyRawData(a, SFILE_VERIFY_BET_TABLE, "BET table")
+		}
+		if info.HashTableOffset != 0 {
+			report.verifyRawData(a, SFILE_VERIFY_HASH_TABLE, "hash table")
+		}
+		if info.BlockTableOffset != 0 {
+			report.verifyRawData(a, SFILE_VERIFY_BLOCK_TABLE, "block table")
+		}
+		if info.HiBlockTableOffset != 0 {
+			report.verifyRawData(a, SFILE_VERIFY_HIBLOCK_TABLE, "hi-block table")
+		}
+	}
+
+	if !options.SkipSignature {
+		report.Signature, err = a.VerifySignature()
+		if err != nil {
+			return nil, err
+		}
+		if err := report.Signature.Err(); err != nil {
+			report.addError("signature", err.Error())
+		}
+	}
+
+	return &report, nil
+}
+
+// Replaces the made-up names of the files with the names from a list file on the local drive, without adding the list file
+// to the archive. Files matching the mask whose made-up names did not match it are appended.
+func (a *Archive) resolveFileNames(files []FileFindData, listFile string, mask string) ([]FileFindData, error) {
+	known := make(map[string]bool)
+	unknown := make(map[uint32]int) // Positions of the files with made-up names, by block index
+
+	for i, findFileData := range files {
+		if isPseudoFileName(findFileData.FileName) {
+			unknown[findFileData.BlockIndex] = i
+		} else {
+			known[listFileKey(findFileData.FileName)] = true
+		}
+	}
+
+	err := a.resolveListFile(listFile, mask, func(fileName string) error {
+		if known[listFileKey(fileName)] {
+			return nil
+		}
+		known[listFileKey(fileName)] = true
+
+		reader, err := a.SFileOpenFileEx(fileName, SFILE_OPEN_FROM_MPQ)
+		if err != nil {
+			return err
+		}
+		defer reader.SFileCloseFile()
+
+		info, err := reader.Info()
+		if err != nil {
+			return err
+		}
+
+		if i, ok := unknown[info.FileIndex]; ok {
+			files[i].FileName = fileName
+			delete(unknown, info.FileIndex)
+		} else {
+			files = append(files, FileFindData{FileName: fileName, BlockIndex: info.FileIndex})
+		}
+		return nil
+	})
+
+	return files, err
+}
+
+// Verifies a file, and the MD5 checksums of its raw data if rawData is set.
+func (r *VerifyReport) verifyFile(a *Archive, fileName string, flags uint32, rawData bool) {
+	file := FileVerifyReport{
+		Name:      fileName,
+		NameKnown: !isPseudoFileName(fileName),
+	}
+
+	r.Totals.Files++
+	if !file.NameKnown {
+		r.Totals.UnknownNames++
+	}
+
+	result, err := a.VerifyFile(fileName, flags)
+	file.Result = result
+	if err == nil {
+		err = result.Err()
+	}
+	if err != nil {
+		file.Error = err.Error()
+		if verifyErr, ok := err.(*VerifyError); ok {
+			verifyErr.FileName = fileName
+			file.Error = verifyErr.Error()
+		}
+	}
+
+	var rawErr error
+	if rawData && !result.OpenFailed() {
+		rawErr = a.VerifyRawData(SFILE_VERIFY_FILE, fileName)
+		if rawErr != nil {
+			if file.Error != "" {
+				file.Error += "; "
+			}
+			file.Error += "raw data: " + rawErr.Error()
+		}
+	}
+
+	if result.Failed() || err != nil || rawErr != nil {
+		r.Totals.Failed++
+	} else {
+		r.Totals.Passed++
+	}
+
+	categories := []struct {
+		failed   bool
+		count    *int
+		category string
+	}{
+		{result.OpenFailed(), &r.Totals.OpenErrors, "open"},
+		{result.ReadFailed(), &r.Totals.ReadErrors, "read"},
+		{result.SectorCRCFailed(), &r.Totals.SectorCRCErrors, "sectorCrc"},
+		{result.CRC32Failed(), &r.Totals.CRC32Errors, "crc32"},
+		{result.MD5Failed(), &r.Totals.MD5Errors, "md5"},
+		{result.RawMD5Failed() || rawErr != nil, &r.Totals.RawMD5Errors, "rawMd5"},
+	}
+	for _, c := range categories {
+		if c.failed {
+			*c.count++
+			r.addError(c.category, file.Error)
+		}
+	}
+
+	r.Files = append(r.Files, file)
+}
+
+func (r *VerifyReport) verifyRawData(a *Archive, whatToVerify uint32, what string) {
+	rawData := RawDataReport{What: what}
+
+	err := a.VerifyRawData(whatToVerify, "")
+	if err != nil {
+		rawData.Error = err.Error()
+		r.Totals.RawDataErrors++
+		r.addError("rawData", fmt.Sprintf("%s: %v", what, err))
+	}
+
+	r.RawData = append(r.RawData, rawData)
+}
+
+// Reports whether the name is one StormLib makes up for files not in any list file, such as File00000012.xxx.
+func isPseudoFileName(fileName string) bool {
+	if len(fileName) < 13 || !strings.HasPrefix(fileName, "File") || fileName[12] != '.' {
+		return false
+	}
+
+	for _, c := range fileName[4:12] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return !strings.ContainsAny(fileName, "\\/")
+}